@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Bloom filter answering "definitely not present" or "maybe present"
+type Bloom struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// Creates a bloom filter sized for the expected items and false positive rate
+func NewBloom(items int, rate float64) *Bloom {
+	if items < 1 {
+		items = 1
+	}
+	// Optimal size and number of hash functions
+	size := math.Ceil(-float64(items) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	hashes := math.Max(1, math.Round(size/float64(items)*math.Ln2))
+	return &Bloom{
+		bits:   make([]uint64, (uint64(size)+63)/64),
+		hashes: uint64(hashes),
+	}
+}
+
+// Adds an item
+func (b *Bloom) Add(item string) {
+	h1, h2 := b.hash(item)
+	size := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Tests whether an item might have been added
+func (b *Bloom) Test(item string) bool {
+	h1, h2 := b.hash(item)
+	size := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Double hashing from the two halves of a 128 bit fnv hash
+func (b *Bloom) hash(item string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(item))
+	sum := h.Sum(nil)
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[i+8])
+	}
+	// An odd step visits every bit
+	return h1, h2 | 1
+}
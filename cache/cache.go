@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Reads every still valid revocation from the database
+type Loader func(ctx context.Context) (tokens map[string]time.Time, accounts map[int]time.Time, err error)
+
+// A cache of revoked jwt token ids and account-wide invalidations sitting in front of the blacklist
+type Revocations interface {
+	// Reports whether a token is revoked, known is false when the database has to be asked
+	Lookup(ctx context.Context, tokenID string) (revoked bool, known bool, err error)
+	// Records a revoked token
+	Revoke(ctx context.Context, tokenID string, expiration time.Time) error
+	// Records a token the database didn't know about when asked, ignored if a revocation reached
+	// the cache since then so a token revoked in the meantime isn't taken as valid
+	Miss(ctx context.Context, tokenID string, asked time.Time) error
+	// Reports since when an account's tokens are revoked, zero if they aren't
+	LookupAccount(ctx context.Context, account int) (revoked time.Time, known bool, err error)
+	// Records an account-wide invalidation
+	RevokeAccount(ctx context.Context, account int, revoked time.Time) error
+	// Replaces the cached revocations with the ones the loader reads, revocations recorded while
+	// it runs are kept since the loader might have read the database before them
+	Load(ctx context.Context, load Loader) error
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// In-process revocation cache, a bloom filter of revoked tokens answers most lookups
+// and an lru of tokens known not to be revoked absorbs its false positives
+type Local struct {
+	mu       sync.Mutex
+	size     int
+	bloom    *Bloom
+	negative *LRU
+	accounts map[int]time.Time
+	changed  time.Time // When a revocation last reached the cache, misses asked before it are ignored
+	// Revocations recorded while loads run, replayed into what they load
+	loading         int
+	pending         map[string]struct{}
+	pendingAccounts map[int]time.Time
+}
+
+// Creates an in-process cache sized for the expected revoked tokens
+func NewLocal(size int) *Local {
+	return &Local{
+		size:     size,
+		negative: NewLRU(size),
+	}
+}
+
+func (l *Local) Lookup(ctx context.Context, tokenID string) (bool, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Asking the database until the first load
+	if l.bloom == nil {
+		return false, false, nil
+	}
+	if !l.bloom.Test(tokenID) || l.negative.Contains(tokenID) {
+		return false, true, nil
+	}
+	return false, false, nil
+}
+
+func (l *Local) Revoke(ctx context.Context, tokenID string, expiration time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.bloom != nil {
+		l.bloom.Add(tokenID)
+	}
+	if l.loading > 0 {
+		l.pending[tokenID] = struct{}{}
+	}
+	l.negative.Remove(tokenID)
+	l.changed = time.Now()
+	return nil
+}
+
+func (l *Local) Miss(ctx context.Context, tokenID string, asked time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !asked.After(l.changed) {
+		return nil
+	}
+	l.negative.Add(tokenID)
+	return nil
+}
+
+func (l *Local) LookupAccount(ctx context.Context, account int) (time.Time, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.accounts == nil {
+		return time.Time{}, false, nil
+	}
+	return l.accounts[account], true, nil
+}
+
+func (l *Local) RevokeAccount(ctx context.Context, account int, revoked time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.accounts != nil && revoked.After(l.accounts[account]) {
+		l.accounts[account] = revoked
+	}
+	if l.loading > 0 && revoked.After(l.pendingAccounts[account]) {
+		l.pendingAccounts[account] = revoked
+	}
+	return nil
+}
+
+func (l *Local) Load(ctx context.Context, load Loader) error {
+	l.mu.Lock()
+	if l.loading == 0 {
+		l.pending = make(map[string]struct{})
+		l.pendingAccounts = make(map[int]time.Time)
+	}
+	l.loading++
+	l.mu.Unlock()
+	tokens, accounts, err := load(ctx)
+	var bloom *Bloom
+	if err == nil {
+		// Rebuilding the filter drops expired tokens which can't be removed from it
+		size := l.size
+		if len(tokens) > size {
+			size = len(tokens)
+		}
+		bloom = NewBloom(size, 0.01)
+		for tokenID := range tokens {
+			bloom.Add(tokenID)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loading--
+	pending, pendingAccounts := l.pending, l.pendingAccounts
+	if l.loading == 0 {
+		l.pending, l.pendingAccounts = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	if accounts == nil {
+		accounts = make(map[int]time.Time)
+	}
+	// Replaying revocations the loader might have missed
+	for tokenID := range pending {
+		bloom.Add(tokenID)
+	}
+	for account, revoked := range pendingAccounts {
+		if revoked.After(accounts[account]) {
+			accounts[account] = revoked
+		}
+	}
+	l.bloom = bloom
+	l.accounts = accounts
+	l.changed = time.Now()
+	// Forgetting negatives revoked elsewhere in the meantime
+	for tokenID := range tokens {
+		l.negative.Remove(tokenID)
+	}
+	for tokenID := range pending {
+		l.negative.Remove(tokenID)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Loads the given revocations once
+func loaded(tokens map[string]time.Time, accounts map[int]time.Time) Loader {
+	return func(ctx context.Context) (map[string]time.Time, map[int]time.Time, error) {
+		return tokens, accounts, nil
+	}
+}
+
+func lookup(t *testing.T, l *Local, tokenID string) (bool, bool) {
+	t.Helper()
+	revoked, known, err := l.Lookup(context.Background(), tokenID)
+	if err != nil {
+		t.Fatalf("lookup %s: %v", tokenID, err)
+	}
+	return revoked, known
+}
+
+func TestLocalUnknownUntilLoaded(t *testing.T) {
+	l := NewLocal(100)
+	if _, known := lookup(t, l, "a"); known {
+		t.Fatal("lookup before the first load should ask the database")
+	}
+	if _, known, _ := l.LookupAccount(context.Background(), 1); known {
+		t.Fatal("account lookup before the first load should ask the database")
+	}
+}
+
+func TestLocalLookup(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(100)
+	expiration := time.Now().Add(time.Hour)
+	if err := l.Load(ctx, loaded(map[string]time.Time{"revoked": expiration}, map[int]time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, known := lookup(t, l, "valid"); revoked || !known {
+		t.Fatalf("token outside the filter: revoked %v known %v, want not revoked and known", revoked, known)
+	}
+	// Filter hits always go to the database which has the last word
+	if _, known := lookup(t, l, "revoked"); known {
+		t.Fatal("token in the filter should ask the database")
+	}
+	// Until the database says it isn't revoked
+	if err := l.Miss(ctx, "revoked", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, known := lookup(t, l, "revoked"); revoked || !known {
+		t.Fatal("a miss should be remembered")
+	}
+	// And a revocation forgets the miss
+	if err := l.Revoke(ctx, "revoked", expiration); err != nil {
+		t.Fatal(err)
+	}
+	if _, known := lookup(t, l, "revoked"); known {
+		t.Fatal("a revocation should forget the miss")
+	}
+}
+
+func TestLocalMissDoesNotOverrideRevoke(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(100)
+	if err := l.Load(ctx, loaded(map[string]time.Time{}, map[int]time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	// The database is asked, the token gets revoked and only then the miss is recorded
+	asked := time.Now()
+	if err := l.Revoke(ctx, "raced", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Miss(ctx, "raced", asked); err != nil {
+		t.Fatal(err)
+	}
+	if _, known := lookup(t, l, "raced"); known {
+		t.Fatal("a miss asked before a revocation shouldn't be recorded")
+	}
+}
+
+func TestLocalMissDoesNotOverrideLoad(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(100)
+	asked := time.Now()
+	// The token was revoked by another instance whose notification got lost, the refresh catches it
+	if err := l.Load(ctx, loaded(map[string]time.Time{"raced": time.Now().Add(time.Hour)}, map[int]time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Miss(ctx, "raced", asked); err != nil {
+		t.Fatal(err)
+	}
+	if _, known := lookup(t, l, "raced"); known {
+		t.Fatal("a miss asked before a load shouldn't be recorded")
+	}
+}
+
+func TestLocalLoadReplaysConcurrentRevocations(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(100)
+	expiration := time.Now().Add(time.Hour)
+	revoked := time.Now().Truncate(time.Second)
+	if err := l.Load(ctx, loaded(map[string]time.Time{}, map[int]time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Miss(ctx, "raced", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// Revocations arriving after the loader read the database
+	err := l.Load(ctx, func(ctx context.Context) (map[string]time.Time, map[int]time.Time, error) {
+		if err := l.Revoke(ctx, "raced", expiration); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.RevokeAccount(ctx, 7, revoked); err != nil {
+			t.Fatal(err)
+		}
+		return map[string]time.Time{}, map[int]time.Time{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, known := lookup(t, l, "raced"); known {
+		t.Fatal("a token revoked during the load was dropped from the filter")
+	}
+	since, known, err := l.LookupAccount(ctx, 7)
+	if err != nil || !known || !since.Equal(revoked) {
+		t.Fatalf("account revoked during the load: %v %v %v, want %v", since, known, err, revoked)
+	}
+}
+
+func TestLocalOverlappingLoads(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(100)
+	// A slow load started before a revocation finishes after a faster one
+	err := l.Load(ctx, func(ctx context.Context) (map[string]time.Time, map[int]time.Time, error) {
+		if err := l.Revoke(ctx, "raced", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Load(ctx, loaded(map[string]time.Time{}, map[int]time.Time{})); err != nil {
+			t.Fatal(err)
+		}
+		return map[string]time.Time{}, map[int]time.Time{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, known := lookup(t, l, "raced"); known {
+		t.Fatal("the slower load dropped a revocation")
+	}
+	// Revocations outside loads aren't kept around
+	if l.pending != nil || l.loading != 0 {
+		t.Fatal("pending revocations should be dropped once no load runs")
+	}
+}
+
+func TestLocalAccountRevocationsOnlyMoveForward(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(100)
+	later := time.Now().Truncate(time.Second)
+	earlier := later.Add(-time.Hour)
+	if err := l.Load(ctx, loaded(map[string]time.Time{}, map[int]time.Time{1: later})); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RevokeAccount(ctx, 1, earlier); err != nil {
+		t.Fatal(err)
+	}
+	since, _, _ := l.LookupAccount(ctx, 1)
+	if !since.Equal(later) {
+		t.Fatalf("account revoked since %v, want %v", since, later)
+	}
+}
@@ -0,0 +1,50 @@
+package cache
+
+import "container/list"
+
+// Fixed size set evicting the least recently used item
+type LRU struct {
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+// Creates an lru set holding up to size items
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Adds an item evicting the oldest one if full
+func (l *LRU) Add(item string) {
+	if element, ok := l.items[item]; ok {
+		l.order.MoveToFront(element)
+		return
+	}
+	l.items[item] = l.order.PushFront(item)
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(string))
+	}
+}
+
+// Reports whether an item is present marking it as recently used
+func (l *LRU) Contains(item string) bool {
+	element, ok := l.items[item]
+	if ok {
+		l.order.MoveToFront(element)
+	}
+	return ok
+}
+
+// Removes an item
+func (l *LRU) Remove(item string) {
+	if element, ok := l.items[item]; ok {
+		l.order.Remove(element)
+		delete(l.items, item)
+	}
+}
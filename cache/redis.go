@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/0xalby/based/database/redis"
+)
+
+// Revocation cache shared between instances, revoked tokens are keys expiring along with them. Only
+// revocations are trusted since a key can be evicted or fail to be written, the database is asked
+// about anything missing
+type Redis struct {
+	Client *redis.Client
+	Prefix string // Key prefix(example "based:")
+}
+
+func (r *Redis) Lookup(ctx context.Context, tokenID string) (bool, bool, error) {
+	reply, err := r.Client.Do(ctx, "EXISTS", r.Prefix+"token:"+tokenID)
+	if err != nil {
+		return false, false, err
+	}
+	count, _ := reply.(int64)
+	if count == 0 {
+		return false, false, nil
+	}
+	return true, true, nil
+}
+
+func (r *Redis) Revoke(ctx context.Context, tokenID string, expiration time.Time) error {
+	_, err := r.Client.Do(ctx, r.set(tokenID, expiration)...)
+	return err
+}
+
+func (r *Redis) Miss(ctx context.Context, tokenID string, asked time.Time) error {
+	return nil
+}
+
+func (r *Redis) LookupAccount(ctx context.Context, account int) (time.Time, bool, error) {
+	reply, err := r.Client.Do(ctx, "ZSCORE", r.Prefix+"accounts", strconv.Itoa(account))
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	// Scores are floats, exact for microseconds until the year 2255
+	micro, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMicro(int64(micro)), true, nil
+}
+
+func (r *Redis) RevokeAccount(ctx context.Context, account int, revoked time.Time) error {
+	_, err := r.Client.Do(ctx, r.setAccount(account, revoked)...)
+	return err
+}
+
+func (r *Redis) Load(ctx context.Context, load Loader) error {
+	// Writes never remove a key so revocations recorded meanwhile survive
+	tokens, accounts, err := load(ctx)
+	if err != nil {
+		return err
+	}
+	commands := make([][]string, 0, len(tokens)+len(accounts))
+	for tokenID, expiration := range tokens {
+		commands = append(commands, r.set(tokenID, expiration))
+	}
+	for account, revoked := range accounts {
+		commands = append(commands, r.setAccount(account, revoked))
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	replies, err := r.Client.Pipeline(ctx, commands)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Builds a command storing a token until it expires
+func (r *Redis) set(tokenID string, expiration time.Time) []string {
+	return []string{"SET", r.Prefix + "token:" + tokenID, "1", "PXAT", strconv.FormatInt(expiration.UnixMilli(), 10)}
+}
+
+// Builds a command storing an account-wide invalidation unless a later one is stored already
+func (r *Redis) setAccount(account int, revoked time.Time) []string {
+	return []string{"ZADD", r.Prefix + "accounts", "GT", strconv.FormatInt(revoked.UnixMicro(), 10), strconv.Itoa(account)}
+}
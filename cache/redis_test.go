@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0xalby/based/database/redis"
+	"github.com/0xalby/based/database/redis/redistest"
+)
+
+// Creates a cache backed by an in-process Redis stand-in
+func newRedis(t *testing.T) (*Redis, *redistest.Server) {
+	t.Helper()
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &redis.Client{Address: server.Addr}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return &Redis{Client: client, Prefix: "test:"}, server
+}
+
+func TestRedisLookup(t *testing.T) {
+	ctx := context.Background()
+	r, server := newRedis(t)
+	if err := r.Revoke(ctx, "revoked", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	revoked, known, err := r.Lookup(ctx, "revoked")
+	if err != nil || !revoked || !known {
+		t.Fatalf("revoked token: revoked %v known %v err %v", revoked, known, err)
+	}
+	// Missing keys are never taken as valid tokens
+	if _, known, err := r.Lookup(ctx, "valid"); err != nil || known {
+		t.Fatalf("missing token should ask the database, known %v err %v", known, err)
+	}
+	if err := r.Miss(ctx, "valid", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, known, _ := r.Lookup(ctx, "valid"); known {
+		t.Fatal("misses shouldn't be cached in redis")
+	}
+	// Even when the revocation was evicted
+	server.Evict("test:token:revoked")
+	if _, known, err := r.Lookup(ctx, "revoked"); err != nil || known {
+		t.Fatalf("evicted token should ask the database, known %v err %v", known, err)
+	}
+}
+
+func TestRedisTokensExpire(t *testing.T) {
+	ctx := context.Background()
+	r, server := newRedis(t)
+	if err := r.Revoke(ctx, "expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists("test:token:expired") {
+		t.Fatal("revoked tokens should expire along with them")
+	}
+}
+
+func TestRedisAccounts(t *testing.T) {
+	ctx := context.Background()
+	r, server := newRedis(t)
+	if _, known, err := r.LookupAccount(ctx, 1); err != nil || known {
+		t.Fatalf("missing account should ask the database, known %v err %v", known, err)
+	}
+	later := time.Now().Truncate(time.Microsecond)
+	earlier := later.Add(-time.Hour)
+	if err := r.RevokeAccount(ctx, 1, later); err != nil {
+		t.Fatal(err)
+	}
+	// A load reading the database before the revocation can't move it back
+	if err := r.Load(ctx, loaded(map[string]time.Time{}, map[int]time.Time{1: earlier})); err != nil {
+		t.Fatal(err)
+	}
+	since, known, err := r.LookupAccount(ctx, 1)
+	if err != nil || !known || !since.Equal(later) {
+		t.Fatalf("account revoked since %v known %v err %v, want %v", since, known, err, later)
+	}
+	server.Evict("test:accounts")
+	if _, known, err := r.LookupAccount(ctx, 1); err != nil || known {
+		t.Fatalf("evicted account should ask the database, known %v err %v", known, err)
+	}
+}
+
+func TestRedisLoad(t *testing.T) {
+	ctx := context.Background()
+	r, _ := newRedis(t)
+	revoked := time.Now().Truncate(time.Microsecond)
+	err := r.Load(ctx, loaded(map[string]time.Time{"a": time.Now().Add(time.Hour)}, map[int]time.Time{2: revoked}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, known, _ := r.Lookup(ctx, "a"); !revoked || !known {
+		t.Fatal("loaded token should be revoked")
+	}
+	since, known, _ := r.LookupAccount(ctx, 2)
+	if !known || !since.Equal(revoked) {
+		t.Fatalf("loaded account revoked since %v, want %v", since, revoked)
+	}
+}
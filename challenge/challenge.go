@@ -0,0 +1,18 @@
+package challenge
+
+import (
+	"context"
+	"errors"
+)
+
+// Errors returned by verifiers
+var (
+	ErrMissing = errors.New("challenge response missing")
+	ErrFailed  = errors.New("challenge failed")
+)
+
+// Checks the response a client sent for a challenge, either a solved proof of work or a CAPTCHA token
+type Verifier interface {
+	// Fails with ErrMissing if there is no response and ErrFailed if it's wrong, any other error means it couldn't be checked
+	Verify(ctx context.Context, response, ip string) error
+}
@@ -0,0 +1,139 @@
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hashcash-style proof of work issued without storing anything, challenges are signed with the secret and bound
+// to the client address so solving one doesn't let other addresses through. Used solutions are remembered until
+// their challenge expires so each lets one request through, per instance since they aren't shared
+type ProofOfWork struct {
+	Secret     []byte
+	Difficulty int           // Leading zero bits the SHA-256 of a solution needs, every bit doubles the work
+	Validity   time.Duration // How long a challenge can be solved and used for
+	mu         sync.Mutex
+	spent      map[string]time.Time // Nonces of used challenges with their expiration
+	pruned     time.Time            // When expired nonces were last forgotten
+}
+
+// A challenge to solve by finding a counter such that SHA-256("<challenge>:<counter>") starts with difficulty zero bits,
+// the solution is sent back as "<challenge>:<counter>"
+type Puzzle struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// Issues a challenge for a client address
+func (pow *ProofOfWork) Issue(ip string) (Puzzle, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Puzzle{}, err
+	}
+	expiration := time.Now().Add(pow.Validity).Truncate(time.Second)
+	payload := strconv.FormatInt(expiration.Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(nonce)
+	return Puzzle{
+		Challenge:  payload + "." + pow.sign(payload, ip),
+		Difficulty: pow.Difficulty,
+		Expiration: expiration,
+	}, nil
+}
+
+// Verifies a solution checking the challenge was issued to the address, hasn't expired, is solved and wasn't used
+func (pow *ProofOfWork) Verify(ctx context.Context, response, ip string) error {
+	if response == "" {
+		return ErrMissing
+	}
+	// Splitting "<expiration>.<nonce>.<signature>:<counter>"
+	challenge, counter, ok := strings.Cut(response, ":")
+	if !ok || counter == "" {
+		return ErrFailed
+	}
+	payload, signature, ok := cutLast(challenge, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(pow.sign(payload, ip))) {
+		return ErrFailed
+	}
+	seconds, nonce, _ := strings.Cut(payload, ".")
+	expiration, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil || time.Now().Unix() > expiration {
+		return ErrFailed
+	}
+	if leadingZeros(sha256.Sum256([]byte(response))) < pow.Difficulty {
+		return ErrFailed
+	}
+	if !pow.spend(nonce, time.Unix(expiration, 0)) {
+		return ErrFailed
+	}
+	return nil
+}
+
+// Marks the nonce of a challenge as used until it expires, reporting false if it already was
+func (pow *ProofOfWork) spend(nonce string, expiration time.Time) bool {
+	pow.mu.Lock()
+	defer pow.mu.Unlock()
+	now := time.Now()
+	if pow.spent == nil {
+		pow.spent = map[string]time.Time{}
+	}
+	// Forgetting expired nonces once in a while rather than on every solution
+	if now.Sub(pow.pruned) > pow.Validity {
+		for spent, expiration := range pow.spent {
+			if now.After(expiration) {
+				delete(pow.spent, spent)
+			}
+		}
+		pow.pruned = now
+	}
+	if _, ok := pow.spent[nonce]; ok {
+		return false
+	}
+	pow.spent[nonce] = expiration
+	return true
+}
+
+// Finds the counter solving a challenge returning the response to send, takes about 2^difficulty hashes
+func Solve(challenge string, difficulty int) string {
+	for counter := 0; ; counter++ {
+		response := challenge + ":" + strconv.Itoa(counter)
+		if leadingZeros(sha256.Sum256([]byte(response))) >= difficulty {
+			return response
+		}
+	}
+}
+
+// Signs a challenge for an address and the current difficulty so neither can be swapped
+func (pow *ProofOfWork) sign(payload, ip string) string {
+	mac := hmac.New(sha256.New, pow.Secret)
+	mac.Write([]byte(payload + "|" + ip + "|" + strconv.Itoa(pow.Difficulty)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Splits a string around the last separator
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// Counts the leading zero bits of a hash
+func leadingZeros(sum [sha256.Size]byte) int {
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
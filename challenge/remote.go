@@ -0,0 +1,74 @@
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// CAPTCHA verified by a siteverify endpoint like the ones of hCaptcha and Turnstile, taking the secret, the token
+// the widget gave the client and its address as a form and answering with a success field
+type Remote struct {
+	URL    string
+	Secret string
+	Client *http.Client // Defaults to a client with a 10 seconds timeout
+}
+
+// Verifies hCaptcha tokens
+func HCaptcha(secret string) *Remote {
+	return &Remote{URL: "https://api.hcaptcha.com/siteverify", Secret: secret}
+}
+
+// Verifies Cloudflare Turnstile tokens
+func Turnstile(secret string) *Remote {
+	return &Remote{URL: "https://challenges.cloudflare.com/turnstile/v0/siteverify", Secret: secret}
+}
+
+// Asks the endpoint whether the token is valid
+func (remote *Remote) Verify(ctx context.Context, response, ip string) error {
+	if response == "" {
+		return ErrMissing
+	}
+	form := url.Values{"secret": {remote.Secret}, "response": {response}}
+	if ip != "" {
+		form.Set("remoteip", ip)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, remote.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := remote.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Error("failed to reach the challenge verifier", "err", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Error("challenge verifier responded with an error", "status", resp.StatusCode)
+		return fmt.Errorf("challenge verifier responded with %d", resp.StatusCode)
+	}
+	var result struct {
+		Success bool     `json:"success"`
+		Codes   []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		log.Error("failed to decode the challenge verifier response", "err", err)
+		return err
+	}
+	if !result.Success {
+		log.Debug("challenge rejected", "codes", result.Codes)
+		return ErrFailed
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xalby/based/challenge"
+	"github.com/0xalby/based/types"
+)
+
+// A client of the API, safe to share as long as Token isn't changed concurrently
+type Client struct {
+	BaseURL    string       // Where the API is mounted(example "https://example.com/api/v1")
+	HTTP       *http.Client // Keeps the jwt cookie set by login in its jar
+	Token      string       // Bearer token set by login and cleared by logout, over https the cookie works too
+	AdminToken string       // The API_ADMIN_TOKEN for admin routes
+	Locale     string       // Sent as Accept-Language(example "it")
+	Challenge  string       // Sent as X-Challenge, a CAPTCHA token or a proof of work solved with SolveChallenge
+}
+
+// Creates a client with a cookie jar
+func New(baseURL string) *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTP:    &http.Client{Jar: jar, Timeout: time.Minute},
+	}
+}
+
+// A logged in session
+type Session struct {
+	Token    string `json:"token"`
+	Redirect string `json:"redirect"`
+}
+
+// A newly generated TOTP secret
+type TOTP struct {
+	Secret string   `json:"secret"`
+	QRCode []byte   `json:"qr_code"` // Png image
+	Backup []string `json:"backup"`  // Backup codes
+}
+
+// Registers an account
+func (c *Client) Register(ctx context.Context, payload types.PayloadRegister) error {
+	return c.do(ctx, http.MethodPost, "/auth/register", "", payload, nil)
+}
+
+// Logs into an account using the token for the following requests
+func (c *Client) Login(ctx context.Context, payload types.PayloadLogin) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, http.MethodPost, "/auth/login", "", payload, &session); err != nil {
+		return nil, err
+	}
+	c.Token = session.Token
+	return &session, nil
+}
+
+// Logs in with a TOTP backup code, the account gets a new secret and backup codes
+func (c *Client) LoginWithBackupCode(ctx context.Context, payload types.PayloadLoginWithBackupCode) (*TOTP, error) {
+	var totp TOTP
+	if err := c.do(ctx, http.MethodPost, "/auth/backup", "", payload, &totp); err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// Unlocks an account locked after too many failed logins with the emailed token
+func (c *Client) Unlock(ctx context.Context, token string) error {
+	return c.do(ctx, http.MethodPost, "/auth/unlock", "", types.PayloadUnlock{Token: token}, nil)
+}
+
+// Logs out every session with the token emailed on a sign-in from a new device or location
+func (c *Client) Secure(ctx context.Context, token string) error {
+	return c.do(ctx, http.MethodPost, "/auth/secure", "", types.PayloadSecure{Token: token}, nil)
+}
+
+// Gets a proof of work challenge and solves it for the next requests enforcing one
+func (c *Client) SolveChallenge(ctx context.Context) error {
+	var puzzle challenge.Puzzle
+	if err := c.do(ctx, http.MethodGet, "/auth/challenge", "", nil, &puzzle); err != nil {
+		return err
+	}
+	c.Challenge = challenge.Solve(puzzle.Challenge, puzzle.Difficulty)
+	return nil
+}
+
+// Revokes the token
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodPost, "/auth/logout", c.Token, nil, nil); err != nil {
+		return err
+	}
+	c.Token = ""
+	return nil
+}
+
+// Verifies the account with the emailed code
+func (c *Client) Verify(ctx context.Context, code string) error {
+	return c.do(ctx, http.MethodPost, "/auth/verification", c.Token, types.PayloadVerification{Code: code}, nil)
+}
+
+// Resends the verification email
+func (c *Client) ResendVerification(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/auth/resend", c.Token, nil, nil)
+}
+
+// Enables TOTP returning the secret to add to an authenticator
+func (c *Client) EnableTOTP(ctx context.Context) (*TOTP, error) {
+	var totp TOTP
+	if err := c.do(ctx, http.MethodPut, "/account/totp/enable", c.Token, nil, &totp); err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// Disables TOTP
+func (c *Client) DisableTOTP(ctx context.Context) error {
+	return c.do(ctx, http.MethodPut, "/account/totp/disable", c.Token, nil, nil)
+}
+
+// Emails a confirmation code to the new address
+func (c *Client) SendConfirmationEmail(ctx context.Context, email string) error {
+	return c.do(ctx, http.MethodGet, "/account/confirmation", c.Token, types.PayloadAccountSendConfirmationEmail{Email: email}, nil)
+}
+
+// Updates the account email with the code sent to the new address
+func (c *Client) UpdateEmail(ctx context.Context, code string) error {
+	return c.do(ctx, http.MethodPut, "/account/update/email", c.Token, types.PayloadAccountUpdateEmail{Code: code}, nil)
+}
+
+// Cancels or reverts an email change with the token emailed to the old address
+func (c *Client) CancelEmailChange(ctx context.Context, token string) error {
+	return c.do(ctx, http.MethodPost, "/account/email/cancel", "", types.PayloadAccountCancelEmailChange{Token: token}, nil)
+}
+
+// Updates the account password
+func (c *Client) UpdatePassword(ctx context.Context, old, new string) error {
+	return c.do(ctx, http.MethodPut, "/account/update/password", c.Token, types.PayloadAccountUpdatePassword{Old: old, New: new}, nil)
+}
+
+// Updates the account preferred locale, empty follows Accept-Language
+func (c *Client) UpdateLocale(ctx context.Context, locale string) error {
+	return c.do(ctx, http.MethodPut, "/account/update/locale", c.Token, types.PayloadAccountUpdateLocale{Locale: locale}, nil)
+}
+
+// Deletes the account
+func (c *Client) DeleteAccount(ctx context.Context, password string) error {
+	if err := c.do(ctx, http.MethodDelete, "/account/delete", c.Token, types.PayloadAccountDelete{Password: password}, nil); err != nil {
+		return err
+	}
+	c.Token = ""
+	return nil
+}
+
+// Emails a password reset code
+func (c *Client) Recovery(ctx context.Context, email string) error {
+	return c.do(ctx, http.MethodGet, "/account/recovery", "", types.PayloadAccountRecovery{Email: email}, nil)
+}
+
+// Resets the password with the emailed code
+func (c *Client) Reset(ctx context.Context, code, password string) error {
+	return c.do(ctx, http.MethodPost, "/account/reset", "", types.PayloadAccountReset{Code: code, Password: password}, nil)
+}
+
+// An invitation along with its token, which is only returned when it's created
+type Invitation struct {
+	Invitation types.Invitation `json:"invitation"`
+	Token      string           `json:"token"`
+}
+
+// Creates an invitation optionally emailed to and bound to an address
+func (c *Client) CreateInvitation(ctx context.Context, payload types.PayloadInvitation) (*Invitation, error) {
+	var invitation Invitation
+	if err := c.do(ctx, http.MethodPost, "/account/invitations", c.Token, payload, &invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// Lists the invitations of the account
+func (c *Client) ListInvitations(ctx context.Context) ([]types.Invitation, error) {
+	return c.listInvitations(ctx, "/account/invitations", c.Token)
+}
+
+// Revokes an invitation of the account
+func (c *Client) RevokeInvitation(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/account/invitations/"+strconv.Itoa(id), c.Token, nil, nil)
+}
+
+// Lists outbox emails optionally filtered by status
+func (c *Client) ListOutbox(ctx context.Context, status string) ([]types.Email, error) {
+	var response struct {
+		Emails []types.Email `json:"emails"`
+	}
+	path := "/admin/outbox"
+	if status != "" {
+		path += "?" + url.Values{"status": {status}}.Encode()
+	}
+	if err := c.do(ctx, http.MethodGet, path, c.AdminToken, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Emails, nil
+}
+
+// Retries a dead outbox email
+func (c *Client) RetryOutbox(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodPost, "/admin/outbox/"+strconv.Itoa(id)+"/retry", c.AdminToken, nil, nil)
+}
+
+// Unlocks an account and forgets its failed logins
+func (c *Client) UnlockAccount(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodPost, "/admin/accounts/"+strconv.Itoa(id)+"/unlock", c.AdminToken, nil, nil)
+}
+
+// Gets the rows the janitor of the instance purged since it started
+func (c *Client) JanitorStats(ctx context.Context) (*types.Purged, error) {
+	var response struct {
+		Purged types.Purged `json:"purged"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/admin/janitor", c.AdminToken, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Purged, nil
+}
+
+// Creates an invitation as an admin, able to set how many registrations it allows and for how many days
+func (c *Client) AdminCreateInvitation(ctx context.Context, payload types.PayloadInvitation) (*Invitation, error) {
+	var invitation Invitation
+	if err := c.do(ctx, http.MethodPost, "/admin/invitations", c.AdminToken, payload, &invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// Lists every invitation
+func (c *Client) AdminListInvitations(ctx context.Context) ([]types.Invitation, error) {
+	return c.listInvitations(ctx, "/admin/invitations", c.AdminToken)
+}
+
+// Revokes any invitation
+func (c *Client) AdminRevokeInvitation(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/admin/invitations/"+strconv.Itoa(id), c.AdminToken, nil, nil)
+}
+
+func (c *Client) listInvitations(ctx context.Context, path, token string) ([]types.Invitation, error) {
+	var response struct {
+		Invitations []types.Invitation `json:"invitations"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, token, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Invitations, nil
+}
+
+// Fetches the openapi document
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var document json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/openapi.json", "", nil, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// Sends a request decoding the response into v or a problem into *Error
+func (c *Client) do(ctx context.Context, method, path, token string, payload, v any) error {
+	var body io.Reader
+	if payload != nil {
+		marshal, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(marshal)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, application/problem+json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.Locale != "" {
+		req.Header.Set("Accept-Language", c.Locale)
+	}
+	if c.Challenge != "" {
+		req.Header.Set("X-Challenge", c.Challenge)
+	}
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return problem(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Reads an error response, whatever sits in front of the API might respond with plain text
+func problem(resp *http.Response) error {
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	e := &Error{Status: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/problem+json") {
+		if err := json.Unmarshal(content, e); err != nil {
+			return err
+		}
+		return e
+	}
+	e.Detail = strings.TrimSpace(string(content))
+	return e
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Problem details responded with by the API
+type Error struct {
+	Status int          `json:"status"` // The http status
+	Code   string       `json:"code"`   // Stable code, empty if the response wasn't a problem(example from a proxy)
+	Detail string       `json:"detail"` // Human readable explanation in the client's locale
+	Errors []FieldError `json:"errors"` // Invalid request body fields
+	// How long to wait before retrying from the Retry-After header, zero if not sent
+	RetryAfter time.Duration `json:"-"`
+}
+
+// A request body field failing validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("based: %d %s", e.Status, e.Detail)
+	}
+	return fmt.Sprintf("based: %d %s: %s", e.Status, e.Code, e.Detail)
+}
+
+// Matches errors by code so errors.Is(err, client.ErrInvalidCredentials) works
+func (e *Error) Is(target error) bool {
+	var other *Error
+	if !errors.As(target, &other) {
+		return false
+	}
+	if other.Code == "" {
+		return other.Status == e.Status
+	}
+	return other.Code == e.Code
+}
+
+// Errors matching the codes in handlers/errors.go, middleware and utils
+var (
+	ErrInternal               = &Error{Code: "internal_error"}
+	ErrTimedOut               = &Error{Code: "timed_out"}
+	ErrCancelled              = &Error{Code: "cancelled"}
+	ErrInvalidAdminToken      = &Error{Code: "invalid_admin_token"}
+	ErrInvalidToken           = &Error{Code: "invalid_token"}
+	ErrTokenExpired           = &Error{Code: "token_expired"}
+	ErrTokenRevoked           = &Error{Code: "token_revoked"}
+	ErrAccountNotFound        = &Error{Code: "account_not_found"}
+	ErrEmailUsed              = &Error{Code: "email_already_used"}
+	ErrInvalidCredentials     = &Error{Code: "invalid_credentials"}
+	ErrWrongPassword          = &Error{Code: "wrong_password"}
+	ErrWrongTOTP              = &Error{Code: "wrong_totp"}
+	ErrInvalidCode            = &Error{Code: "invalid_code"}
+	ErrInvalidRecoveryCode    = &Error{Code: "invalid_recovery_code"}
+	ErrInvalidBackupCode      = &Error{Code: "invalid_backup_code"}
+	ErrAlreadyVerified        = &Error{Code: "account_already_verified"}
+	ErrNotVerified            = &Error{Code: "account_not_verified"}
+	ErrSameEmail              = &Error{Code: "same_email"}
+	ErrSamePassword           = &Error{Code: "same_password"}
+	ErrTOTPEnabled            = &Error{Code: "totp_already_enabled"}
+	ErrTOTPDisabled           = &Error{Code: "totp_already_disabled"}
+	ErrEmailChangeNotFound    = &Error{Code: "email_change_not_found"}
+	ErrOutboxEmailNotFound    = &Error{Code: "email_not_found"}
+	ErrInvalidParameter       = &Error{Code: "invalid_parameter"}
+	ErrAccountLocked          = &Error{Code: "account_locked"}
+	ErrLoginDelayed           = &Error{Code: "login_delayed"}
+	ErrUnlockTokenNotFound    = &Error{Code: "unlock_token_not_found"}
+	ErrAccountNotLocked       = &Error{Code: "account_not_locked"}
+	ErrPasswordTooShort       = &Error{Code: "password_too_short"}
+	ErrPasswordTooLong        = &Error{Code: "password_too_long"}
+	ErrPasswordTooWeak        = &Error{Code: "password_too_weak"}
+	ErrPasswordContainsEmail  = &Error{Code: "password_contains_email"}
+	ErrPasswordBreached       = &Error{Code: "password_breached"}
+	ErrPasswordReused         = &Error{Code: "password_reused"}
+	ErrChallengeRequired      = &Error{Code: "challenge_required"}
+	ErrChallengeFailed        = &Error{Code: "challenge_failed"}
+	ErrEmailDomainNotAllowed  = &Error{Code: "email_domain_not_allowed"}
+	ErrEmailDisposable        = &Error{Code: "email_disposable"}
+	ErrEmailDomainUnreachable = &Error{Code: "email_domain_unreachable"}
+	ErrInvalidEmail           = &Error{Code: "invalid_email"}
+	ErrRegistrationClosed     = &Error{Code: "registration_closed"}
+	ErrInvitationRequired     = &Error{Code: "invitation_required"}
+	ErrInvalidInvitation      = &Error{Code: "invalid_invitation"}
+	ErrInvitationLimit        = &Error{Code: "invitation_limit"}
+	ErrInvitationNotFound     = &Error{Code: "invitation_not_found"}
+	ErrSignInUnconfirmed      = &Error{Code: "signin_unconfirmed"}
+	ErrInvalidSignInCode      = &Error{Code: "invalid_signin_code"}
+	ErrSignInNotFound         = &Error{Code: "signin_not_found"}
+	ErrAddressNotAllowed      = &Error{Code: "address_not_allowed"}
+	ErrEmptyBody              = &Error{Code: "empty_body"}
+	ErrInvalidBody            = &Error{Code: "invalid_body"}
+	ErrValidationFailed       = &Error{Code: "validation_failed"}
+	ErrRateLimited            = &Error{Code: "rate_limited"}
+)
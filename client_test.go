@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xalby/based/client"
+	"github.com/0xalby/based/types"
+	"github.com/pquerna/otp/totp"
+)
+
+// Sends every request with a user agent
+type agent struct {
+	name string
+	next http.RoundTripper
+}
+
+func (a *agent) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", a.name)
+	return a.next.RoundTrip(req)
+}
+
+// Gets the id of an account by its email
+func accountID(t *testing.T, api *testAPI, email string) int {
+	t.Helper()
+	var id int
+	if err := api.DB.QueryRow("SELECT id FROM accounts WHERE email = ?", email).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// Gets the problem an error is
+func problemOf(t *testing.T, err error) *client.Error {
+	t.Helper()
+	var problem *client.Error
+	if !errors.As(err, &problem) {
+		t.Fatalf("got %v, want a *client.Error", err)
+	}
+	return problem
+}
+
+func TestClientSessions(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, map[string]string{
+		"CHALLENGE":            "pow",
+		"CHALLENGE_SECRET":     "a challenge secret of at least 32 characters",
+		"CHALLENGE_ROUTES":     "register",
+		"CHALLENGE_DIFFICULTY": "4",
+	})
+	c := api.Client()
+	payload := types.PayloadRegister{Email: "session@example.com", Password: testPassword}
+	expect(t, c.Register(ctx, payload), client.ErrChallengeRequired)
+	if err := c.SolveChallenge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Register(ctx, payload); err != nil {
+		t.Fatal(err)
+	}
+	// Every solution lets a single request through
+	expect(t, c.Register(ctx, payload), client.ErrChallengeFailed)
+	if err := c.SolveChallenge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.Register(ctx, payload), client.ErrEmailUsed)
+	_, err := c.Login(ctx, types.PayloadLogin{Email: "session@example.com", Password: "wrong " + testPassword})
+	expect(t, err, client.ErrInvalidCredentials)
+	session, err := c.Login(ctx, types.PayloadLogin{Email: "session@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Token == "" || c.Token != session.Token {
+		t.Fatalf("login kept token %q, responded %q", c.Token, session.Token)
+	}
+	if err := c.Verify(ctx, api.Emailed(t, "session@example.com", subjectVerification, codePattern)); err != nil {
+		t.Fatal(err)
+	}
+	// Problems are in the locale of the account once it has one
+	english := problemOf(t, c.ResendVerification(ctx)).Detail
+	if err := c.UpdateLocale(ctx, "it"); err != nil {
+		t.Fatal(err)
+	}
+	italian := problemOf(t, c.ResendVerification(ctx))
+	if italian.Code != client.ErrAlreadyVerified.Code || italian.Detail == english {
+		t.Fatalf("got %q %q after switching to italian, in english %q", italian.Code, italian.Detail, english)
+	}
+	// A logged out token stops working
+	token := c.Token
+	if err := c.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token != "" {
+		t.Fatal("logout kept the token")
+	}
+	c.Token = token
+	expect(t, c.ResendVerification(ctx), client.ErrTokenRevoked)
+}
+
+func TestClientTOTP(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	c := register(t, api, "totp@example.com", true)
+	key, err := c.EnableTOTP(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Secret == "" || len(key.QRCode) == 0 || len(key.Backup) == 0 {
+		t.Fatalf("incomplete totp setup %+v", key)
+	}
+	_, err = c.EnableTOTP(ctx)
+	expect(t, err, client.ErrTOTPEnabled)
+	_, err = c.Login(ctx, types.PayloadLogin{Email: "totp@example.com", Password: testPassword})
+	expect(t, err, client.ErrWrongTOTP)
+	code, err := totp.GenerateCode(key.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "totp@example.com", Password: testPassword, TOTP: code}); err != nil {
+		t.Fatal(err)
+	}
+	// A backup code replaces the secret and the backup codes, itself included
+	backup := types.PayloadLoginWithBackupCode{Email: "totp@example.com", BackupCode: key.Backup[0]}
+	renewed, err := c.LoginWithBackupCode(ctx, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Secret == "" || renewed.Secret == key.Secret || len(renewed.Backup) == 0 {
+		t.Fatalf("backup login didn't renew the totp secret %+v", renewed)
+	}
+	_, err = c.LoginWithBackupCode(ctx, backup)
+	expect(t, err, client.ErrInvalidBackupCode)
+	if err := c.DisableTOTP(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.DisableTOTP(ctx), client.ErrTOTPDisabled)
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "totp@example.com", Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientLockout(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, map[string]string{"LOGIN_FREE_ATTEMPTS": "10", "LOGIN_LOCKOUT_THRESHOLD": "2"})
+	c := register(t, api, "locked@example.com", true)
+	wrong := types.PayloadLogin{Email: "locked@example.com", Password: "wrong " + testPassword}
+	right := types.PayloadLogin{Email: "locked@example.com", Password: testPassword}
+	lock := func() {
+		t.Helper()
+		_, err := c.Login(ctx, wrong)
+		expect(t, err, client.ErrInvalidCredentials)
+		_, err = c.Login(ctx, wrong)
+		expect(t, err, client.ErrAccountLocked)
+		if problemOf(t, err).RetryAfter <= 0 {
+			t.Fatal("locked without a Retry-After")
+		}
+		_, err = c.Login(ctx, right)
+		expect(t, err, client.ErrAccountLocked)
+	}
+	lock()
+	expect(t, c.Unlock(ctx, strings.Repeat("0", 64)), client.ErrUnlockTokenNotFound)
+	if err := c.Unlock(ctx, api.Emailed(t, "locked@example.com", "Account locked", tokenPattern)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, right); err != nil {
+		t.Fatal(err)
+	}
+	// Admins unlock by account id
+	lock()
+	id := accountID(t, api, "locked@example.com")
+	intruder := api.Client()
+	intruder.AdminToken = strings.Repeat("0", len(testAdminToken))
+	expect(t, intruder.UnlockAccount(ctx, id), client.ErrInvalidAdminToken)
+	if err := c.UnlockAccount(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.UnlockAccount(ctx, id), client.ErrAccountNotLocked)
+	if _, err := c.Login(ctx, right); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientSecure(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	owner := register(t, api, "secure@example.com", true)
+	// Signing in from another browser emails the owner a link logging out everywhere
+	intruder := api.Client()
+	intruder.HTTP.Transport = &agent{name: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:120.0) Gecko/20100101 Firefox/120.0", next: http.DefaultTransport}
+	if _, err := intruder.Login(ctx, types.PayloadLogin{Email: "secure@example.com", Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+	token := api.Emailed(t, "secure@example.com", "New sign-in", tokenPattern)
+	if err := owner.Secure(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, owner.Secure(ctx, token), client.ErrSignInNotFound)
+	expect(t, intruder.ResendVerification(ctx), client.ErrTokenRevoked)
+	expect(t, owner.ResendVerification(ctx), client.ErrTokenRevoked)
+}
+
+func TestClientAccount(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	c := register(t, api, "account@example.com", true)
+	expect(t, c.UpdatePassword(ctx, "wrong "+testPassword, "another "+testPassword), client.ErrWrongPassword)
+	expect(t, c.UpdatePassword(ctx, testPassword, testPassword), client.ErrSamePassword)
+	if err := c.UpdatePassword(ctx, testPassword, "another "+testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "account@example.com", Password: "another " + testPassword}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.DeleteAccount(ctx, testPassword), client.ErrWrongPassword)
+	if err := c.DeleteAccount(ctx, "another "+testPassword); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Login(ctx, types.PayloadLogin{Email: "account@example.com", Password: "another " + testPassword})
+	expect(t, err, client.ErrAccountNotFound)
+}
+
+func TestClientInvitations(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, map[string]string{"REGISTRATION_MODE": "invite"})
+	admin := api.Client()
+	expect(t, admin.Register(ctx, types.PayloadRegister{Email: "inviter@example.com", Password: testPassword}), client.ErrInvitationRequired)
+	created, err := admin.AdminCreateInvitation(ctx, types.PayloadInvitation{Uses: 2, Days: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Token == "" || created.Invitation.MaxUses != 2 {
+		t.Fatalf("unexpected admin invitation %+v", created)
+	}
+	inviter := api.Client()
+	if err := inviter.Register(ctx, types.PayloadRegister{Email: "inviter@example.com", Password: testPassword, Invitation: created.Token}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inviter.Login(ctx, types.PayloadLogin{Email: "inviter@example.com", Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+	if err := inviter.Verify(ctx, api.Emailed(t, "inviter@example.com", subjectVerification, codePattern)); err != nil {
+		t.Fatal(err)
+	}
+	// Accounts invite others by email
+	invited, err := inviter.CreateInvitation(ctx, types.PayloadInvitation{Email: "invited@example.com", Bind: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := api.Emailed(t, "invited@example.com", "You're invited", tokenPattern); token != invited.Token {
+		t.Fatalf("emailed %q, created %q", token, invited.Token)
+	}
+	listed, err := inviter.ListInvitations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != invited.Invitation.ID {
+		t.Fatalf("listed %+v, want only invitation %d", listed, invited.Invitation.ID)
+	}
+	all, err := admin.AdminListInvitations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("admin listed %d invitations, want 2", len(all))
+	}
+	// Revoked invitations can't be used
+	expect(t, inviter.RevokeInvitation(ctx, created.Invitation.ID), client.ErrInvitationNotFound)
+	if err := inviter.RevokeInvitation(ctx, invited.Invitation.ID); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, inviter.RevokeInvitation(ctx, invited.Invitation.ID), client.ErrInvitationNotFound)
+	expect(t, admin.Register(ctx, types.PayloadRegister{Email: "invited@example.com", Password: testPassword, Invitation: invited.Token}), client.ErrInvalidInvitation)
+	if err := admin.AdminRevokeInvitation(ctx, created.Invitation.ID); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, admin.AdminRevokeInvitation(ctx, created.Invitation.ID), client.ErrInvitationNotFound)
+	expect(t, admin.Register(ctx, types.PayloadRegister{Email: "other@example.com", Password: testPassword, Invitation: created.Token}), client.ErrInvalidInvitation)
+}
+
+func TestClientOutbox(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, map[string]string{"MAIL_OUTBOX_WORKERS": "1"})
+	c := api.Client()
+	// An email which ran out of attempts, queued before emails were encrypted
+	result, err := api.DB.Exec("INSERT INTO outbox (recipient, subject, message, status, attempts, next, error) VALUES (?, ?, ?, 'dead', 5, ?, 'unreachable')",
+		"dead@example.com", "Dead", `{"To":"dead@example.com","Subject":"Dead","HTML":"<p>dead</p>"}`, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead, err := c.ListOutbox(ctx, "dead")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ID != int(id) || dead[0].Error != "unreachable" {
+		t.Fatalf("listed %+v, want the dead email %d", dead, id)
+	}
+	_, err = c.ListOutbox(ctx, "lost")
+	expect(t, err, client.ErrInvalidParameter)
+	expect(t, c.RetryOutbox(ctx, int(id)+1), client.ErrOutboxEmailNotFound)
+	if err := c.RetryOutbox(ctx, int(id)); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); len(api.Mail.To("dead@example.com")) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the retried email wasn't delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect(t, c.RetryOutbox(ctx, int(id)), client.ErrOutboxEmailNotFound)
+}
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, map[string]string{"RATE_LIMIT_LOGIN": "1/1h email"})
+	c := register(t, api, "errors@example.com", false)
+	// Invalid fields are listed
+	err := c.Register(ctx, types.PayloadRegister{Email: "not an email", Password: testPassword})
+	expect(t, err, client.ErrValidationFailed)
+	if fields := problemOf(t, err).Errors; len(fields) != 1 || fields[0].Field != "email" {
+		t.Fatalf("got field errors %+v, want one for email", fields)
+	}
+	// Limited requests say when to retry
+	_, err = c.Login(ctx, types.PayloadLogin{Email: "errors@example.com", Password: testPassword})
+	expect(t, err, client.ErrRateLimited)
+	if problemOf(t, err).RetryAfter <= 0 {
+		t.Fatal("rate limited without a Retry-After")
+	}
+	// Admin routes need the admin token
+	if _, err := c.JanitorStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	intruder := api.Client()
+	intruder.AdminToken = ""
+	_, err = intruder.JanitorStats(ctx)
+	expect(t, err, client.ErrInvalidAdminToken)
+	if _, err := intruder.OpenAPI(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Anything in front of the API responding with plain text still gives an error with its status
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+	err = client.New(proxy.URL).Logout(ctx)
+	expect(t, err, &client.Error{Status: http.StatusBadGateway})
+	if problem := problemOf(t, err); problem.Code != "" || problem.Detail != "upstream unavailable" {
+		t.Fatalf("got %+v from a plain text response", problem)
+	}
+	if errors.Is(err, client.ErrInternal) {
+		t.Fatal("a plain text response matched a problem code")
+	}
+}
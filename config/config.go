@@ -1,8 +1,11 @@
 package config
 
 import (
+	"time"
+
 	"github.com/charmbracelet/log"
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
 )
 
 var TokenAuth *jwtauth.JWTAuth
@@ -21,3 +24,17 @@ func InitJWT(secret string) {
 		return
 	}
 }
+
+// Issues a jwt token providing access to protected routes for a week
+func IssueToken(account int) (string, time.Time, error) {
+	now := time.Now()
+	expiration := now.Add(time.Hour * 24 * 7)
+	_, token, err := TokenAuth.Encode(map[string]interface{}{
+		"account": account,
+		"exp":     expiration.Unix(),
+		"iat":     now.Unix(),
+		"issued":  now.UnixMicro(), // Unlike iat precise enough to tell tokens issued right after a revocation
+		"jti":     uuid.New().String(),
+	})
+	return token, expiration, err
+}
@@ -1,10 +1,20 @@
 package database
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+)
 
 type Driver interface {
 	MustConnect(uri string, user string, password string) (*sql.DB, error)
-	Exec(directive string, args ...interface{}) (sql.Result, error)
-	Query(query string, args ...interface{}) (*sql.Rows, error)
+	Exec(ctx context.Context, directive string, args ...interface{}) (sql.Result, error)
+	Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 	Close() error
 }
+
+// Implemented by drivers able to signal other instances sharing the database
+type Notifier interface {
+	Notify(ctx context.Context, channel string, payload string) error
+	// Calls handle for every notification until the context is done, an empty payload means some might have been missed
+	Listen(ctx context.Context, channel string, handle func(payload string)) error
+}
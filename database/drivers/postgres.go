@@ -1,23 +1,26 @@
 package drivers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/lib/pq"
 )
 
 type DriverPostgres struct {
-	db *sql.DB
+	db      *sql.DB
+	connStr string
 }
 
 func (d *DriverPostgres) MustConnect(uri, user, password string) (*sql.DB, error) {
 	// Constructing the connection string
-	connStr := fmt.Sprintf("postgres://%s:%s@%s", user, password, uri)
+	d.connStr = fmt.Sprintf("postgres://%s:%s@%s", user, password, uri)
 	// Opening the connection
 	var err error
-	d.db, err = sql.Open("postgres", connStr)
+	d.db, err = sql.Open("postgres", d.connStr)
 	if err != nil {
 		panic(fmt.Errorf("failed to open postgres database %s", err))
 	}
@@ -29,8 +32,8 @@ func (d *DriverPostgres) MustConnect(uri, user, password string) (*sql.DB, error
 	return d.db, nil
 }
 
-func (d *DriverPostgres) Exec(directive string, args ...interface{}) (sql.Result, error) {
-	result, err := d.db.Exec(directive, args...)
+func (d *DriverPostgres) Exec(ctx context.Context, directive string, args ...interface{}) (sql.Result, error) {
+	result, err := d.db.ExecContext(ctx, directive, args...)
 	if err != nil {
 		if pgErr, ok := err.(*pq.Error); ok {
 			return nil, fmt.Errorf("postgres error %s %v", pgErr.Code, pgErr.Error())
@@ -41,8 +44,8 @@ func (d *DriverPostgres) Exec(directive string, args ...interface{}) (sql.Result
 	return result, nil
 }
 
-func (d *DriverPostgres) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	rows, err := d.db.Query(query, args...)
+func (d *DriverPostgres) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		if pgErr, ok := err.(*pq.Error); ok {
 			return nil, pgErr
@@ -53,6 +56,49 @@ func (d *DriverPostgres) Query(query string, args ...interface{}) (*sql.Rows, er
 	return rows, nil
 }
 
+func (d *DriverPostgres) Notify(ctx context.Context, channel string, payload string) error {
+	if _, err := d.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload); err != nil {
+		if pgErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf("postgres error %s %v", pgErr.Code, pgErr.Error())
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *DriverPostgres) Listen(ctx context.Context, channel string, handle func(payload string)) error {
+	// Using a dedicated connection reconnecting on its own
+	listener := pq.NewListener(d.connStr, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error("postgres listener event", "event", event, "err", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-listener.Notify:
+				// A nil notification is sent after reconnecting
+				if notification == nil {
+					handle("")
+					continue
+				}
+				handle(notification.Extra)
+			case <-time.After(time.Minute):
+				// Making sure the connection is still alive
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
+
 func (d *DriverPostgres) Close() error {
 	if err := d.db.Close(); err != nil {
 		if pgErr, ok := err.(*pq.Error); ok {
@@ -1,8 +1,10 @@
 package drivers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/charmbracelet/log"
 	"modernc.org/sqlite"
@@ -13,6 +15,14 @@ type DriverSqlite3 struct {
 }
 
 func (d *DriverSqlite3) MustConnect(uri, user, password string) (*sql.DB, error) {
+	// Enforcing foreign keys on every connection so deletions cascade
+	if !strings.Contains(uri, "foreign_keys") {
+		separator := "?"
+		if strings.Contains(uri, "?") {
+			separator = "&"
+		}
+		uri += separator + "_pragma=foreign_keys(1)"
+	}
 	var err error
 	d.db, err = sql.Open("sqlite", uri)
 	if err != nil {
@@ -26,8 +36,8 @@ func (d *DriverSqlite3) MustConnect(uri, user, password string) (*sql.DB, error)
 	return d.db, nil
 }
 
-func (d *DriverSqlite3) Exec(directive string, args ...interface{}) (sql.Result, error) {
-	result, err := d.db.Exec(directive, args...)
+func (d *DriverSqlite3) Exec(ctx context.Context, directive string, args ...interface{}) (sql.Result, error) {
+	result, err := d.db.ExecContext(ctx, directive, args...)
 	if err != nil {
 		if sqliteErr, ok := err.(*sqlite.Error); ok {
 			return nil, fmt.Errorf("sqlite error %d %v", sqliteErr.Code(), sqliteErr.Error())
@@ -38,8 +48,8 @@ func (d *DriverSqlite3) Exec(directive string, args ...interface{}) (sql.Result,
 	return result, nil
 }
 
-func (d *DriverSqlite3) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	rows, err := d.db.Query(query, args...)
+func (d *DriverSqlite3) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		if sqliteErr, ok := err.(*sqlite.Error); ok {
 			return nil, fmt.Errorf("sqlite error %d %v", sqliteErr.Code(), sqliteErr.Error())
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS invalidations (
+    `account` INTEGER NOT NULL PRIMARY KEY,
+    `revoked` TIMESTAMP NOT NULL, -- Tokens issued before this are revoked
+	FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE invalidations;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- SQLite can't alter foreign keys so the table is rebuilt
+CREATE TABLE IF NOT EXISTS codes_cascade (
+  `id` INTEGER NOT NULL PRIMARY KEY,
+  `code` VARCHAR(6) NOT NULL DEFAULT "",
+  `recovery` VARCHAR(255) NOT NULL DEFAULT "",
+  `expiration` TIMESTAMP NOT NULL,
+  `account` INTEGER NOT NULL,
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+INSERT INTO codes_cascade (id, code, recovery, expiration, account) SELECT id, code, recovery, expiration, account FROM codes;
+DROP TABLE codes;
+ALTER TABLE codes_cascade RENAME TO codes;
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS codes_restrict (
+  `id` INTEGER NOT NULL PRIMARY KEY,
+  `code` VARCHAR(6) NOT NULL DEFAULT "",
+  `recovery` VARCHAR(255) NOT NULL DEFAULT "",
+  `expiration` TIMESTAMP NOT NULL,
+  `account` INTEGER NOT NULL,
+  FOREIGN KEY (account) REFERENCES accounts(id)
+);
+-- +goose StatementEnd
+INSERT INTO codes_restrict (id, code, recovery, expiration, account) SELECT id, code, recovery, expiration, account FROM codes;
+DROP TABLE codes;
+ALTER TABLE codes_restrict RENAME TO codes;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Emails are unique regardless of case, fails if existing accounts only differ by case
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_email_unique ON accounts (lower(email));
+
+-- +goose Down
+DROP INDEX IF EXISTS accounts_email_unique;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS outbox (
+  `id` INTEGER NOT NULL PRIMARY KEY,
+  `recipient` VARCHAR(255) NOT NULL,
+  `subject` VARCHAR(255) NOT NULL,
+  `message` TEXT NOT NULL, -- JSON encoded message
+  `status` VARCHAR(16) NOT NULL DEFAULT "pending", -- pending, sending, sent or dead
+  `attempts` INTEGER NOT NULL DEFAULT 0,
+  `next` TIMESTAMP NOT NULL, -- Next delivery attempt
+  `error` TEXT NOT NULL DEFAULT "", -- Last delivery error
+  `created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+CREATE INDEX IF NOT EXISTS outbox_status_next ON outbox (status, next);
+
+-- +goose Down
+DROP INDEX IF EXISTS outbox_status_next;
+-- +goose StatementBegin
+DROP TABLE outbox;
+-- +goose StatementEnd
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Empty follows the Accept-Language header of each request
+ALTER TABLE accounts ADD COLUMN `locale` VARCHAR(16) NOT NULL DEFAULT "";
+
+-- +goose Down
+ALTER TABLE accounts DROP COLUMN `locale`;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ratelimits (
+  `bucket` VARCHAR(512) NOT NULL, -- The limiter name followed by the key requests are counted by
+  `start` INTEGER NOT NULL, -- Unix time the window starts at
+  `hits` INTEGER NOT NULL DEFAULT 0,
+  `expiration` INTEGER NOT NULL, -- Unix time after which the window isn't needed anymore
+  PRIMARY KEY (bucket, start)
+);
+-- +goose StatementEnd
+CREATE INDEX IF NOT EXISTS ratelimits_expiration ON ratelimits (expiration);
+
+-- +goose Down
+DROP TABLE IF EXISTS ratelimits;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS history (
+  `id` INTEGER NOT NULL PRIMARY KEY,
+  `account` INTEGER NOT NULL,
+  `hash` VARCHAR(255) NOT NULL, -- Hash of a password the account had before
+  `created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, -- When the password got replaced
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+CREATE INDEX IF NOT EXISTS history_account ON history (account);
+
+-- +goose Down
+DROP TABLE IF EXISTS history;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS invitations (
+  `id` INTEGER NOT NULL PRIMARY KEY,
+  `token` VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 of the token registrations are made with
+  `inviter` INTEGER, -- Account which created it, null if an admin did
+  `email` VARCHAR(255) NOT NULL DEFAULT "", -- Only this address can use it, any if empty
+  `uses` INTEGER NOT NULL DEFAULT 0, -- Registrations made with it
+  `max_uses` INTEGER NOT NULL DEFAULT 1,
+  `expiration` TIMESTAMP NOT NULL,
+  `created` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (inviter) REFERENCES accounts(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+CREATE INDEX IF NOT EXISTS invitations_inviter ON invitations (inviter);
+CREATE INDEX IF NOT EXISTS invitations_expiration ON invitations (expiration);
+
+-- +goose Down
+DROP TABLE IF EXISTS invitations;
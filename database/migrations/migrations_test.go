@@ -0,0 +1,286 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed *.sql
+var sqliteMigrations embed.FS
+
+//go:embed postgres/*.sql
+var postgresMigrations embed.FS
+
+// Foreign keys every table referencing accounts has to delete along with them
+var cascades = map[string]string{
+	"codes":         "account",
+	"blacklist":     "account",
+	"backup":        "account",
+	"invalidations": "account",
+	"changes":       "account",
+	"attempts":      "account",
+	"history":       "account",
+	"invitations":   "inviter",
+	"signins":       "account",
+}
+
+// Indexes by name with the table they are on and whether they are unique
+var indexes = map[string]struct {
+	table  string
+	unique bool
+}{
+	"accounts_email_unique":  {"accounts", true},
+	"codes_code":             {"codes", false},
+	"codes_recovery":         {"codes", false},
+	"codes_account":          {"codes", false},
+	"codes_expiration":       {"codes", false},
+	"blacklist_account":      {"blacklist", false},
+	"blacklist_expiration":   {"blacklist", false},
+	"backup_account":         {"backup", false},
+	"outbox_status_next":     {"outbox", false},
+	"outbox_status_lease":    {"outbox", false},
+	"changes_account":        {"changes", false},
+	"changes_revert":         {"changes", false},
+	"changes_expiration":     {"changes", false},
+	"attempts_token":         {"attempts", false},
+	"ratelimits_expiration":  {"ratelimits", false},
+	"history_account":        {"history", false},
+	"invitations_inviter":    {"invitations", false},
+	"invitations_expiration": {"invitations", false},
+	"signins_account":        {"signins", false},
+	"signins_token":          {"signins", false},
+}
+
+// A database migrations are tested against
+type dialect struct {
+	migrations fs.FS
+	open       func(t *testing.T) *sql.DB
+	// Gets the table referenced by a column and what happens on delete
+	foreignKey func(db *sql.DB, table, column string) (references string, onDelete string, err error)
+	// Reports whether an index exists and is unique
+	index func(db *sql.DB, table, name string) (exists bool, unique bool, err error)
+}
+
+var sqlite = dialect{
+	migrations: sqliteMigrations,
+	open: func(t *testing.T) *sql.DB {
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "based.db")+"?_pragma=foreign_keys(1)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	},
+	foreignKey: func(db *sql.DB, table, column string) (string, string, error) {
+		var references, onDelete string
+		err := db.QueryRow(`SELECT "table", on_delete FROM pragma_foreign_key_list(?) WHERE "from" = ?`, table, column).Scan(&references, &onDelete)
+		return references, onDelete, err
+	},
+	index: func(db *sql.DB, table, name string) (bool, bool, error) {
+		var unique bool
+		err := db.QueryRow(`SELECT "unique" FROM pragma_index_list(?) WHERE name = ?`, table, name).Scan(&unique)
+		if err == sql.ErrNoRows {
+			return false, false, nil
+		}
+		return err == nil, unique, err
+	},
+}
+
+var postgres = dialect{
+	migrations: mustSub(postgresMigrations, "postgres"),
+	open: func(t *testing.T) *sql.DB {
+		address := os.Getenv("TEST_POSTGRES_ADDRESS")
+		if address == "" {
+			t.Skip("TEST_POSTGRES_ADDRESS isn't set")
+		}
+		db, err := sql.Open("postgres", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Migrating a schema of its own on a single connection so the search path sticks
+		db.SetMaxOpenConns(1)
+		schema := fmt.Sprintf("based_test_%d", time.Now().UnixNano())
+		if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("SET search_path TO " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			db.Exec("DROP SCHEMA " + schema + " CASCADE")
+			db.Close()
+		})
+		return db
+	},
+	foreignKey: func(db *sql.DB, table, column string) (string, string, error) {
+		var references, action string
+		err := db.QueryRow(`
+			SELECT c.confrelid::regclass::text, c.confdeltype FROM pg_constraint c
+			JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY(c.conkey)
+			WHERE c.contype = 'f' AND c.conrelid = $1::regclass AND a.attname = $2`, table, column).Scan(&references, &action)
+		onDelete := map[string]string{"a": "NO ACTION", "r": "RESTRICT", "c": "CASCADE", "n": "SET NULL", "d": "SET DEFAULT"}[action]
+		return references, onDelete, err
+	},
+	index: func(db *sql.DB, table, name string) (bool, bool, error) {
+		var unique bool
+		err := db.QueryRow(`
+			SELECT i.indisunique FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+			WHERE c.relname = $1 AND c.relnamespace = current_schema()::regnamespace AND i.indrelid = $2::regclass`, name, table).Scan(&unique)
+		if err == sql.ErrNoRows {
+			return false, false, nil
+		}
+		return err == nil, unique, err
+	},
+}
+
+func TestSQLite(t *testing.T) {
+	testSchema(t, sqlite)
+}
+
+func TestPostgres(t *testing.T) {
+	testSchema(t, postgres)
+}
+
+// Migrates up, checks the schema, migrates down and up again
+func testSchema(t *testing.T, d dialect) {
+	db := d.open(t)
+	files := migrationFiles(t, d.migrations)
+	up(t, db, d.migrations, files)
+	checkSchema(t, db, d)
+	// Every migration can be rolled back and applied again
+	for i := len(files) - 1; i >= 0; i-- {
+		exec(t, db, files[i], section(t, d.migrations, files[i], false))
+	}
+	if _, err := db.Exec("SELECT 1 FROM accounts"); err == nil {
+		t.Fatal("accounts still exists after migrating down")
+	}
+	up(t, db, d.migrations, files)
+	checkSchema(t, db, d)
+}
+
+func checkSchema(t *testing.T, db *sql.DB, d dialect) {
+	t.Helper()
+	for table, column := range cascades {
+		references, onDelete, err := d.foreignKey(db, table, column)
+		if err != nil {
+			t.Errorf("foreign key of %s.%s: %v", table, column, err)
+			continue
+		}
+		if references != "accounts" || onDelete != "CASCADE" {
+			t.Errorf("%s.%s references %s on delete %s, want accounts on delete CASCADE", table, column, references, onDelete)
+		}
+	}
+	for name, expected := range indexes {
+		exists, unique, err := d.index(db, expected.table, name)
+		if err != nil {
+			t.Errorf("index %s: %v", name, err)
+			continue
+		}
+		if !exists {
+			t.Errorf("index %s on %s is missing", name, expected.table)
+		} else if unique != expected.unique {
+			t.Errorf("index %s unique %v, want %v", name, unique, expected.unique)
+		}
+	}
+	// Emails are unique regardless of case
+	mustExec(t, db, "INSERT INTO accounts (email, password) VALUES ('case@example.com', 'hash')")
+	if _, err := db.Exec("INSERT INTO accounts (email, password) VALUES ('Case@Example.com', 'hash')"); err == nil {
+		t.Error("emails differing only by case were both inserted")
+	}
+	// Codes go away along with their account
+	var id int
+	if err := db.QueryRow("SELECT id FROM accounts WHERE email = 'case@example.com'").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db, fmt.Sprintf("INSERT INTO codes (code, expiration, account) VALUES ('ABC123', CURRENT_TIMESTAMP, %d)", id))
+	mustExec(t, db, fmt.Sprintf("DELETE FROM accounts WHERE id = %d", id))
+	var codes int
+	if err := db.QueryRow("SELECT COUNT(*) FROM codes").Scan(&codes); err != nil {
+		t.Fatal(err)
+	}
+	if codes != 0 {
+		t.Errorf("%d codes left after deleting their account", codes)
+	}
+}
+
+// Lists the migration files in the order goose applies them
+func migrationFiles(t *testing.T, migrations fs.FS) []string {
+	t.Helper()
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		t.Fatal("no migrations found")
+	}
+	return files
+}
+
+func up(t *testing.T, db *sql.DB, migrations fs.FS, files []string) {
+	t.Helper()
+	for _, file := range files {
+		exec(t, db, file, section(t, migrations, file, true))
+	}
+}
+
+// Gets the up or down statements of a goose migration
+func section(t *testing.T, migrations fs.FS, file string, up bool) string {
+	t.Helper()
+	content, err := fs.ReadFile(migrations, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upSection, downSection, found := strings.Cut(string(content), "-- +goose Down")
+	if !found || !strings.Contains(upSection, "-- +goose Up") {
+		t.Fatalf("%s isn't a goose migration", file)
+	}
+	if up {
+		return upSection
+	}
+	return downSection
+}
+
+// Runs the statements of a section unless it only has comments
+func exec(t *testing.T, db *sql.DB, file, statements string) {
+	t.Helper()
+	empty := true
+	for _, line := range strings.Split(statements, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			empty = false
+			break
+		}
+	}
+	if empty {
+		return
+	}
+	if _, err := db.Exec(statements); err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+}
+
+func mustExec(t *testing.T, db *sql.DB, statement string) {
+	t.Helper()
+	if _, err := db.Exec(statement); err != nil {
+		t.Fatalf("%s: %v", statement, err)
+	}
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS accounts (
+  id SERIAL PRIMARY KEY,
+  email VARCHAR(255) NOT NULL UNIQUE,
+  pending VARCHAR(255) NOT NULL DEFAULT '',
+  password VARCHAR(255) NOT NULL,
+  verified BOOLEAN NOT NULL DEFAULT FALSE, -- Verified true/false
+  totp BOOLEAN NOT NULL DEFAULT FALSE, -- 2FA TOTP disabled/enabled
+  secret VARCHAR(255) NOT NULL DEFAULT '', -- TOTP secret
+  updated TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE accounts;
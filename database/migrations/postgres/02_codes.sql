@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS codes (
+  id SERIAL PRIMARY KEY,
+  code VARCHAR(6) NOT NULL DEFAULT '',
+  recovery VARCHAR(255) NOT NULL DEFAULT '',
+  expiration TIMESTAMPTZ NOT NULL,
+  account INTEGER NOT NULL,
+  FOREIGN KEY (account) REFERENCES accounts(id)
+);
+
+-- +goose Down
+DROP TABLE codes;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS blacklist (
+  token VARCHAR(36) NOT NULL PRIMARY KEY, -- Unique identifier for the JWT token
+  expiration TIMESTAMPTZ NOT NULL,
+  account INTEGER NOT NULL,
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE blacklist;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS backup (
+  id SERIAL PRIMARY KEY,
+  hash VARCHAR(255) NOT NULL, -- Hashed TOTP backup code
+  account INTEGER NOT NULL,
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE backup;
@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS invalidations (
+  account INTEGER NOT NULL PRIMARY KEY,
+  revoked TIMESTAMPTZ NOT NULL, -- Tokens issued before this are revoked
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE invalidations;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE codes DROP CONSTRAINT IF EXISTS codes_account_fkey;
+ALTER TABLE codes ADD CONSTRAINT codes_account_fkey FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE;
+
+-- +goose Down
+ALTER TABLE codes DROP CONSTRAINT IF EXISTS codes_account_fkey;
+ALTER TABLE codes ADD CONSTRAINT codes_account_fkey FOREIGN KEY (account) REFERENCES accounts(id);
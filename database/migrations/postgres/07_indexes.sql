@@ -0,0 +1,19 @@
+-- +goose Up
+-- Codes are looked up by value when verifying and recovering and by account when cleaning up
+CREATE INDEX IF NOT EXISTS codes_code ON codes (code);
+CREATE INDEX IF NOT EXISTS codes_recovery ON codes (recovery);
+CREATE INDEX IF NOT EXISTS codes_account ON codes (account);
+CREATE INDEX IF NOT EXISTS codes_expiration ON codes (expiration);
+-- Revoked tokens are purged by expiration and deleted along with their account
+CREATE INDEX IF NOT EXISTS blacklist_account ON blacklist (account);
+CREATE INDEX IF NOT EXISTS blacklist_expiration ON blacklist (expiration);
+CREATE INDEX IF NOT EXISTS backup_account ON backup (account);
+
+-- +goose Down
+DROP INDEX IF EXISTS codes_code;
+DROP INDEX IF EXISTS codes_recovery;
+DROP INDEX IF EXISTS codes_account;
+DROP INDEX IF EXISTS codes_expiration;
+DROP INDEX IF EXISTS blacklist_account;
+DROP INDEX IF EXISTS blacklist_expiration;
+DROP INDEX IF EXISTS backup_account;
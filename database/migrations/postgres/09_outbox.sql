@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS outbox (
+  id SERIAL PRIMARY KEY,
+  recipient VARCHAR(255) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  message TEXT NOT NULL, -- JSON encoded message
+  status VARCHAR(16) NOT NULL DEFAULT 'pending', -- pending, sending, sent or dead
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next TIMESTAMPTZ NOT NULL, -- Next delivery attempt
+  error TEXT NOT NULL DEFAULT '', -- Last delivery error
+  created TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS outbox_status_next ON outbox (status, next);
+
+-- +goose Down
+DROP TABLE outbox;
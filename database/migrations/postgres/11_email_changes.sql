@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS changes (
+  id SERIAL PRIMARY KEY,
+  account INTEGER NOT NULL,
+  old VARCHAR(255) NOT NULL, -- Address the account had when the change was requested
+  new VARCHAR(255) NOT NULL,
+  token VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 of the token in the cancel link sent to the old address
+  revert VARCHAR(64) NOT NULL DEFAULT '', -- SHA-256 of the token in the revert link sent once confirmed
+  status VARCHAR(16) NOT NULL DEFAULT 'pending', -- pending, confirmed, cancelled or reverted
+  expiration TIMESTAMPTZ NOT NULL, -- The cancel link works until then
+  created TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS changes_account ON changes (account);
+CREATE INDEX IF NOT EXISTS changes_revert ON changes (revert);
+CREATE INDEX IF NOT EXISTS changes_expiration ON changes (expiration);
+
+-- +goose Down
+DROP TABLE IF EXISTS changes;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS attempts (
+  account INTEGER NOT NULL PRIMARY KEY,
+  failures INTEGER NOT NULL DEFAULT 0, -- Failed logins in a row
+  next TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- No login is tried before then
+  locked TIMESTAMPTZ, -- The account is locked until then, null if it isn't
+  token VARCHAR(64) NOT NULL DEFAULT '', -- SHA-256 of the token in the unlock link emailed once locked
+  updated TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- Timestamp of the last failure
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS attempts_token ON attempts (token);
+
+-- +goose Down
+DROP TABLE IF EXISTS attempts;
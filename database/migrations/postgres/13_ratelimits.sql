@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS ratelimits (
+  bucket VARCHAR(512) NOT NULL, -- The limiter name followed by the key requests are counted by
+  start BIGINT NOT NULL, -- Unix time the window starts at
+  hits INTEGER NOT NULL DEFAULT 0,
+  expiration BIGINT NOT NULL, -- Unix time after which the window isn't needed anymore
+  PRIMARY KEY (bucket, start)
+);
+CREATE INDEX IF NOT EXISTS ratelimits_expiration ON ratelimits (expiration);
+
+-- +goose Down
+DROP TABLE IF EXISTS ratelimits;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS history (
+  id SERIAL PRIMARY KEY,
+  account INTEGER NOT NULL,
+  hash VARCHAR(255) NOT NULL, -- Hash of a password the account had before
+  created TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- When the password got replaced
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS history_account ON history (account);
+
+-- +goose Down
+DROP TABLE IF EXISTS history;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Addresses are stored lowercased since they are looked up normalized, fails if two accounts differ only by case
+UPDATE accounts SET email = lower(email), pending = lower(pending);
+
+-- +goose Down
+-- The original case is lost
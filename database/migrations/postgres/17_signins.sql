@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS signins (
+  id SERIAL PRIMARY KEY,
+  account INTEGER NOT NULL,
+  network VARCHAR(64) NOT NULL, -- The /24 or /48 network the account signed in from
+  agent VARCHAR(64) NOT NULL, -- The browser and operating system families of the user agent
+  code VARCHAR(64) NOT NULL DEFAULT '', -- SHA-256 of the emailed code confirming the sign-in, empty once confirmed
+  token VARCHAR(64) NOT NULL DEFAULT '', -- SHA-256 of the token in the secure my account link
+  expiration TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- The code can't be used after then
+  created TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- The first sign-in from the network and agent
+  seen TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, -- The last sign-in from them
+  FOREIGN KEY (account) REFERENCES accounts(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS signins_account ON signins (account, network, agent);
+CREATE INDEX IF NOT EXISTS signins_token ON signins (token);
+
+-- +goose Down
+DROP TABLE IF EXISTS signins;
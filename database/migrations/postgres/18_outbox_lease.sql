@@ -0,0 +1,12 @@
+-- +goose Up
+-- The instance delivering an email and until when, others claim it again once the lease runs out
+ALTER TABLE outbox ADD COLUMN owner VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN lease TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS outbox_status_lease ON outbox (status, lease);
+-- Sent emails don't keep their content, codes and tokens are in it
+UPDATE outbox SET message = '' WHERE status = 'sent';
+
+-- +goose Down
+DROP INDEX IF EXISTS outbox_status_lease;
+ALTER TABLE outbox DROP COLUMN lease;
+ALTER TABLE outbox DROP COLUMN owner;
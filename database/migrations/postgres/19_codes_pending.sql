@@ -0,0 +1,6 @@
+-- +goose Up
+-- The address a confirmation code changes the email to, empty for the other codes
+ALTER TABLE codes ADD COLUMN pending VARCHAR(255) NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE codes DROP COLUMN pending;
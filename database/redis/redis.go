@@ -0,0 +1,197 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Errors replied by the server
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Minimal client speaking the Redis serialization protocol over a single connection
+type Client struct {
+	Address  string        // Host and port(example "localhost:6379")
+	Password string        // Optional password sent with AUTH
+	Database int           // Database index selected after connecting
+	Timeout  time.Duration // Dial and i/o timeout used when the context has no deadline
+	mu       sync.Mutex
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// Sends a single command returning its reply
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.Pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(Error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Sends many commands in a single round trip returning their replies, server errors are returned in place
+func (c *Client) Pipeline(ctx context.Context, commands [][]string) ([]any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+	replies, err := c.roundTrip(ctx, commands)
+	if err != nil {
+		// Dropping the connection since its state is unknown
+		c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	return replies, nil
+}
+
+// Closes the connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Dials the server authenticating and selecting the database if needed
+func (c *Client) connect(ctx context.Context) error {
+	if c.conn != nil {
+		return nil
+	}
+	dialer := net.Dialer{Timeout: c.timeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	var setup [][]string
+	if c.Password != "" {
+		setup = append(setup, []string{"AUTH", c.Password})
+	}
+	if c.Database != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.Database)})
+	}
+	if len(setup) == 0 {
+		return nil
+	}
+	replies, err := c.roundTrip(ctx, setup)
+	if err == nil {
+		for _, reply := range replies {
+			if replyErr, ok := reply.(Error); ok {
+				err = replyErr
+				break
+			}
+		}
+	}
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Writes the commands and reads as many replies
+func (c *Client) roundTrip(ctx context.Context, commands [][]string) ([]any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.timeout())
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// Encoding commands as arrays of bulk strings
+	writer := bufio.NewWriter(c.conn)
+	for _, args := range commands {
+		fmt.Fprintf(writer, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(commands))
+	for i := range commands {
+		reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// Reads a reply
+func (c *Client) read() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed redis reply")
+	}
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return Error(value), nil
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		items := make([]any, size)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type %q", kind)
+	}
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return c.Timeout
+}
@@ -0,0 +1,396 @@
+// Package redistest provides an in-process Redis stand-in speaking enough of the protocol for the
+// revocation cache and the rate limits to be tested without a server
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Redis stand-in listening on a local port, keys live in memory and expire lazily
+type Server struct {
+	Addr     string // Host and port the client connects to
+	listener net.Listener
+	mu       sync.Mutex
+	strings  map[string]string
+	sets     map[string]map[string]float64
+	expires  map[string]time.Time
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// Starts a stand-in on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		strings:  make(map[string]string),
+		sets:     make(map[string]map[string]float64),
+		expires:  make(map[string]time.Time),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	server.wg.Add(1)
+	go server.accept()
+	return server, nil
+}
+
+// Stops listening and closes every connection
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Deletes a key as if the server evicted it
+func (s *Server) Evict(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delete(key)
+}
+
+// Reports whether a key exists
+func (s *Server) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exists(key)
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// Replies to the commands of a connection until it's closed
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		reply := s.execute(args)
+		s.mu.Unlock()
+		writeReply(writer, reply)
+		// Flushing once the pipelined commands are all read
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Error replied to a command
+type replyError string
+
+// Runs a command returning its reply
+func (s *Server) execute(args []string) any {
+	if len(args) == 0 {
+		return replyError("ERR empty command")
+	}
+	command, args := strings.ToUpper(args[0]), args[1:]
+	switch command {
+	case "PING":
+		return "PONG"
+	case "AUTH", "SELECT":
+		return "OK"
+	case "FLUSHALL":
+		s.strings = make(map[string]string)
+		s.sets = make(map[string]map[string]float64)
+		s.expires = make(map[string]time.Time)
+		return "OK"
+	case "GET":
+		if len(args) != 1 {
+			return arity(command)
+		}
+		return s.get(args[0])
+	case "MGET":
+		values := make([]any, len(args))
+		for i, key := range args {
+			values[i] = s.get(key)
+		}
+		return values
+	case "SET":
+		return s.set(args)
+	case "EXISTS":
+		var count int64
+		for _, key := range args {
+			if s.exists(key) {
+				count++
+			}
+		}
+		return count
+	case "DEL":
+		var count int64
+		for _, key := range args {
+			if s.exists(key) {
+				s.delete(key)
+				count++
+			}
+		}
+		return count
+	case "INCRBY":
+		if len(args) != 2 {
+			return arity(command)
+		}
+		amount, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		current := int64(0)
+		if value, ok := s.get(args[0]).(string); ok {
+			if current, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return replyError("ERR value is not an integer or out of range")
+			}
+		}
+		current += amount
+		s.strings[args[0]] = strconv.FormatInt(current, 10)
+		return current
+	case "PEXPIREAT":
+		if len(args) != 2 {
+			return arity(command)
+		}
+		unix, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		if !s.exists(args[0]) {
+			return int64(0)
+		}
+		s.expires[args[0]] = time.UnixMilli(unix)
+		return int64(1)
+	case "ZADD":
+		return s.zadd(args)
+	case "ZSCORE":
+		if len(args) != 2 {
+			return arity(command)
+		}
+		s.expire(args[0])
+		score, ok := s.sets[args[0]][args[1]]
+		if !ok {
+			return nil
+		}
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	default:
+		return replyError(fmt.Sprintf("ERR unknown command '%s'", command))
+	}
+}
+
+// Sets a string supporting the NX, EX, PX and PXAT options
+func (s *Server) set(args []string) any {
+	if len(args) < 2 {
+		return arity("SET")
+	}
+	key, value := args[0], args[1]
+	var (
+		expiration time.Time
+		nx         bool
+	)
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "NX" {
+			nx = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return replyError("ERR syntax error")
+		}
+		amount, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		i++
+		switch option {
+		case "EX":
+			expiration = time.Now().Add(time.Duration(amount) * time.Second)
+		case "PX":
+			expiration = time.Now().Add(time.Duration(amount) * time.Millisecond)
+		case "PXAT":
+			expiration = time.UnixMilli(amount)
+		default:
+			return replyError("ERR syntax error")
+		}
+	}
+	if nx && s.exists(key) {
+		return nil
+	}
+	s.delete(key)
+	s.strings[key] = value
+	if !expiration.IsZero() {
+		s.expires[key] = expiration
+	}
+	return "OK"
+}
+
+// Adds members to a sorted set supporting the GT option
+func (s *Server) zadd(args []string) any {
+	if len(args) < 3 {
+		return arity("ZADD")
+	}
+	key, args := args[0], args[1:]
+	gt := false
+	if strings.ToUpper(args[0]) == "GT" {
+		gt, args = true, args[1:]
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return replyError("ERR syntax error")
+	}
+	s.expire(key)
+	if _, ok := s.strings[key]; ok {
+		return replyError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	set, ok := s.sets[key]
+	if !ok {
+		set = make(map[string]float64)
+		s.sets[key] = set
+	}
+	var added int64
+	for i := 0; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return replyError("ERR value is not a valid float")
+		}
+		current, exists := set[args[i+1]]
+		if !exists {
+			added++
+		} else if gt && score <= current {
+			continue
+		}
+		set[args[i+1]] = score
+	}
+	return added
+}
+
+// Gets a string, nil if it doesn't exist
+func (s *Server) get(key string) any {
+	s.expire(key)
+	value, ok := s.strings[key]
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func (s *Server) exists(key string) bool {
+	s.expire(key)
+	_, isString := s.strings[key]
+	_, isSet := s.sets[key]
+	return isString || isSet
+}
+
+// Deletes a key if it expired
+func (s *Server) expire(key string) {
+	if expiration, ok := s.expires[key]; ok && !time.Now().Before(expiration) {
+		s.delete(key)
+	}
+}
+
+func (s *Server) delete(key string) {
+	delete(s.strings, key)
+	delete(s.sets, key)
+	delete(s.expires, key)
+}
+
+func arity(command string) replyError {
+	return replyError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
+}
+
+// Reads a command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected a bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// Encodes a reply, strings are bulk strings except OK and PONG which are simple ones
+func writeReply(writer *bufio.Writer, reply any) {
+	switch reply := reply.(type) {
+	case nil:
+		writer.WriteString("$-1\r\n")
+	case replyError:
+		fmt.Fprintf(writer, "-%s\r\n", reply)
+	case int64:
+		fmt.Fprintf(writer, ":%d\r\n", reply)
+	case string:
+		if reply == "OK" || reply == "PONG" {
+			fmt.Fprintf(writer, "+%s\r\n", reply)
+			return
+		}
+		fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(reply), reply)
+	case []any:
+		fmt.Fprintf(writer, "*%d\r\n", len(reply))
+		for _, item := range reply {
+			writeReply(writer, item)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/0xalby/based/client"
+	"github.com/0xalby/based/types"
+)
+
+const (
+	subjectVerification = "Email verification or account changes"
+	subjectRecovery     = "Account Recovery"
+	subjectChange       = "Email address change requested"
+	subjectChanged      = "Email address changed"
+)
+
+// Registers and logs into an account, verifying it if asked to
+func register(t *testing.T, api *testAPI, email string, verify bool) *client.Client {
+	t.Helper()
+	ctx := context.Background()
+	c := api.Client()
+	if err := c.Register(ctx, types.PayloadRegister{Email: email, Password: testPassword}); err != nil {
+		t.Fatalf("register %s: %v", email, err)
+	}
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: email, Password: testPassword}); err != nil {
+		t.Fatalf("login %s: %v", email, err)
+	}
+	if verify {
+		if err := c.Verify(ctx, api.Emailed(t, email, subjectVerification, codePattern)); err != nil {
+			t.Fatalf("verify %s: %v", email, err)
+		}
+	}
+	return c
+}
+
+func expect(t *testing.T, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("got %v, want %v", err, target)
+	}
+}
+
+func TestVerification(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	c := register(t, api, "verify@example.com", false)
+	expect(t, c.Verify(ctx, "AAAAAA"), client.ErrInvalidCode)
+	// Resending emails another code
+	if err := c.ResendVerification(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.Mail.To("verify@example.com")) < 2 {
+		t.Fatal("the verification email wasn't resent")
+	}
+	if err := c.Verify(ctx, api.Emailed(t, "verify@example.com", subjectVerification, codePattern)); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.ResendVerification(ctx), client.ErrAlreadyVerified)
+}
+
+func TestRecovery(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	register(t, api, "recover@example.com", true)
+	c := api.Client()
+	// Unknown addresses get the same response without an email
+	if err := c.Recovery(ctx, "nobody@example.com"); err != nil && !errors.Is(err, client.ErrAccountNotFound) {
+		t.Fatal(err)
+	}
+	if len(api.Mail.To("nobody@example.com")) != 0 {
+		t.Fatal("a recovery email was sent to an unknown address")
+	}
+	if err := c.Recovery(ctx, "recover@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	code := api.Emailed(t, "recover@example.com", subjectRecovery, codePattern)
+	expect(t, c.Reset(ctx, "AAAAAA", "another horse battery staple 43!"), client.ErrInvalidRecoveryCode)
+	if err := c.Reset(ctx, code, "another horse battery staple 43!"); err != nil {
+		t.Fatal(err)
+	}
+	// Codes can be used once
+	expect(t, c.Reset(ctx, code, "yet another horse battery staple 44!"), client.ErrInvalidRecoveryCode)
+	_, err := c.Login(ctx, types.PayloadLogin{Email: "recover@example.com", Password: testPassword})
+	expect(t, err, client.ErrInvalidCredentials)
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "recover@example.com", Password: "another horse battery staple 43!"}); err != nil {
+		t.Fatal(err)
+	}
+	// Tokens issued right after the reset revoked every session work, even within the same second
+	if err := c.UpdateLocale(ctx, "it"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	c := register(t, api, "old@example.com", true)
+	expect(t, c.SendConfirmationEmail(ctx, "old@example.com"), client.ErrSameEmail)
+	if err := c.SendConfirmationEmail(ctx, "new@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// The new address gets the code and the old one a way to cancel
+	code := api.Emailed(t, "new@example.com", subjectVerification, codePattern)
+	api.Emailed(t, "old@example.com", subjectChange, tokenPattern)
+	if err := c.UpdateEmail(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "new@example.com", Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := api.Client().Login(ctx, types.PayloadLogin{Email: "old@example.com", Password: testPassword})
+	expect(t, err, client.ErrAccountNotFound)
+	// The old address can take the account back, logging out every session
+	token := api.Emailed(t, "old@example.com", subjectChanged, tokenPattern)
+	if err := api.Client().CancelEmailChange(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, api.Client().CancelEmailChange(ctx, token), client.ErrEmailChangeNotFound)
+	expect(t, c.UpdateLocale(ctx, "it"), client.ErrTokenRevoked)
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "old@example.com", Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmailChangeCancelled(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	c := register(t, api, "keep@example.com", true)
+	if err := c.SendConfirmationEmail(ctx, "taken@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	code := api.Emailed(t, "taken@example.com", subjectVerification, codePattern)
+	if err := c.Recovery(ctx, "keep@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	recovery := api.Emailed(t, "keep@example.com", subjectRecovery, codePattern)
+	if err := api.Client().CancelEmailChange(ctx, api.Emailed(t, "keep@example.com", subjectChange, tokenPattern)); err != nil {
+		t.Fatal(err)
+	}
+	// The code sent to the new address is gone along with the change
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "keep@example.com", Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.UpdateEmail(ctx, code), client.ErrInvalidCode)
+	// While codes sent for anything else still work
+	if err := c.Reset(ctx, recovery, "another horse battery staple 43!"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmailChangeSuperseded(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	c := register(t, api, "owner@example.com", true)
+	if err := c.SendConfirmationEmail(ctx, "first@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	first := api.Emailed(t, "first@example.com", subjectVerification, codePattern)
+	if err := c.SendConfirmationEmail(ctx, "second@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	second := api.Emailed(t, "second@example.com", subjectVerification, codePattern)
+	if err := c.Recovery(ctx, "owner@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	recovery := api.Emailed(t, "owner@example.com", subjectRecovery, codePattern)
+	// The code sent to an address which is no longer pending can't confirm another one
+	expect(t, c.UpdateEmail(ctx, first), client.ErrInvalidCode)
+	if err := c.UpdateEmail(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "second@example.com", Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is pending anymore
+	expect(t, c.UpdateEmail(ctx, second), client.ErrInvalidCode)
+	// Confirming the change leaves the recovery in progress alone
+	if err := c.Reset(ctx, recovery, "another horse battery staple 43!"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmailChangeUnrecorded(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	c := register(t, api, "recorded@example.com", true)
+	if err := c.SendConfirmationEmail(ctx, "unrecorded@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	code := api.Emailed(t, "unrecorded@example.com", subjectVerification, codePattern)
+	if _, err := api.DB.Exec("DELETE FROM changes"); err != nil {
+		t.Fatal(err)
+	}
+	// Without a change the old address could revert, the email isn't changed
+	expect(t, c.UpdateEmail(ctx, code), client.ErrEmailChangeNotFound)
+	if _, err := c.Login(ctx, types.PayloadLogin{Email: "recorded@example.com", Password: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	basedv1 "github.com/0xalby/based/proto/based/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Connects to the gRPC API served next to the REST one in clear text
+func dialGRPC(t *testing.T, api *testAPI) *grpc.ClientConn {
+	t.Helper()
+	server := httptest.NewUnstartedServer(api.Handler)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+	conn, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCRateLimits(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, map[string]string{"GRPC_ENABLED": "true", "RATE_LIMIT_LOGIN": "2/1h email"})
+	auth := basedv1.NewAuthServiceClient(dialGRPC(t, api))
+	// Logging in over REST counts towards the limit of logging in over gRPC
+	register(t, api, "grpc@example.com", false)
+	login := &basedv1.LoginRequest{Email: "grpc@example.com", Password: testPassword}
+	if _, err := auth.Login(ctx, login); err != nil {
+		t.Fatal(err)
+	}
+	_, err := auth.Login(ctx, login)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("got %v, want %v", err, codes.ResourceExhausted)
+	}
+	var reason string
+	var retry *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			reason = detail.Reason
+		case *errdetails.RetryInfo:
+			retry = detail
+		}
+	}
+	if reason != "rate_limited" || retry == nil || retry.RetryDelay.AsDuration() <= 0 {
+		t.Fatalf("got reason %q and retry %v, want rate_limited with a delay", reason, retry)
+	}
+	// Other emails aren't limited
+	if _, err := auth.Login(ctx, &basedv1.LoginRequest{Email: "nobody@example.com", Password: testPassword}); status.Code(err) == codes.ResourceExhausted {
+		t.Fatalf("got %v logging into another account", err)
+	}
+}
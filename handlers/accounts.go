@@ -1,9 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"os"
 
+	"github.com/0xalby/based/locale"
 	"github.com/0xalby/based/services"
 	"github.com/0xalby/based/types"
 	"github.com/0xalby/based/utils"
@@ -13,6 +14,11 @@ type AccountsHandler struct {
 	AS *services.AccountsService
 	ES *services.EmailService
 	TS *services.TotpService
+	BS *services.BlacklistService
+	CS *services.ChangesService
+	PS *services.PasswordService
+	HS *services.HistoryService
+	DS *services.DomainService
 }
 
 func (handler *AccountsHandler) SendConfirmationEmail(w http.ResponseWriter, r *http.Request) {
@@ -26,64 +32,69 @@ func (handler *AccountsHandler) SendConfirmationEmail(w http.ResponseWriter, r *
 	if err := utils.Validate(w, r, &payload); err != nil {
 		return
 	}
+	// Normalizing the new address so it compares and gets stored like the others
+	email, err := utils.NormalizeEmail(payload.Email)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	payload.Email = email
+	// Refusing email domains the configuration doesn't allow
+	if err := handler.DS.Check(r.Context(), payload.Email); err != nil {
+		Fail(w, r, err)
+		return
+	}
 	// Generating a random code
 	code, err := utils.GenerateRandomCode(6)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Claiming the account id from request
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "failed to get claims" || err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByID(id)
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Ensuring emails are different
 	if account.Email == payload.Email {
-		utils.Response(w, http.StatusBadRequest,
-			map[string]interface{}{"message": "the new email has to be different from the old one", "status": http.StatusBadRequest},
-		)
+		Fail(w, r, ErrSameEmail)
+		return
+	}
+	// Letting the old address cancel the change in case the account was taken over, before any code is out
+	token, expiration, err := handler.CS.RequestEmailChange(r.Context(), id, account.Email, payload.Email)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	if err := handler.ES.SendEmailChangeEmail(r.Context(), account.Email, payload.Email, token, false, expiration); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Adds the code to the database
-	if err := handler.ES.AddVerificationCode(code, id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.ES.AddConfirmationCode(r.Context(), code, id, payload.Email); err != nil {
+		Fail(w, r, err)
+		return
 	}
 	// Saving pending email
-	if err := handler.AS.SavePending(payload.Email, id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.SavePending(r.Context(), payload.Email, id); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Sending confirmation email
-	if err := handler.ES.SendVerificationEmail(payload.Email, code); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.ES.SendVerificationEmail(r.Context(), payload.Email, code); err != nil {
+		Fail(w, r, err)
 		return
 	}
-	utils.Response(w, http.StatusOK, "confirmation email sent")
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"message": "confirmation email sent", "status": http.StatusOK},
+	)
 }
 
 func (handler *AccountsHandler) UpdateEmail(w http.ResponseWriter, r *http.Request) {
@@ -100,68 +111,107 @@ func (handler *AccountsHandler) UpdateEmail(w http.ResponseWriter, r *http.Reque
 	// Claiming the account id from request context
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByID(id)
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
-	// Comparing confirmation codes
-	if err := handler.ES.CompareCodes(payload.Code, id); err != nil {
-		if err.Error() == "invalid verification or confirmation code" || err.Error() == "verification or confirmation code has expired" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid confirmation code", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	// Nothing to confirm without a pending email
+	if account.Pending == "" {
+		Fail(w, r, services.ErrInvalidCode)
+		return
+	}
+	// Comparing confirmation codes, only the one sent to the pending email confirms it
+	if err := handler.ES.CompareCodes(r.Context(), payload.Code, id, account.Pending); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Letting the old address revert the change for a while, without a recorded change it can't so the email stays
+	change, token, err := handler.CS.ConfirmEmailChange(r.Context(), id, account.Pending)
+	if err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Updating account email
-	if err := handler.AS.UpdateAccountEmail(account.Pending, id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.UpdateAccountEmail(r.Context(), account.Pending, id); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Clean pending email
-	if err := handler.AS.CleanPendingEmail(id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.CleanPendingEmail(r.Context(), id); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Optionally send email notification
-	if os.Getenv("SMTP_ADDRESS") != "" {
+	if handler.ES.Enabled() {
 		// Sending a notification email
-		if err := handler.ES.SendNotificationEmail(account.Pending, "Updated email address", "Your email address has been updated"); err != nil {
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
+		if err := handler.ES.SendNotificationEmail(r.Context(), account.Pending, "Updated email address", "Your email address has been updated"); err != nil {
+			Fail(w, r, err)
+			return
+		}
+		if err := handler.ES.SendEmailChangeEmail(r.Context(), change.Old, change.New, token, true, change.Expiration); err != nil {
+			Fail(w, r, err)
 			return
 		}
 	}
 	// Sending a response
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "updated", "status": http.StatusOK},
 	)
 }
 
+/* Cancels a pending email change or reverts a confirmed one from the link sent to the old address */
+func (handler *AccountsHandler) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	// Creating a payload
+	var payload types.PayloadAccountCancelEmailChange
+	// Unmarshaling payload
+	if err := utils.Unmarshal(w, r, &payload); err != nil {
+		return
+	}
+	// Validating payload
+	if err := utils.Validate(w, r, &payload); err != nil {
+		return
+	}
+	// Cancelling the change
+	change, err := handler.CS.CancelEmailChange(r.Context(), payload.Token)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Giving the old address back to its owner
+	if change.Status == services.ChangeReverted {
+		if err := handler.AS.UpdateAccountEmail(r.Context(), change.Old, change.Account); err != nil {
+			Fail(w, r, err)
+			return
+		}
+	}
+	// Forgetting the pending email and its confirmation code
+	if err := handler.AS.CleanPendingEmail(r.Context(), change.Account); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	if err := handler.ES.DeleteConfirmationCodes(r.Context(), change.Account, change.New); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Logging out every session since whoever asked for the change might be in
+	if err := handler.BS.RevokeAccount(r.Context(), change.Account); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	message := "email change cancelled"
+	if change.Status == services.ChangeReverted {
+		message = "email change reverted"
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"message": message, "status": http.StatusOK},
+	)
+}
+
 func (handler *AccountsHandler) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	// Creating a payload
 	var payload types.PayloadAccountUpdatePassword
@@ -175,78 +225,101 @@ func (handler *AccountsHandler) UpdatePassword(w http.ResponseWriter, r *http.Re
 	}
 	// Ensuring the passwords are different
 	if payload.Old == payload.New {
-		utils.Response(w, http.StatusBadRequest,
-			map[string]interface{}{"message": "the new password has to be different from the old one", "status": http.StatusBadRequest})
+		Fail(w, r, ErrSamePassword)
 		return
 	}
 	// Claiming the account id from request context
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "failed to get claims" || err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByID(id)
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Comparing passwords
 	if !utils.CompareHashedAndPlain(account.Password, payload.Old) {
-		utils.Response(w, http.StatusUnauthorized,
-			map[string]interface{}{"message": "wrong password", "status": http.StatusUnauthorized},
-		)
+		Fail(w, r, ErrWrongPassword)
+		return
+	}
+	// Checking the new password against the policy
+	if err := handler.PS.Check(r.Context(), payload.New, account.Email); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Refusing passwords the account had recently
+	if err := handler.HS.Check(r.Context(), account, payload.New); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Hashing the new password
 	hashed, err := utils.Hash(payload.New)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Updating account password
-	if err := handler.AS.UpdateAccountPassword(hashed, id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.UpdateAccountPassword(r.Context(), hashed, id); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Remembering the replaced password
+	if err := handler.HS.Record(r.Context(), id, account.Password); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Optionally send email notification
-	if os.Getenv("SMTP_ADDRESS") != "" {
+	if handler.ES.Enabled() {
 		// Getting the account
-		account, err := handler.AS.GetAccountByID(id)
+		account, err := handler.AS.GetAccountByID(r.Context(), id)
 		if err != nil {
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
+			Fail(w, r, err)
 			return
 		}
 		// Sending a notification email
-		if err := handler.ES.SendNotificationEmail(account.Email, "Updated password", "Your password has been updated"); err != nil {
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
+		if err := handler.ES.SendNotificationEmail(r.Context(), account.Email, "Updated password", "Your password has been updated"); err != nil {
+			Fail(w, r, err)
 			return
 		}
 	}
 	// Sending a response
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "updated", "status": http.StatusOK},
 	)
 }
 
+func (handler *AccountsHandler) UpdateLocale(w http.ResponseWriter, r *http.Request) {
+	// Creating a payload
+	var payload types.PayloadAccountUpdateLocale
+	// Unmarshaling payload
+	if err := utils.Unmarshal(w, r, &payload); err != nil {
+		return
+	}
+	// Validating payload
+	if err := utils.Validate(w, r, &payload); err != nil {
+		return
+	}
+	// Claiming the account id from request context
+	id, err := utils.ContextClaimID(r)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Updating the preferred locale
+	if err := handler.AS.UpdateLocale(r.Context(), id, payload.Locale); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Responding in the new locale right away
+	r = r.WithContext(locale.Prefer(r.Context(), payload.Locale))
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"message": "updated", "locale": payload.Locale, "status": http.StatusOK},
+	)
+}
+
 func (handler *AccountsHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 	// Creating a payload
 	var payload types.PayloadAccountDelete
@@ -261,65 +334,46 @@ func (handler *AccountsHandler) DeleteAccount(w http.ResponseWriter, r *http.Req
 	// Claiming the account id from request context
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "failed to get claims" || err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByID(id)
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
 	if err != nil {
-		if err.Error() == "account not found" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "account not found", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Comparing passwords
 	if !utils.CompareHashedAndPlain(account.Password, payload.Password) {
-		utils.Response(w, http.StatusUnauthorized,
-			map[string]interface{}{"message": "wrong password", "status": http.StatusUnauthorized},
-		)
+		Fail(w, r, ErrWrongPassword)
+		return
+	}
+	// Forgetting the previous passwords
+	if err := handler.HS.Clear(r.Context(), id); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Deleting the account from the database
-	if err := handler.AS.DeleteAccount(id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.DeleteAccount(r.Context(), id); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Deleting leftover account codes
-	if err := handler.ES.DeleteCodes(id); err != nil {
-		if err.Error() != "no rows affected" {
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
+	if err := handler.ES.DeleteCodes(r.Context(), id); err != nil {
+		if !errors.Is(err, services.ErrNoRowsAffected) {
+			Fail(w, r, err)
 			return
 		}
 	}
 	// Optionally send email notification
-	if os.Getenv("SMTP_ADDRESS") != "" {
+	if handler.ES.Enabled() {
 		// Sending a notification email
-		if err := handler.ES.SendNotificationEmail(account.Email, "Deleted account", "Your account has been deleted, goodbye"); err != nil {
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
+		if err := handler.ES.SendNotificationEmail(r.Context(), account.Email, "Deleted account", "Your account has been deleted, goodbye"); err != nil {
+			Fail(w, r, err)
 			return
 		}
 	}
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "deleted", "status": http.StatusOK},
 	)
 }
@@ -336,40 +390,30 @@ func (handler *AccountsHandler) Recovery(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Getting account by email
-	account, err := handler.AS.GetAccountByEmail(payload.Email)
+	account, err := handler.AS.GetAccountByEmail(r.Context(), payload.Email)
 	if err != nil {
-		if err.Error() == "account not found" {
-			utils.Response(w, http.StatusBadRequest,
-				map[string]interface{}{"message": "account not existing", "status": http.StatusBadRequest})
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
+	// Emailing and responding in the account's preferred locale
+	r = r.WithContext(locale.Prefer(r.Context(), account.Locale))
 	// Generating a random code
 	code, err := utils.GenerateRandomCode(6)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Adding the recovery code to the database
-	if err := handler.ES.AddRecoveryCode(code, account.ID); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.ES.AddRecoveryCode(r.Context(), code, account.ID); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Sending a recovery email with the code
-	if err := handler.ES.SendRecoveryEmail(account.Email, code); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.ES.SendRecoveryEmail(r.Context(), account.Email, code); err != nil {
+		Fail(w, r, err)
+		return
 	}
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "recovery email sent", "status": http.StatusOK},
 	)
 }
@@ -386,47 +430,58 @@ func (handler *AccountsHandler) Reset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Getting account by code ownership
-	id, err := handler.ES.GetAccountIDByCodeOwnership(payload.Code)
+	id, err := handler.ES.GetAccountIDByCodeOwnership(r.Context(), payload.Code)
 	if err != nil {
-		if err.Error() == "invalid or expired code" {
-			utils.Response(w, http.StatusBadRequest,
-				map[string]interface{}{"message": "invalid or expired code", "status": http.StatusBadRequest})
-			return
+		// Only recovery codes are expected here
+		if errors.Is(err, services.ErrInvalidCode) {
+			err = ErrInvalidRecoveryCode
 		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
+		return
+	}
+	// Getting the account
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Checking the new password against the policy before the code is used up
+	if err := handler.PS.Check(r.Context(), payload.Password, account.Email); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Refusing passwords the account had recently
+	if err := handler.HS.Check(r.Context(), account, payload.Password); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Comparing recovery codes
-	if err := handler.ES.CompareRecoveryCodes(payload.Code, id); err != nil {
-		if err.Error() == "invalid recovery code" || err.Error() == "recovery code expired" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid recovery code", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.ES.CompareRecoveryCodes(r.Context(), payload.Code, id); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Hashing the new password
 	hashed, err := utils.Hash(payload.Password)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Resetting the password
-	if err := handler.AS.UpdateAccountPassword(hashed, id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.UpdateAccountPassword(r.Context(), hashed, id); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Remembering the replaced password
+	if err := handler.HS.Record(r.Context(), id, account.Password); err != nil {
+		Fail(w, r, err)
 		return
 	}
-	utils.Response(w, http.StatusOK,
+	// Logging out every session since the password might have been compromised
+	if err := handler.BS.RevokeAccount(r.Context(), id); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "recovered", "status": http.StatusOK},
 	)
 }
@@ -435,72 +490,50 @@ func (handler *AccountsHandler) AccountEnableTOTP(w http.ResponseWriter, r *http
 	// Claiming the account id from request context
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByID(id)
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Ensuring 2fa totp isn't already enabled
 	if account.TotpEnabled {
-		utils.Response(w, http.StatusForbidden,
-			map[string]interface{}{"message": "2fa already enabled", "status": http.StatusForbidden},
-		)
+		Fail(w, r, ErrTOTPEnabled)
 		return
 	}
 	// Generating a totp secret
-	key, err := handler.TS.GenerateTOTPSecret(account.Email, id)
+	key, err := handler.TS.GenerateTOTPSecret(r.Context(), account.Email, id)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "failed to generate totp secret", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Generating a qrcoode
 	qrCode, err := handler.TS.GenerateQRCode(key)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "failed to generate qrcode", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Enabling 2fa totp for the account
-	if err := handler.AS.EnableTOTP(id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.EnableTOTP(r.Context(), id); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Generating backup codes
 	codes, err := handler.TS.GenerateBackupCodes(12, 8)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Adding backup codes
-	if err := handler.TS.AddBackupCodes(codes, account.ID); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.TS.AddBackupCodes(r.Context(), codes, account.ID); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	/* Base64 encoded png image */
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		/* Here we could have an http redirect to the 2fa setup page */
 		map[string]interface{}{"message": "enabled", "secret": key.Secret(), "qr_code": qrCode, "backup": codes, "status": http.StatusOK},
 	)
@@ -510,47 +543,31 @@ func (handler *AccountsHandler) AccountDisableTOTP(w http.ResponseWriter, r *htt
 	// Claiming the account id from request context
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByID(id)
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Ensuring 2fa isn't already disabled
 	if !account.TotpEnabled {
-		utils.Response(w, http.StatusForbidden,
-			map[string]interface{}{"message": "2fa already disabled", "status": http.StatusForbidden},
-		)
+		Fail(w, r, ErrTOTPDisabled)
 		return
 	}
 	// Disabling 2fa totp for the account
-	if err := handler.AS.DisableTOTP(id); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.DisableTOTP(r.Context(), id); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Deleting leftover backup codes
-	if err := handler.TS.DeleteBackupCodes(account.ID); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.TS.DeleteBackupCodes(r.Context(), account.ID); err != nil {
+		Fail(w, r, err)
 		return
 	}
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "disabled", "status": http.StatusOK},
 	)
 }
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/0xalby/based/services"
+	"github.com/0xalby/based/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type AdminHandler struct {
+	OS *services.OutboxService
+	LS *services.LockoutService
+	JS *services.JanitorService
+}
+
+// Lists outbox emails optionally filtered by status
+func (handler *AdminHandler) ListOutbox(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", services.OutboxPending, services.OutboxSending, services.OutboxSent, services.OutboxDead:
+	default:
+		Fail(w, r, ErrInvalidParameter)
+		return
+	}
+	emails, err := handler.OS.List(r.Context(), status, 100)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"emails": emails, "status": http.StatusOK},
+	)
+}
+
+// Retries a dead outbox email
+func (handler *AdminHandler) RetryOutbox(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		Fail(w, r, ErrInvalidParameter)
+		return
+	}
+	if err := handler.OS.Retry(r.Context(), id); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"message": "retrying", "status": http.StatusOK},
+	)
+}
+
+// Unlocks an account locked after too many failed logins
+func (handler *AdminHandler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		Fail(w, r, ErrInvalidParameter)
+		return
+	}
+	if err := handler.LS.UnlockAccount(r.Context(), id); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"message": "unlocked", "status": http.StatusOK},
+	)
+}
+
+// Gets the rows the janitor of this instance purged since startup
+func (handler *AdminHandler) JanitorStats(w http.ResponseWriter, r *http.Request) {
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"purged": handler.JS.Stats(), "status": http.StatusOK},
+	)
+}
@@ -1,16 +1,17 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/0xalby/based/config"
+	"github.com/0xalby/based/locale"
 	"github.com/0xalby/based/services"
 	"github.com/0xalby/based/types"
 	"github.com/0xalby/based/utils"
+	"github.com/charmbracelet/log"
 	"github.com/go-chi/jwtauth/v5"
-	"github.com/google/uuid"
 )
 
 type AuthHandler struct {
@@ -18,6 +19,11 @@ type AuthHandler struct {
 	ES *services.EmailService
 	TS *services.TotpService
 	BS *services.BlacklistService
+	LS *services.LockoutService
+	PS *services.PasswordService
+	DS *services.DomainService
+	IS *services.InvitationsService
+	SS *services.SignInsService
 }
 
 func (handler *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -31,70 +37,68 @@ func (handler *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	if err := utils.Validate(w, r, &payload); err != nil {
 		return
 	}
+	// Refusing email domains the configuration doesn't allow
+	if err := handler.DS.Check(r.Context(), payload.Email); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Checking the password against the policy
+	if err := handler.PS.Check(r.Context(), payload.Password, payload.Email); err != nil {
+		Fail(w, r, err)
+		return
+	}
 	// Hashing the password
 	hashed, err := utils.Hash(payload.Password)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Creating an account
 	account := &types.Account{
 		Email:    payload.Email,
 		Password: hashed,
+		Locale:   payload.Locale,
 	}
-	if err := handler.AS.CreateAccount(account); err != nil {
-		// switch err.Error()
-		if err.Error() == "email already used" {
-			utils.Response(w, http.StatusConflict,
-				map[string]interface{}{"message": "email already used", "status": http.StatusConflict},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	// Responding and emailing in the preferred locale if given
+	r = r.WithContext(locale.Prefer(r.Context(), payload.Locale))
+	// Letting the registration through depending on the mode, using up the invitation if one is needed
+	if err := handler.IS.Admit(r.Context(), payload.Invitation, payload.Email); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	if err := handler.AS.CreateAccount(r.Context(), account); err != nil {
+		// Giving the invitation back since nobody registered with it
+		handler.IS.Release(r.Context(), payload.Invitation)
+		Fail(w, r, err)
 		return
 	}
 	// Optionally sending a verification email
-	if os.Getenv("SMTP_ADDRESS") != "" {
+	if handler.ES.Enabled() {
 		// Generating a random code
 		code, err := utils.GenerateRandomCode(6)
 		if err != nil {
-			utils.Response(w, http.StatusInternalServerError, "internal server error")
+			Fail(w, r, err)
 			return
 		}
 		// Sending a verification email
-		if err := handler.ES.SendVerificationEmail(account.Email, code); err != nil {
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
+		if err := handler.ES.SendVerificationEmail(r.Context(), account.Email, code); err != nil {
+			Fail(w, r, err)
 			return
 		}
 		// Getting account by email
-		account, err = handler.AS.GetAccountByEmail(account.Email)
+		account, err = handler.AS.GetAccountByEmail(r.Context(), account.Email)
 		if err != nil {
-			if err.Error() == "account not found" {
-				utils.Response(w, http.StatusBadRequest,
-					map[string]interface{}{"message": "account not existing", "status": http.StatusBadRequest},
-				)
-				return
-			}
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
+			Fail(w, r, err)
 			return
 		}
 		// Adding the verification code to the database
-		if err := handler.ES.AddVerificationCode(code, account.ID); err != nil {
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
+		if err := handler.ES.AddVerificationCode(r.Context(), code, account.ID); err != nil {
+			Fail(w, r, err)
+			return
 		}
 	}
 	// Sending a response
-	utils.Response(w, http.StatusCreated,
+	utils.Response(w, r, http.StatusCreated,
 		/* Here we could have an http redirect to the email verification page */
 		map[string]interface{}{"message": "created", "status": http.StatusCreated},
 	)
@@ -113,53 +117,61 @@ func (handler *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByEmail(payload.Email)
+	account, err := handler.AS.GetAccountByEmail(r.Context(), payload.Email)
 	if err != nil {
-		if err.Error() == "account not found" {
-			utils.Response(w, http.StatusBadRequest,
-				map[string]interface{}{"message": "account not existing", "status": http.StatusBadRequest},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
+	// Reserving the attempt as a failed one before comparing anything so concurrent logins can't get past the
+	// limits, refusing logins to locked accounts and until the delay after the last failure is over
+	attempt, err := handler.LS.Reserve(r.Context(), account.ID)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Giving the attempt back if the login neither fails nor succeeds
+	defer handler.LS.Release(r.Context(), attempt)
 	// Comparing passwords
 	if !utils.CompareHashedAndPlain(account.Password, payload.Password) {
-		utils.Response(w, http.StatusUnauthorized,
-			map[string]interface{}{"message": "invalid credentials", "status": http.StatusUnauthorized},
-		)
+		handler.failedLogin(w, r, account, attempt, ErrInvalidCredentials)
 		return
 	}
 	// Asking for totp validation if the account has it enabled
 	if account.TotpEnabled {
-		valid, err := handler.TS.ValidateTOTP(account.ID, payload.TOTP)
+		valid, err := handler.TS.ValidateTOTP(r.Context(), account.ID, payload.TOTP)
+		if err != nil {
+			Fail(w, r, err)
+			return
+		}
 		if !valid {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "wrong totp code", "status": http.StatusUnauthorized},
-			)
+			handler.failedLogin(w, r, account, attempt, ErrWrongTOTP)
 			return
 		}
+	}
+	// Telling the owner about sign-ins from new devices or locations
+	if !handler.signIn(w, r, account, attempt, payload.SignInCode) {
+		return
+	}
+	// Forgetting previous failures
+	if err := handler.LS.Succeed(r.Context(), attempt); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Upgrading the hash to the current algorithm and parameters while the password is at hand
+	if utils.NeedsRehash(account.Password) {
+		hashed, err := utils.Hash(payload.Password)
+		if err == nil {
+			err = handler.AS.UpdateAccountPassword(r.Context(), hashed, account.ID)
+		}
+		// Not failing the login over it, the hash gets upgraded on the next one
 		if err != nil {
-			utils.Response(w, http.StatusInternalServerError,
-				map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-			)
-			return
+			log.Error("failed to rehash the password", "err", err)
 		}
 	}
 	// Generating a new jwt token providing access to protected routes for some time
-	expiration := time.Now().Add(time.Hour * 24 * 7)
-	_, token, err := config.TokenAuth.Encode(map[string]interface{}{
-		"account": account.ID,
-		"exp":     expiration.Unix(),
-		"jti":     uuid.New().String(),
-	})
+	token, expiration, err := config.IssueToken(account.ID)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Setting the jwt token as a secure httponly cookie
@@ -172,7 +184,7 @@ func (handler *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   int(time.Until(expiration).Seconds()),
 		SameSite: http.SameSiteLaxMode,
 		Expires:  expiration})
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		/* Here we could have an http redirect to the dashboard page */
 		map[string]interface{}{"message": "token generated", "token": token, "redirect": "/", "status": http.StatusOK},
 	)
@@ -192,53 +204,33 @@ func (handler *AuthHandler) Verification(w http.ResponseWriter, r *http.Request)
 	// Claiming the account id from request context
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "failed to get claims" || err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByID(id)
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
+	// Responding in the account's preferred locale
+	r = r.WithContext(locale.Prefer(r.Context(), account.Locale))
 	// Ensuring the account isn't already verified
 	if account.Verified {
-		utils.Response(w, http.StatusForbidden,
-			map[string]interface{}{"message": "account already verified", "status": http.StatusForbidden},
-		)
+		Fail(w, r, ErrAlreadyVerified)
 		return
 	}
 	// Comparing verification codes
-	if err := handler.ES.CompareCodes(payload.Code, account.ID); err != nil {
-		if err.Error() == "invalid verification or confirmation code" || err.Error() == "verification or confirmation code has expired" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid or expired code", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.ES.CompareCodes(r.Context(), payload.Code, account.ID, ""); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Marking account as verified
-	if err := handler.AS.MarkAccountAsVerified(account.ID); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.AS.MarkAccountAsVerified(r.Context(), account.ID); err != nil {
+		Fail(w, r, err)
 		return
 	}
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "verified", "status": http.StatusOK},
 	)
 }
@@ -248,54 +240,39 @@ func (handler *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Re
 	// Claiming the account id from request context
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "failed to get claims" || err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByID(id)
+	account, err := handler.AS.GetAccountByID(r.Context(), id)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
+	// Responding in the account's preferred locale
+	r = r.WithContext(locale.Prefer(r.Context(), account.Locale))
 	// Ensuring the account isn't already verified
 	if account.Verified {
-		utils.Response(w, http.StatusForbidden,
-			map[string]interface{}{"message": "account already verified", "status": http.StatusForbidden},
-		)
+		Fail(w, r, ErrAlreadyVerified)
 		return
 	}
 	// Generating a random code
 	code, err := utils.GenerateRandomCode(6)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Sending the verification email
-	if err := handler.ES.SendVerificationEmail(account.Email, code); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.ES.SendVerificationEmail(r.Context(), account.Email, code); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Adding the verification code to the database
-	if err := handler.ES.AddVerificationCode(code, account.ID); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.ES.AddVerificationCode(r.Context(), code, account.ID); err != nil {
+		Fail(w, r, err)
+		return
 	}
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "verification email resent", "status": http.StatusOK},
 	)
 }
@@ -304,59 +281,35 @@ func (handler *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	// Claiming the account id from request context
 	id, err := utils.ContextClaimID(r)
 	if err != nil {
-		if err.Error() == "failed to get claims" || err.Error() == "account not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Extracting the jwt token from the request
 	token, _, err := jwtauth.FromContext(r.Context())
 	if err != nil {
-		utils.Response(w, http.StatusUnauthorized,
-			map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-		)
+		Fail(w, r, ErrInvalidToken)
 		return
 	}
 	// Getting the token id
 	tokenID := token.JwtID()
 	if tokenID == "" {
-		utils.Response(w, http.StatusUnauthorized,
-			map[string]interface{}{"message": "missing token id", "status": http.StatusUnauthorized},
-		)
+		Fail(w, r, ErrInvalidToken)
 		return
 	}
 	// Claiming the jwt token expiration from the request
 	exp, err := utils.ContextClaimExpiration(r)
 	if err != nil {
-		if err.Error() == "failed to get claims" || err.Error() == "expiration not found in claims or not a float64" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Ensure the jwt token is not already expired
 	if exp.Before(time.Now()) {
-		utils.Response(w, http.StatusUnauthorized,
-			map[string]interface{}{"message": "token has already expired", "status": http.StatusUnauthorized},
-		)
+		Fail(w, r, ErrTokenExpired)
 		return
 	}
 	// Revoking the jwt token
-	if err := handler.BS.RevokeToken(tokenID, id, exp); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.BS.RevokeToken(r.Context(), tokenID, id, exp); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Clear the jwt cookie
@@ -369,7 +322,7 @@ func (handler *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   -1, // Expire the cookie immediately
 		SameSite: http.SameSiteLaxMode,
 	})
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		map[string]interface{}{"message": "logged out", "status": http.StatusOK},
 	)
 }
@@ -386,86 +339,192 @@ func (handler *AuthHandler) LoginWithBackupCode(w http.ResponseWriter, r *http.R
 		return
 	}
 	// Getting the account
-	account, err := handler.AS.GetAccountByEmail(payload.Email)
+	account, err := handler.AS.GetAccountByEmail(r.Context(), payload.Email)
 	if err != nil {
-		if err.Error() == "account not found" {
-			utils.Response(w, http.StatusBadRequest,
-				map[string]interface{}{"message": "account not existing", "status": http.StatusBadRequest},
-			)
-			return
-		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Ensuring the email is verified
 	if !account.Verified {
-		utils.Response(w, http.StatusUnauthorized,
-			map[string]interface{}{"message": "account not verified", "status": http.StatusUnauthorized},
-		)
+		Fail(w, r, ErrNotVerified)
+		return
+	}
+	// Reserving the attempt as a failed one before comparing anything so concurrent logins can't get past the
+	// limits, refusing logins to locked accounts and until the delay after the last failure is over
+	attempt, err := handler.LS.Reserve(r.Context(), account.ID)
+	if err != nil {
+		Fail(w, r, err)
 		return
 	}
+	// Giving the attempt back if the login neither fails nor succeeds
+	defer handler.LS.Release(r.Context(), attempt)
 	// Validate the backup code
-	if err := handler.TS.ValidateBackupCode(account.ID, payload.BackupCode); err != nil {
-		if err.Error() == "code not found" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "code not found", "status": http.StatusUnauthorized},
-			)
-			return
-		}
-		if err.Error() == "invalid backup code" {
-			utils.Response(w, http.StatusUnauthorized,
-				map[string]interface{}{"message": "invalid backup code", "status": http.StatusUnauthorized},
-			)
+	if err := handler.TS.ValidateBackupCode(r.Context(), account.ID, payload.BackupCode); err != nil {
+		if errors.Is(err, services.ErrInvalidBackupCode) || errors.Is(err, services.ErrBackupCodeNotFound) {
+			handler.failedLogin(w, r, account, attempt, err)
 			return
 		}
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
+		return
+	}
+	// Telling the owner about sign-ins from new devices or locations
+	if !handler.signIn(w, r, account, attempt, payload.SignInCode) {
+		return
+	}
+	// Forgetting previous failures
+	if err := handler.LS.Succeed(r.Context(), attempt); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Deleting backup codes for the account
-	if err := handler.TS.DeleteBackupCodes(account.ID); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.TS.DeleteBackupCodes(r.Context(), account.ID); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	// Generating a totp secret
-	key, err := handler.TS.GenerateTOTPSecret(account.Email, account.ID)
+	key, err := handler.TS.GenerateTOTPSecret(r.Context(), account.Email, account.ID)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "failed to generate totp secret", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Generating a qrcoode
 	qrCode, err := handler.TS.GenerateQRCode(key)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError, map[string]interface{}{
-			"message": "failed to generate qrcode", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Generating backup codes
 	codes, err := handler.TS.GenerateBackupCodes(12, 8)
 	if err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+		Fail(w, r, err)
 		return
 	}
 	// Adding backup codes
-	if err := handler.TS.AddBackupCodes(codes, account.ID); err != nil {
-		utils.Response(w, http.StatusInternalServerError,
-			map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-		)
+	if err := handler.TS.AddBackupCodes(r.Context(), codes, account.ID); err != nil {
+		Fail(w, r, err)
 		return
 	}
 	/* Base64 encoded png image */
-	utils.Response(w, http.StatusOK,
+	utils.Response(w, r, http.StatusOK,
 		/* Here we could have an http redirect to the 2fa setup page */
 		map[string]interface{}{"message": "enabled", "secret": key.Secret(), "qr_code": qrCode, "backup": codes, "status": http.StatusOK},
 	)
 }
+
+// Settles an attempt as failed responding with why, if it locks the account the owner is emailed an unlock link
+func (handler *AuthHandler) failedLogin(w http.ResponseWriter, r *http.Request, account *types.Account, attempt *services.Attempt, reason error) {
+	token, until := handler.LS.Fail(attempt)
+	if token == "" {
+		Fail(w, r, reason)
+		return
+	}
+	if handler.ES.Enabled() {
+		// Emailing in the account's preferred locale
+		ctx := locale.Prefer(r.Context(), account.Locale)
+		if err := handler.ES.SendUnlockEmail(ctx, account.Email, token, until); err != nil {
+			Fail(w, r, err)
+			return
+		}
+	}
+	Fail(w, r, &services.LockedError{Err: services.ErrAccountLocked, Until: until})
+}
+
+// Compares where and how an account signs in with its earlier sign-ins, new contexts are emailed to the owner
+// with a link logging out every session or, if they have to be confirmed, get a code emailed which the next
+// login has to include. Reports whether the login can go on, having responded otherwise
+func (handler *AuthHandler) signIn(w http.ResponseWriter, r *http.Request, account *types.Account, attempt *services.Attempt, code string) bool {
+	network, agent := services.Fingerprint(utils.ClientIP(r), r.UserAgent())
+	known, err := handler.SS.Known(r.Context(), account.ID, network, agent)
+	if err != nil {
+		Fail(w, r, err)
+		return false
+	}
+	// Emailing in the account's preferred locale
+	ctx := locale.Prefer(r.Context(), account.Locale)
+	if !known && handler.SS.Verify && handler.ES.Enabled() {
+		if code == "" {
+			code, err := handler.SS.Challenge(r.Context(), account.ID, network, agent)
+			if err != nil {
+				Fail(w, r, err)
+				return false
+			}
+			if err := handler.ES.SendVerificationEmail(ctx, account.Email, code); err != nil {
+				Fail(w, r, err)
+				return false
+			}
+			Fail(w, r, ErrSignInUnconfirmed)
+			return false
+		}
+		// Wrong codes count as failed logins so they can't be guessed
+		if err := handler.SS.Confirm(r.Context(), account.ID, network, agent, code); err != nil {
+			if errors.Is(err, services.ErrInvalidSignInCode) {
+				handler.failedLogin(w, r, account, attempt, err)
+				return false
+			}
+			Fail(w, r, err)
+			return false
+		}
+		return true
+	}
+	token, err := handler.SS.Record(r.Context(), account.ID, network, agent)
+	if err != nil {
+		Fail(w, r, err)
+		return false
+	}
+	if !known && handler.ES.Enabled() {
+		// Not letting the notification stop the owner from signing in
+		if err := handler.ES.SendSignInEmail(ctx, account.Email, token, network, agent, time.Now()); err != nil {
+			log.Error("failed to send new sign-in email", "err", err)
+		}
+	}
+	return true
+}
+
+// Logs out every session of the account a new sign-in was emailed for
+func (handler *AuthHandler) Secure(w http.ResponseWriter, r *http.Request) {
+	// Creating a payload
+	var payload types.PayloadSecure
+	// Unmarshaling payload
+	if err := utils.Unmarshal(w, r, &payload); err != nil {
+		return
+	}
+	// Validating payload
+	if err := utils.Validate(w, r, &payload); err != nil {
+		return
+	}
+	// Forgetting the sign-in so it's noticed again
+	account, err := handler.SS.Secure(r.Context(), payload.Token)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Logging out every session, whoever signed in included
+	if err := handler.BS.RevokeAccount(r.Context(), account); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"message": "every session logged out", "status": http.StatusOK},
+	)
+}
+
+func (handler *AuthHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	// Creating a payload
+	var payload types.PayloadUnlock
+	// Unmarshaling payload
+	if err := utils.Unmarshal(w, r, &payload); err != nil {
+		return
+	}
+	// Validating payload
+	if err := utils.Validate(w, r, &payload); err != nil {
+		return
+	}
+	// Unlocking the account the token was emailed for
+	if _, err := handler.LS.Unlock(r.Context(), payload.Token); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"message": "unlocked", "status": http.StatusOK},
+	)
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/0xalby/based/challenge"
+	"github.com/0xalby/based/utils"
+)
+
+type ChallengeHandler struct {
+	PoW *challenge.ProofOfWork
+}
+
+// Issues a proof of work challenge bound to the client address
+func (handler *ChallengeHandler) Issue(w http.ResponseWriter, r *http.Request) {
+	puzzle, err := handler.PoW.Issue(utils.ClientIP(r))
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{
+			"message":    "challenge issued",
+			"challenge":  puzzle.Challenge,
+			"difficulty": puzzle.Difficulty,
+			"expiration": puzzle.Expiration,
+			"status":     http.StatusOK,
+		},
+	)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/0xalby/based/services"
+	"github.com/0xalby/based/utils"
+	"github.com/charmbracelet/log"
+)
+
+// Problems responded with, their codes are stable so clients can rely on them instead of messages
+var (
+	ErrInternal               = utils.NewProblem(http.StatusInternalServerError, "internal_error", "internal server error")
+	ErrInvalidToken           = utils.NewProblem(http.StatusUnauthorized, "invalid_token", "invalid token")
+	ErrTokenExpired           = utils.NewProblem(http.StatusUnauthorized, "token_expired", "token has already expired")
+	ErrTokenRevoked           = utils.NewProblem(http.StatusUnauthorized, "token_revoked", "token revoked")
+	ErrAccountNotFound        = utils.NewProblem(http.StatusBadRequest, "account_not_found", "account not existing")
+	ErrEmailUsed              = utils.NewProblem(http.StatusConflict, "email_already_used", "email already used")
+	ErrInvalidCredentials     = utils.NewProblem(http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
+	ErrWrongPassword          = utils.NewProblem(http.StatusUnauthorized, "wrong_password", "wrong password")
+	ErrWrongTOTP              = utils.NewProblem(http.StatusUnauthorized, "wrong_totp", "wrong totp code")
+	ErrInvalidCode            = utils.NewProblem(http.StatusUnauthorized, "invalid_code", "invalid or expired code")
+	ErrInvalidRecoveryCode    = utils.NewProblem(http.StatusUnauthorized, "invalid_recovery_code", "invalid or expired code")
+	ErrInvalidBackupCode      = utils.NewProblem(http.StatusUnauthorized, "invalid_backup_code", "invalid backup code")
+	ErrAlreadyVerified        = utils.NewProblem(http.StatusForbidden, "account_already_verified", "account already verified")
+	ErrNotVerified            = utils.NewProblem(http.StatusForbidden, "account_not_verified", "account not verified")
+	ErrSameEmail              = utils.NewProblem(http.StatusBadRequest, "same_email", "the new email has to be different from the old one")
+	ErrSamePassword           = utils.NewProblem(http.StatusBadRequest, "same_password", "the new password has to be different from the old one")
+	ErrTOTPEnabled            = utils.NewProblem(http.StatusForbidden, "totp_already_enabled", "2fa already enabled")
+	ErrTOTPDisabled           = utils.NewProblem(http.StatusForbidden, "totp_already_disabled", "2fa already disabled")
+	ErrEmailChangeNotFound    = utils.NewProblem(http.StatusNotFound, "email_change_not_found", "invalid or expired token")
+	ErrOutboxEmailNotFound    = utils.NewProblem(http.StatusNotFound, "email_not_found", "dead email not found")
+	ErrInvalidParameter       = utils.NewProblem(http.StatusBadRequest, "invalid_parameter", "invalid parameter")
+	ErrAccountLocked          = utils.NewProblem(http.StatusLocked, "account_locked", "account locked after too many failed logins")
+	ErrLoginDelayed           = utils.NewProblem(http.StatusTooManyRequests, "login_delayed", "too many failed logins, try again later")
+	ErrUnlockTokenNotFound    = utils.NewProblem(http.StatusNotFound, "unlock_token_not_found", "invalid or expired token")
+	ErrAccountNotLocked       = utils.NewProblem(http.StatusNotFound, "account_not_locked", "account not locked")
+	ErrPasswordTooShort       = utils.NewProblem(http.StatusBadRequest, "password_too_short", "the password is too short")
+	ErrPasswordTooLong        = utils.NewProblem(http.StatusBadRequest, "password_too_long", "the password is too long")
+	ErrPasswordTooWeak        = utils.NewProblem(http.StatusBadRequest, "password_too_weak", "the password is too easy to guess, try a longer passphrase")
+	ErrPasswordContainsEmail  = utils.NewProblem(http.StatusBadRequest, "password_contains_email", "the password can't contain the email address")
+	ErrPasswordBreached       = utils.NewProblem(http.StatusBadRequest, "password_breached", "the password appeared in a data breach, choose another one")
+	ErrPasswordReused         = utils.NewProblem(http.StatusBadRequest, "password_reused", "the password was used recently, choose another one")
+	ErrEmailDomainNotAllowed  = utils.NewProblem(http.StatusBadRequest, "email_domain_not_allowed", "email addresses from this domain aren't allowed")
+	ErrEmailDisposable        = utils.NewProblem(http.StatusBadRequest, "email_disposable", "disposable email addresses aren't allowed")
+	ErrEmailDomainUnreachable = utils.NewProblem(http.StatusBadRequest, "email_domain_unreachable", "the email domain can't receive emails")
+	ErrRegistrationClosed     = utils.NewProblem(http.StatusForbidden, "registration_closed", "registration is closed")
+	ErrInvitationRequired     = utils.NewProblem(http.StatusForbidden, "invitation_required", "registering takes an invitation")
+	ErrInvalidInvitation      = utils.NewProblem(http.StatusForbidden, "invalid_invitation", "invalid or expired invitation")
+	ErrInvitationLimit        = utils.NewProblem(http.StatusForbidden, "invitation_limit", "no invitations left, wait for some to be used or expire")
+	ErrInvitationNotFound     = utils.NewProblem(http.StatusNotFound, "invitation_not_found", "invitation not found")
+	ErrSignInUnconfirmed      = utils.NewProblem(http.StatusForbidden, "signin_unconfirmed", "sign-in from a new device or location, enter the code emailed to you")
+	ErrInvalidSignInCode      = utils.NewProblem(http.StatusUnauthorized, "invalid_signin_code", "invalid or expired code")
+	ErrSignInNotFound         = utils.NewProblem(http.StatusNotFound, "signin_not_found", "invalid or expired token")
+)
+
+// Problems service errors map to, anything else is an internal server error
+var problems = []struct {
+	err     error
+	problem *utils.Problem
+}{
+	{utils.ErrInvalidClaims, ErrInvalidToken},
+	{services.ErrAccountNotFound, ErrAccountNotFound},
+	{services.ErrEmailUsed, ErrEmailUsed},
+	{services.ErrInvalidCode, ErrInvalidCode},
+	{services.ErrExpiredCode, ErrInvalidCode},
+	{services.ErrInvalidRecoveryCode, ErrInvalidRecoveryCode},
+	{services.ErrExpiredRecoveryCode, ErrInvalidRecoveryCode},
+	{services.ErrBackupCodeNotFound, ErrInvalidBackupCode},
+	{services.ErrInvalidBackupCode, ErrInvalidBackupCode},
+	{services.ErrEmailChangeNotFound, ErrEmailChangeNotFound},
+	{services.ErrEmailNotFound, ErrOutboxEmailNotFound},
+	{services.ErrAccountLocked, ErrAccountLocked},
+	{services.ErrLoginDelayed, ErrLoginDelayed},
+	{services.ErrUnlockTokenNotFound, ErrUnlockTokenNotFound},
+	{services.ErrAccountNotLocked, ErrAccountNotLocked},
+	{services.ErrPasswordTooShort, ErrPasswordTooShort},
+	{services.ErrPasswordTooLong, ErrPasswordTooLong},
+	{services.ErrPasswordTooWeak, ErrPasswordTooWeak},
+	{services.ErrPasswordContainsEmail, ErrPasswordContainsEmail},
+	{services.ErrPasswordBreached, ErrPasswordBreached},
+	{services.ErrPasswordReused, ErrPasswordReused},
+	{services.ErrEmailDomainNotAllowed, ErrEmailDomainNotAllowed},
+	{services.ErrEmailDisposable, ErrEmailDisposable},
+	{services.ErrEmailDomainUnreachable, ErrEmailDomainUnreachable},
+	{services.ErrRegistrationClosed, ErrRegistrationClosed},
+	{services.ErrInvitationRequired, ErrInvitationRequired},
+	{services.ErrInvalidInvitation, ErrInvalidInvitation},
+	{services.ErrInvitationLimit, ErrInvitationLimit},
+	{services.ErrInvitationNotFound, ErrInvitationNotFound},
+	{services.ErrInvalidSignInCode, ErrInvalidSignInCode},
+	{services.ErrSignInNotFound, ErrSignInNotFound},
+}
+
+// Maps an error to the problem responded with
+func ProblemFor(err error) *utils.Problem {
+	var problem *utils.Problem
+	if errors.As(err, &problem) {
+		return problem
+	}
+	for _, mapping := range problems {
+		if errors.Is(err, mapping.err) {
+			return mapping.problem
+		}
+	}
+	return ErrInternal
+}
+
+// Responds with the problem an error maps to
+func Fail(w http.ResponseWriter, r *http.Request, err error) {
+	problem := ProblemFor(err)
+	if problem == ErrInternal {
+		log.Error("internal server error", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+	// Telling clients when to try logging in again
+	var locked *services.LockedError
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(locked.Until).Seconds()))))
+	}
+	utils.WriteProblem(w, r, problem)
+}
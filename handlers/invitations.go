@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/0xalby/based/services"
+	"github.com/0xalby/based/types"
+	"github.com/0xalby/based/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type InvitationsHandler struct {
+	IS *services.InvitationsService
+	ES *services.EmailService
+	AS *services.AccountsService
+}
+
+// Creates an invitation for the account, single use and lasting the default validity
+func (handler *InvitationsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	// Claiming the account id from request context
+	id, err := utils.ContextClaimID(r)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	handler.create(w, r, id)
+}
+
+// Lists the invitations of the account
+func (handler *InvitationsHandler) List(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ContextClaimID(r)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	handler.list(w, r, id)
+}
+
+// Revokes an invitation of the account
+func (handler *InvitationsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ContextClaimID(r)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	handler.revoke(w, r, id)
+}
+
+// Creates an invitation as an admin, able to set how many registrations it allows and how long it lasts
+func (handler *InvitationsHandler) AdminCreate(w http.ResponseWriter, r *http.Request) {
+	handler.create(w, r, 0)
+}
+
+// Lists every invitation
+func (handler *InvitationsHandler) AdminList(w http.ResponseWriter, r *http.Request) {
+	handler.list(w, r, 0)
+}
+
+// Revokes any invitation
+func (handler *InvitationsHandler) AdminRevoke(w http.ResponseWriter, r *http.Request) {
+	handler.revoke(w, r, 0)
+}
+
+func (handler *InvitationsHandler) create(w http.ResponseWriter, r *http.Request, inviter int) {
+	// Creating a payload
+	var payload types.PayloadInvitation
+	// Unmarshaling payload
+	if err := utils.Unmarshal(w, r, &payload); err != nil {
+		return
+	}
+	// Validating payload
+	if err := utils.Validate(w, r, &payload); err != nil {
+		return
+	}
+	// Binding takes an address to bind to
+	if payload.Bind && payload.Email == "" {
+		Fail(w, r, ErrInvalidParameter)
+		return
+	}
+	bound := ""
+	if payload.Bind {
+		bound = payload.Email
+	}
+	token, invitation, err := handler.IS.Create(r.Context(), inviter, bound, payload.Uses, time.Duration(payload.Days)*24*time.Hour)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	// Optionally emailing the invitation naming the account which sent it
+	if payload.Email != "" && handler.ES.Enabled() {
+		name := ""
+		if inviter != 0 {
+			account, err := handler.AS.GetAccountByID(r.Context(), inviter)
+			if err != nil {
+				Fail(w, r, err)
+				return
+			}
+			name = account.Email
+		}
+		if err := handler.ES.SendInvitationEmail(r.Context(), payload.Email, token, name, invitation.Expiration); err != nil {
+			Fail(w, r, err)
+			return
+		}
+	}
+	// The token is only ever shown here
+	utils.Response(w, r, http.StatusCreated,
+		map[string]interface{}{"message": "invitation created", "invitation": invitation, "token": token, "status": http.StatusCreated},
+	)
+}
+
+func (handler *InvitationsHandler) list(w http.ResponseWriter, r *http.Request, inviter int) {
+	invitations, err := handler.IS.List(r.Context(), inviter)
+	if err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"invitations": invitations, "status": http.StatusOK},
+	)
+}
+
+func (handler *InvitationsHandler) revoke(w http.ResponseWriter, r *http.Request, inviter int) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		Fail(w, r, ErrInvalidParameter)
+		return
+	}
+	if err := handler.IS.Revoke(r.Context(), id, inviter); err != nil {
+		Fail(w, r, err)
+		return
+	}
+	utils.Response(w, r, http.StatusOK,
+		map[string]interface{}{"message": "invitation revoked", "status": http.StatusOK},
+	)
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/0xalby/based/openapi"
+	"github.com/0xalby/based/utils"
+)
+
+type OpenAPIHandler struct {
+	Spec *openapi.Document // Set once every route is registered
+}
+
+// Responds with the openapi document of the registered routes
+func (handler *OpenAPIHandler) Document(w http.ResponseWriter, r *http.Request) {
+	utils.Response(w, r, http.StatusOK, handler.Spec)
+}
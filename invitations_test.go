@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0xalby/based/services"
+)
+
+func TestPurgeInvitations(t *testing.T) {
+	ctx := context.Background()
+	db := migrate(t)
+	invitations := &services.InvitationsService{DB: db, Mode: services.RegistrationInvite, Validity: time.Hour}
+	janitor := &services.JanitorService{DB: db}
+	used, _, err := invitations.Create(ctx, 0, "", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := invitations.Admit(ctx, used, "used@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	expired, invitation, err := invitations.Create(ctx, 0, "", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE invitations SET expiration = ? WHERE id = ?", time.Now().Add(-time.Minute), invitation.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Only the expired invitation goes, the used up one is kept until it expires
+	purged, err := janitor.PurgeInvitations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("purged %d invitations, want 1", purged)
+	}
+	listed, err := invitations.List(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Uses != 1 {
+		t.Fatalf("listed %+v, want only the used up invitation", listed)
+	}
+	// A failed registration can still give back its use
+	invitations.Release(ctx, used)
+	if err := invitations.Admit(ctx, used, "other@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, invitations.Admit(ctx, expired, "expired@example.com"), services.ErrInvalidInvitation)
+}
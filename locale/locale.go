@@ -0,0 +1,115 @@
+package locale
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/text/language"
+)
+
+// Locale used when nothing better matches, messages are written in it
+const Default = "en"
+
+// Supported locales, the first one is the fallback
+var Supported = []string{Default, "it", "de"}
+
+//go:embed catalogs/*.json
+var catalogFS embed.FS
+
+// Translations by locale keyed by their english message
+var catalogs = loadCatalogs()
+
+var matcher = language.NewMatcher(tags())
+
+type key int
+
+const localeKey key = 0
+
+func loadCatalogs() map[string]map[string]string {
+	catalogs := make(map[string]map[string]string)
+	entries, err := catalogFS.ReadDir("catalogs")
+	if err != nil {
+		log.Fatal("failed to read message catalogs", "err", err)
+	}
+	for _, entry := range entries {
+		content, err := catalogFS.ReadFile(path.Join("catalogs", entry.Name()))
+		if err != nil {
+			log.Fatal("failed to read message catalog", "catalog", entry.Name(), "err", err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(content, &catalog); err != nil {
+			log.Fatal("failed to unmarshal message catalog", "catalog", entry.Name(), "err", err)
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+	return catalogs
+}
+
+func tags() []language.Tag {
+	tags := make([]language.Tag, len(Supported))
+	for i, locale := range Supported {
+		tags[i] = language.MustParse(locale)
+	}
+	return tags
+}
+
+// Picks the best supported locale for an Accept-Language header
+func Negotiate(header string) string {
+	preferred, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(preferred) == 0 {
+		return Default
+	}
+	_, index, confidence := matcher.Match(preferred...)
+	if confidence == language.No {
+		return Default
+	}
+	return Supported[index]
+}
+
+// Reports whether a locale is supported
+func IsSupported(locale string) bool {
+	for _, supported := range Supported {
+		if supported == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a context carrying the locale
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey, locale)
+}
+
+// Returns a context carrying a preferred locale, unchanged if it's empty or unsupported
+func Prefer(ctx context.Context, locale string) context.Context {
+	if !IsSupported(locale) {
+		return ctx
+	}
+	return WithLocale(ctx, locale)
+}
+
+// Gets the locale carried by the context
+func FromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey).(string); ok {
+		return locale
+	}
+	return Default
+}
+
+// Translates an english message, untranslated ones are returned as they are
+func Translate(locale, message string) string {
+	if translated, ok := catalogs[locale][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// Translates an english message to the context's locale
+func T(ctx context.Context, message string) string {
+	return Translate(FromContext(ctx), message)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xalby/based/client"
+	"github.com/0xalby/based/services"
+	"github.com/0xalby/based/types"
+)
+
+func TestLockoutConcurrentLogins(t *testing.T) {
+	ctx := context.Background()
+	// Hashing slowly enough for the logins to overlap while passwords are compared
+	api := newTestAPI(t, map[string]string{
+		"LOGIN_FREE_ATTEMPTS":     "100",
+		"LOGIN_LOCKOUT_THRESHOLD": "5",
+		"RATE_LIMIT_LOGIN":        "off",
+		"PASSWORD_HASH_TIME":      "4",
+		"PASSWORD_HASH_MEMORY":    "8192",
+	})
+	register(t, api, "guessed@example.com", true)
+	// Guessing in parallel gets as many tries as guessing one at a time
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = map[string]int{}
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := api.Client().Login(ctx, types.PayloadLogin{Email: "guessed@example.com", Password: "wrong " + testPassword})
+			var problem *client.Error
+			if !errors.As(err, &problem) {
+				t.Errorf("got %v, want a problem", err)
+				return
+			}
+			mu.Lock()
+			results[problem.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if results[client.ErrInvalidCredentials.Code] != 4 || results[client.ErrAccountLocked.Code] != 16 {
+		t.Fatalf("got %v, want 4 wrong passwords and the rest locked", results)
+	}
+	if locks := len(api.Mail.To("guessed@example.com")) - 1; locks != 1 {
+		t.Fatalf("%d unlock emails sent, want 1", locks)
+	}
+}
+
+func TestLockoutReleasedAttempts(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, nil)
+	register(t, api, "released@example.com", false)
+	id := accountID(t, api, "released@example.com")
+	lockout := &services.LockoutService{DB: api.DB, Free: 1, Delay: time.Hour, MaxDelay: time.Hour, Threshold: 2, Duration: time.Hour, Window: time.Hour}
+	failures := func() int {
+		t.Helper()
+		var failures int
+		if err := api.DB.QueryRow("SELECT COALESCE(SUM(failures), 0) FROM attempts WHERE account = ?", id).Scan(&failures); err != nil {
+			t.Fatal(err)
+		}
+		return failures
+	}
+	// Errors while comparing leave no trace
+	attempt, err := lockout.Reserve(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockout.Release(ctx, attempt)
+	if failures() != 0 {
+		t.Fatal("a released first attempt was counted")
+	}
+	attempt, err = lockout.Reserve(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := lockout.Fail(attempt); token != "" {
+		t.Fatal("the first failure locked the account")
+	}
+	// Settled attempts stay as they are
+	lockout.Release(ctx, attempt)
+	if failures() != 1 {
+		t.Fatalf("%d failures, want 1", failures())
+	}
+	// Releasing an attempt which would have locked the account leaves it unlocked and delayed as before
+	attempt, err = lockout.Reserve(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockout.Reserve(ctx, id); !errors.Is(err, services.ErrAccountLocked) {
+		t.Fatalf("got %v while an attempt locking the account was in flight, want %v", err, services.ErrAccountLocked)
+	}
+	lockout.Release(ctx, attempt)
+	if failures() != 1 {
+		t.Fatalf("%d failures after releasing, want 1", failures())
+	}
+	if _, err := lockout.Reserve(ctx, id); err != nil {
+		t.Fatalf("got %v after releasing, want the account unlocked", err)
+	}
+}
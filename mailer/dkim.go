@@ -0,0 +1,217 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers signed when none are configured, missing ones are signed too so they can't be added later
+var DefaultSignedHeaders = []string{
+	"From", "To", "Subject", "Date", "Message-ID", "Mime-Version", "Content-Type",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// Signs messages with DomainKeys Identified Mail using relaxed canonicalization
+type DKIM struct {
+	Domain   string        // Signing domain, the public key is published at Selector._domainkey.Domain
+	Selector string        // Selector of the public key
+	Headers  []string      // Signed headers, DefaultSignedHeaders if empty
+	Key      crypto.Signer // Either an *rsa.PrivateKey or an ed25519.PrivateKey
+}
+
+// Loads a PEM encoded RSA or Ed25519 private key
+func LoadDKIMKey(path string) (crypto.Signer, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block found in dkim key")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported dkim key type %T", key)
+	}
+}
+
+// Gets the signing algorithm for the key
+func (d *DKIM) algorithm() (string, error) {
+	switch d.Key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	default:
+		return "", fmt.Errorf("unsupported dkim key type %T", d.Key)
+	}
+}
+
+// Returns the message with a DKIM-Signature header prepended, lines have to end with CRLF
+func (d *DKIM) Sign(message []byte) ([]byte, error) {
+	algorithm, err := d.algorithm()
+	if err != nil {
+		return nil, err
+	}
+	header, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return nil, errors.New("message without a header and body separator")
+	}
+	names := d.Headers
+	if len(names) == 0 {
+		names = DefaultSignedHeaders
+	}
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	signature := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d;\r\n\th=%s;\r\n\tbh=%s;\r\n\tb=",
+		algorithm, d.Domain, d.Selector, time.Now().Unix(),
+		strings.Join(names, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	// Hashing the signed headers followed by the signature itself without its value
+	hash := sha256.New()
+	fields := parseHeader(header)
+	for _, name := range names {
+		if field, ok := fields.pop(name); ok {
+			hash.Write([]byte(relaxedHeader(field) + "\r\n"))
+		}
+	}
+	hash.Write([]byte(relaxedHeader("DKIM-Signature: " + signature)))
+	digest := hash.Sum(nil)
+	var signed []byte
+	switch key := d.Key.(type) {
+	case *rsa.PrivateKey:
+		signed, err = key.Sign(rand.Reader, digest, crypto.SHA256)
+	case ed25519.PrivateKey:
+		// Ed25519 signs the hash itself as per RFC 8463
+		signed, err = key.Sign(nil, digest, crypto.Hash(0))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result bytes.Buffer
+	result.WriteString("DKIM-Signature: " + signature + fold(base64.StdEncoding.EncodeToString(signed)) + "\r\n")
+	result.Write(message)
+	return result.Bytes(), nil
+}
+
+// Header fields by lowercase name in the order they appear
+type header map[string][]string
+
+// Splits a header in its fields keeping folded lines together
+func parseHeader(raw []byte) header {
+	fields := make(header)
+	var current string
+	flush := func() {
+		if current == "" {
+			return
+		}
+		name, _, _ := strings.Cut(current, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		fields[name] = append(fields[name], current)
+	}
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			current += "\r\n" + line
+			continue
+		}
+		flush()
+		current = line
+	}
+	flush()
+	return fields
+}
+
+// Takes the last instance of a field not signed yet as signers and verifiers go bottom up
+func (h header) pop(name string) (string, bool) {
+	name = strings.ToLower(name)
+	instances := h[name]
+	if len(instances) == 0 {
+		return "", false
+	}
+	h[name] = instances[:len(instances)-1]
+	return instances[len(instances)-1], true
+}
+
+var whitespaces = regexp.MustCompile(`[ \t]+`)
+
+// Canonicalizes a header field, the name lowercase and the value unfolded with whitespaces collapsed
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(whitespaces.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// Canonicalizes a body collapsing whitespaces and dropping trailing ones and empty lines
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(whitespaces.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// Folds a long value so header lines stay short
+func fold(value string) string {
+	var folded strings.Builder
+	for len(value) > 72 {
+		folded.WriteString(value[:72] + "\r\n\t")
+		value = value[72:]
+	}
+	folded.WriteString(value)
+	return folded.String()
+}
+
+// Mailer signing every message before handing it to another one
+type Signed struct {
+	Mailer Mailer
+	DKIM   *DKIM
+}
+
+func (s *Signed) Send(ctx context.Context, message *Message) error {
+	// Signing at delivery so the signature covers exactly what the transport writes
+	signed := *message
+	signed.dkim = s.DKIM
+	return s.Mailer.Send(ctx, &signed)
+}
+
+func (s *Signed) Close() error {
+	return s.Mailer.Close()
+}
+
+// Generates a unique Message-ID for a domain
+func MessageID(domain string) string {
+	random := make([]byte, 12)
+	rand.Read(random)
+	return "<" + strconv.FormatInt(time.Now().UnixNano(), 36) + "." + base64.RawURLEncoding.EncodeToString(random) + "@" + domain + ">"
+}
@@ -0,0 +1,212 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Verifies the DKIM-Signature on top of a message following RFC 6376 on its own, only relaxed/relaxed
+func verify(message []byte, public crypto.PublicKey) error {
+	header, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return fmt.Errorf("no header and body separator")
+	}
+	// Splitting the header in fields, continuation lines start with whitespace
+	var fields []string
+	for _, line := range strings.Split(string(header), "\r\n") {
+		if len(fields) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	if !strings.HasPrefix(strings.ToLower(fields[0]), "dkim-signature:") {
+		return fmt.Errorf("the first field isn't a DKIM-Signature")
+	}
+	signature, fields := fields[0], fields[1:]
+	tags := map[string]string{}
+	_, value, _ := strings.Cut(signature, ":")
+	for _, tag := range strings.Split(value, ";") {
+		name, value, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, value)
+	}
+	if tags["v"] != "1" || tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("unexpected version %q or canonicalization %q", tags["v"], tags["c"])
+	}
+	bodyHash := sha256.Sum256(canonicalBody(body))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		return fmt.Errorf("body hash mismatch")
+	}
+	// Signed fields are taken bottom up, a name appearing more often in h than in the header signs nothing
+	hash := sha256.New()
+	used := map[int]bool{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			fieldName, _, _ := strings.Cut(fields[i], ":")
+			if !used[i] && strings.EqualFold(strings.TrimSpace(fieldName), name) {
+				used[i] = true
+				hash.Write([]byte(canonicalHeader(fields[i]) + "\r\n"))
+				break
+			}
+		}
+	}
+	// The signature itself is hashed with an empty b tag and without the trailing CRLF
+	empty := regexp.MustCompile(`([;:][ \t\r\n]*b=)[^;]*`).ReplaceAllString(signature, "$1")
+	hash.Write([]byte(canonicalHeader(empty)))
+	signed, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return fmt.Errorf("unexpected algorithm %q", tags["a"])
+		}
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, hash.Sum(nil), signed)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return fmt.Errorf("unexpected algorithm %q", tags["a"])
+		}
+		if !ed25519.Verify(public, hash.Sum(nil), signed) {
+			return fmt.Errorf("ed25519 verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key %T", public)
+}
+
+// Relaxed header canonicalization from RFC 6376 3.4.2
+func canonicalHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = regexp.MustCompile(`[ \t]+`).ReplaceAllString(value, " ")
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + strings.Trim(value, " ")
+}
+
+// Relaxed body canonicalization from RFC 6376 3.4.4
+func canonicalBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(regexp.MustCompile(`[ \t]+`).ReplaceAllString(lines[i], " "), " ")
+	}
+	canonical := strings.TrimRight(strings.Join(lines, "\r\n"), "\r\n")
+	if canonical == "" {
+		return nil
+	}
+	return []byte(canonical + "\r\n")
+}
+
+// Keys of both supported algorithms with their public halves
+func keys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"rsa": rsaKey, "ed25519": edKey}
+}
+
+func TestDKIMSignVerifies(t *testing.T) {
+	messages := map[string]string{
+		"plain":       "From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\n\r\nHello\r\n",
+		"folded":      "From: a@example.com\r\nTo: b@example.com\r\nSubject: a subject\r\n\tfolded  on\r\n more lines\r\n\r\nHello\r\n",
+		"trailing":    "From : a@example.com \t\r\nTo:b@example.com\r\nSubject:  spaced\t out  \r\n\r\nHello \t \r\n  world\t\r\n\r\n\r\n",
+		"empty body":  "From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\n\r\n",
+		"blank body":  "From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\n\r\n\r\n\r\n",
+		"no crlf":     "From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\n\r\nHello",
+		"duplicate":   "From: a@example.com\r\nTo: b@example.com\r\nSubject: first\r\nSubject: second\r\n\r\nHello\r\n",
+		"uppercase":   "FROM: a@example.com\r\nto: b@example.com\r\nSUBJECT: Hi\r\n\r\nHello\r\n",
+		"only header": "From: a@example.com\r\n\r\n",
+	}
+	for algorithm, key := range keys(t) {
+		d := &DKIM{Domain: "example.com", Selector: "based", Key: key}
+		for name, message := range messages {
+			signed, err := d.Sign([]byte(message))
+			if err != nil {
+				t.Fatalf("%s %s: %v", algorithm, name, err)
+			}
+			if !bytes.HasSuffix(signed, []byte(message)) {
+				t.Fatalf("%s %s: the message changed while signing", algorithm, name)
+			}
+			if err := verify(signed, key.Public()); err != nil {
+				t.Errorf("%s %s: %v", algorithm, name, err)
+			}
+			// Trailing empty lines are ignored by relaxed canonicalization, a changed header isn't
+			padded := append(bytes.Clone(signed), "\r\n\r\n"...)
+			if err := verify(padded, key.Public()); err != nil {
+				t.Errorf("%s %s: trailing empty lines broke the signature: %v", algorithm, name, err)
+			}
+			tampered := bytes.Replace(signed, []byte("a@example.com"), []byte("z@example.com"), 1)
+			if err := verify(tampered, key.Public()); err == nil {
+				t.Errorf("%s %s: a changed From still verifies", algorithm, name)
+			}
+		}
+	}
+}
+
+func TestDKIMRelaxedCanonicalization(t *testing.T) {
+	// The example from RFC 6376 3.4.6
+	fields := parseHeader([]byte("A: X\r\nB : Y\t\r\n\tZ  "))
+	a, _ := fields.pop("a")
+	b, _ := fields.pop("b")
+	if got := relaxedHeader(a) + "\r\n" + relaxedHeader(b) + "\r\n"; got != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("relaxed header %q, want %q", got, "a:X\r\nb:Y Z\r\n")
+	}
+	bodies := map[string]string{
+		" C \r\nD \t E\r\n\r\n\r\n":  " C\r\nD E\r\n",
+		"":                           "",
+		"\r\n":                       "",
+		"\r\n\r\n \t\r\n":            "",
+		"no ending":                  "no ending\r\n",
+		"trailing \t\r\n":            "trailing\r\n",
+		"\r\nleading empty line\r\n": "\r\nleading empty line\r\n",
+	}
+	for body, want := range bodies {
+		if got := string(relaxedBody([]byte(body))); got != want {
+			t.Errorf("relaxed body of %q is %q, want %q", body, got, want)
+		}
+	}
+	// The hash of an empty body is the hash of nothing
+	if hash := sha256.Sum256(relaxedBody(nil)); base64.StdEncoding.EncodeToString(hash[:]) != "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" {
+		t.Error("empty body hash differs from RFC 6376")
+	}
+}
+
+func TestDKIMSignsRenderedMessages(t *testing.T) {
+	for algorithm, key := range keys(t) {
+		message := &Message{
+			From:    "based@example.com",
+			To:      "someone@example.com",
+			Subject: strings.Repeat("A long subject getting folded by the encoder ", 4),
+			HTML:    "<p>Hello   there</p>\n\n",
+			Text:    "Hello   there \t\n\n",
+			Headers: map[string]string{"List-Unsubscribe": "<mailto:unsubscribe@example.com>"},
+			dkim:    &DKIM{Domain: "example.com", Selector: "based", Key: key},
+		}
+		var rendered bytes.Buffer
+		if _, err := message.WriteTo(&rendered); err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(rendered.Bytes(), key.Public()); err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+		}
+	}
+}
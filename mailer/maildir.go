@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Writes emails to a maildir readable by most mail clients
+type Maildir struct {
+	Path    string
+	counter atomic.Uint64
+}
+
+func (m *Maildir) Send(ctx context.Context, message *Message) error {
+	// Ensuring the maildir structure exists
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Path, dir), 0700); err != nil {
+			return err
+		}
+	}
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().Unix(), os.Getpid(), m.counter.Add(1), hostname)
+	// Writing to tmp and moving to new so readers never see partial messages
+	path := filepath.Join(m.Path, "tmp", name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := message.WriteTo(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return os.Rename(path, filepath.Join(m.Path, "new", name))
+}
+
+func (m *Maildir) Close() error {
+	return nil
+}
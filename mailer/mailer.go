@@ -0,0 +1,132 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/gomail.v2"
+)
+
+// An email ready to be sent
+type Message struct {
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	Subject string            `json:"subject"`
+	HTML    string            `json:"html"`
+	Text    string            `json:"text,omitempty"`    // Plain text alternative
+	Headers map[string]string `json:"headers,omitempty"` // Additional headers
+	dkim    *DKIM             // Signer set when delivering through Signed
+}
+
+// Renders the message in MIME format
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	message := gomail.NewMessage()
+	message.SetHeader("From", m.From)
+	message.SetHeader("To", m.To)
+	message.SetHeader("Subject", m.Subject)
+	for name, value := range m.Headers {
+		message.SetHeader(name, value)
+	}
+	// Clients pick the last alternative they can display
+	if m.Text != "" {
+		message.SetBody("text/plain", m.Text)
+		message.AddAlternative("text/html", m.HTML)
+	} else {
+		message.SetBody("text/html", m.HTML)
+	}
+	if m.dkim == nil {
+		return message.WriteTo(w)
+	}
+	// Signing needs the whole message first
+	var rendered bytes.Buffer
+	if _, err := message.WriteTo(&rendered); err != nil {
+		return 0, err
+	}
+	signed, err := m.dkim.Sign(rendered.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(signed)
+	return int64(n), err
+}
+
+// Delivers emails
+type Mailer interface {
+	Send(ctx context.Context, message *Message) error
+	Close() error
+}
+
+// Creates the mailer selected by MAIL_TRANSPORT signing messages if MAIL_DKIM_KEY is set, nil if sending emails is disabled
+func FromEnv() (Mailer, error) {
+	mailer, err := transportFromEnv()
+	if err != nil || mailer == nil || os.Getenv("MAIL_DKIM_KEY") == "" {
+		return mailer, err
+	}
+	key, err := LoadDKIMKey(os.Getenv("MAIL_DKIM_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAIL_DKIM_KEY %s", err)
+	}
+	dkim := &DKIM{
+		Domain:   os.Getenv("MAIL_DKIM_DOMAIN"),
+		Selector: os.Getenv("MAIL_DKIM_SELECTOR"),
+		Key:      key,
+	}
+	if dkim.Domain == "" || dkim.Selector == "" {
+		return nil, fmt.Errorf("MAIL_DKIM_DOMAIN and MAIL_DKIM_SELECTOR have to be set")
+	}
+	if headers := os.Getenv("MAIL_DKIM_HEADERS"); headers != "" {
+		dkim.Headers = strings.Split(headers, ":")
+	}
+	return &Signed{Mailer: mailer, DKIM: dkim}, nil
+}
+
+// Creates the transport selected by MAIL_TRANSPORT
+func transportFromEnv() (Mailer, error) {
+	transport := os.Getenv("MAIL_TRANSPORT")
+	// Keeping SMTP as the default when it's configured
+	if transport == "" && os.Getenv("SMTP_ADDRESS") != "" {
+		transport = "smtp"
+	}
+	switch transport {
+	case "":
+		return nil, nil
+	case "smtp":
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT %s", err)
+		}
+		mode := os.Getenv("SMTP_TLS")
+		if mode == "" {
+			mode = TLSStartTLS
+			if port == 465 {
+				mode = TLSImplicit
+			}
+		}
+		if mode != TLSImplicit && mode != TLSStartTLS && mode != TLSNone {
+			return nil, fmt.Errorf("unsupported SMTP_TLS %s", mode)
+		}
+		return &SMTP{
+			Host:     os.Getenv("SMTP_ADDRESS"),
+			Port:     port,
+			User:     os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			TLS:      mode,
+		}, nil
+	case "file":
+		if os.Getenv("MAIL_DIRECTORY") == "" {
+			return nil, fmt.Errorf("MAIL_DIRECTORY not set")
+		}
+		return &Maildir{Path: os.Getenv("MAIL_DIRECTORY")}, nil
+	case "stdout":
+		return &Stdout{Writer: os.Stdout}, nil
+	case "memory":
+		return &Memory{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported MAIL_TRANSPORT %s", transport)
+	}
+}
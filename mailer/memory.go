@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Keeps emails in memory so tests can inspect them
+type Memory struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func (m *Memory) Send(ctx context.Context, message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := *message
+	m.messages = append(m.messages, &sent)
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Gets every sent email in order
+func (m *Memory) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Message(nil), m.messages...)
+}
+
+// Gets the emails sent to a recipient in order
+func (m *Memory) To(recipient string) []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var messages []*Message
+	for _, message := range m.messages {
+		if message.To == recipient {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Gets the last email sent to a recipient, nil if there is none
+func (m *Memory) Last(recipient string) *Message {
+	messages := m.To(recipient)
+	if len(messages) == 0 {
+		return nil
+	}
+	return messages[len(messages)-1]
+}
+
+// Forgets every sent email
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
@@ -0,0 +1,165 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transport security modes
+const (
+	TLSImplicit = "tls"      // TLS from the start, usually on port 465
+	TLSStartTLS = "starttls" // Upgrading with STARTTLS, failing if the server doesn't support it
+	TLSNone     = "none"     // Plain text, only meant for local development servers
+)
+
+// Delivers emails through an SMTP server reusing the connection between messages
+type SMTP struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	TLS      string
+	Idle     time.Duration // How long an unused connection is kept open
+	mu       sync.Mutex
+	conn     net.Conn
+	client   *smtp.Client
+	idle     *time.Timer
+}
+
+func (s *SMTP) Send(ctx context.Context, message *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Reusing the connection if the server still answers
+	if s.client != nil {
+		s.setDeadline(ctx, s.conn)
+		if err := s.client.Noop(); err != nil {
+			s.closeLocked()
+		}
+	}
+	if s.client == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if err := s.send(ctx, message); err != nil {
+		// Dropping the connection since its state is unknown
+		s.closeLocked()
+		return err
+	}
+	// Closing the connection once unused for a while
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.idle = time.AfterFunc(s.idleTimeout(), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closeLocked()
+	})
+	return nil
+}
+
+func (s *SMTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+	return nil
+}
+
+// Dials, secures and authenticates a connection
+func (s *SMTP) connect(ctx context.Context) error {
+	address := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.TLS == TLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	s.setDeadline(ctx, conn)
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if s.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return errors.New("smtp server doesn't support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return err
+		}
+	}
+	if s.User != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.User, s.Password, s.Host)); err != nil {
+			client.Close()
+			return err
+		}
+	}
+	s.conn = conn
+	s.client = client
+	return nil
+}
+
+// Sends a message on the current connection
+func (s *SMTP) send(ctx context.Context, message *Message) error {
+	s.setDeadline(ctx, s.conn)
+	if err := s.client.Mail(message.From); err != nil {
+		return err
+	}
+	if err := s.client.Rcpt(message.To); err != nil {
+		return err
+	}
+	writer, err := s.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := message.WriteTo(writer); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// Bounds the next operations by the context deadline
+func (s *SMTP) setDeadline(ctx context.Context, conn net.Conn) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	conn.SetDeadline(deadline)
+}
+
+func (s *SMTP) closeLocked() {
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	if s.client != nil {
+		s.client.Quit()
+		s.client.Close()
+		s.client = nil
+		s.conn = nil
+	}
+}
+
+func (s *SMTP) idleTimeout() time.Duration {
+	if s.Idle <= 0 {
+		return 30 * time.Second
+	}
+	return s.Idle
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Prints emails, handy during development
+type Stdout struct {
+	Writer io.Writer
+	mu     sync.Mutex
+}
+
+func (s *Stdout) Send(ctx context.Context, message *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := message.WriteTo(s.Writer); err != nil {
+		return err
+	}
+	_, err := fmt.Fprint(s.Writer, "\r\n\r\n")
+	return err
+}
+
+func (s *Stdout) Close() error {
+	return nil
+}
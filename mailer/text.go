@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements starting on a new line
+var blocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "header": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// Elements whose content isn't meant to be read
+var hidden = map[string]bool{"head": true, "style": true, "script": true, "title": true}
+
+var (
+	spaces = regexp.MustCompile(`[ \t\r\n]+`)
+	lines  = regexp.MustCompile(`\n{3,}`)
+)
+
+// Generates a plain text alternative from an html email, links are kept next to their text
+func PlainText(document string) string {
+	var (
+		text   strings.Builder
+		skip   int
+		href   string
+		tokens = html.NewTokenizer(strings.NewReader(document))
+	)
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			// Trimming every line and collapsing blank ones
+			result := strings.Split(text.String(), "\n")
+			for i, line := range result {
+				result[i] = strings.TrimSpace(line)
+			}
+			return strings.TrimSpace(lines.ReplaceAllString(strings.Join(result, "\n"), "\n\n"))
+		case html.TextToken:
+			if skip == 0 {
+				text.WriteString(spaces.ReplaceAllString(string(tokens.Text()), " "))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokens.TagName()
+			tag := string(name)
+			if hidden[tag] {
+				skip++
+			}
+			if blocks[tag] {
+				text.WriteString("\n\n")
+			}
+			if tag == "a" {
+				href = attribute(tokens, "href")
+			}
+		case html.EndTagToken:
+			name, _ := tokens.TagName()
+			tag := string(name)
+			if hidden[tag] && skip > 0 {
+				skip--
+			}
+			if tag == "a" && href != "" {
+				text.WriteString(" (" + href + ")")
+				href = ""
+			}
+		}
+	}
+}
+
+// Gets an attribute of the current tag
+func attribute(tokens *html.Tokenizer, name string) string {
+	for {
+		key, value, more := tokens.TagAttr()
+		if string(key) == name {
+			return string(value)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
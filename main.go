@@ -1,35 +1,55 @@
 package main
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/hex"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/0xalby/based/cache"
+	"github.com/0xalby/based/challenge"
 	"github.com/0xalby/based/config"
 	"github.com/0xalby/based/database"
 	"github.com/0xalby/based/database/drivers"
+	"github.com/0xalby/based/database/redis"
 	"github.com/0xalby/based/handlers"
+	"github.com/0xalby/based/mailer"
 	"github.com/0xalby/based/middleware"
+	"github.com/0xalby/based/openapi"
+	"github.com/0xalby/based/ratelimit"
+	"github.com/0xalby/based/rpc"
 	"github.com/0xalby/based/services"
+	"github.com/0xalby/based/utils"
 	"github.com/charmbracelet/log"
 	"github.com/go-chi/chi/v5"
-	chiddlware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	"github.com/go-chi/httprate"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/joho/godotenv"
+	"golang.org/x/net/idna"
 )
 
 // An API instance
 type API struct {
-	addr string
-	db   *sql.DB
+	addr     string
+	db       *sql.DB
+	notifier database.Notifier
+	janitor  *services.JanitorService
+	mailer   mailer.Mailer // Delivers emails instead of the transport chosen in the enviroment if set
+	logs     io.Writer     // Receives the request logs instead of stdout and log/api.log if set
+	logger   *log.Logger
 }
 
 // Creates a new API instance
@@ -40,16 +60,12 @@ func NewAPI(addr string, db *sql.DB) *API {
 	}
 }
 
-// Init is ran before the entry point
-func init() {
+// Entry point
+func main() {
 	// Loading enviroment variables from .env
 	if err := godotenv.Load(); err != nil {
 		log.Fatal("failed to load .env(names in .env.example)")
 	}
-}
-
-// Entry point
-func main() {
 	// Initializing JWT
 	config.InitJWT(os.Getenv("API_JWT_SECRET"))
 	// Creating a database connection
@@ -83,21 +99,100 @@ func main() {
 	connection.SetMaxOpenConns(maxOpenConns)
 	connection.SetMaxIdleConns(maxIdleConns)
 	connection.SetConnMaxLifetime(time.Duration(maxConnsLifetimeMinutes) * time.Minute)
+	janitorService := &services.JanitorService{DB: connection}
+	// Running the one-shot purge command
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		purged, err := janitorService.PurgeAll(context.Background())
+		if err != nil {
+			log.Errorf("failed to purge expired rows %s", err)
+			return
+		}
+		log.Info("purged expired rows", "blacklist", purged.Blacklist, "codes", purged.Codes, "pending", purged.Pending, "outbox", purged.Outbox, "changes", purged.Changes, "ratelimits", purged.RateLimits, "invitations", purged.Invitations, "signins", purged.SignIns)
+		return
+	}
+	// Scheduling the janitor in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interval := minutesFromEnv("JANITOR_INTERVAL", time.Hour)
+	janitorService.Schedule(ctx, "blacklist", minutesFromEnv("JANITOR_BLACKLIST_INTERVAL", interval), janitorService.PurgeBlacklist)
+	janitorService.Schedule(ctx, "codes", minutesFromEnv("JANITOR_CODES_INTERVAL", interval), janitorService.PurgeCodes)
+	janitorService.Schedule(ctx, "pending", minutesFromEnv("JANITOR_PENDING_INTERVAL", interval), janitorService.PurgePending)
+	janitorService.Schedule(ctx, "outbox", minutesFromEnv("JANITOR_OUTBOX_INTERVAL", interval), janitorService.PurgeOutbox)
+	janitorService.Schedule(ctx, "changes", minutesFromEnv("JANITOR_CHANGES_INTERVAL", interval), janitorService.PurgeChanges)
+	janitorService.Schedule(ctx, "ratelimits", minutesFromEnv("JANITOR_RATELIMITS_INTERVAL", interval), janitorService.PurgeRateLimits)
+	janitorService.Schedule(ctx, "invitations", minutesFromEnv("JANITOR_INVITATIONS_INTERVAL", interval), janitorService.PurgeInvitations)
+	janitorService.Schedule(ctx, "signins", minutesFromEnv("JANITOR_SIGNINS_INTERVAL", interval), janitorService.PurgeSignIns)
 	// Creating an API instance
 	api := NewAPI(os.Getenv("API_ADDRESS"), connection)
+	api.janitor = janitorService
+	if notifier, ok := driver.(database.Notifier); ok {
+		api.notifier = notifier
+	}
 	// Running the new instance
-	api.Run()
+	api.Run(ctx)
 }
 
 //go:embed templates/*
 var templateFS embed.FS
 
 // Running
-func (server *API) Run() error {
+func (server *API) Run(ctx context.Context) error {
+	listener := &http.Server{Addr: server.addr, Handler: server.Handler(ctx)}
+	// gRPC clients speak http2 in clear text(h2c) unless behind a tls proxy
+	if os.Getenv("GRPC_ENABLED") == "true" && os.Getenv("GRPC_ADDRESS") == "" {
+		listener.Protocols = new(http.Protocols)
+		listener.Protocols.SetHTTP1(true)
+		listener.Protocols.SetUnencryptedHTTP2(true)
+	}
+	// Listening
+	server.logger.Printf("running on %s", server.addr)
+	return listener.ListenAndServe()
+}
+
+// Creates the services and registers the routes, what they hold is released once the context is done
+func (server *API) Handler(ctx context.Context) http.Handler {
+	// Connecting to redis once if the revocation cache or the rate limits use it
+	var redisClient *redis.Client
+	if os.Getenv("REVOCATION_CACHE") == "redis" || os.Getenv("RATE_LIMIT_STORE") == "redis" {
+		index, _ := strconv.Atoi(os.Getenv("REDIS_DATABASE"))
+		redisClient = &redis.Client{
+			Address:  os.Getenv("REDIS_ADDRESS"),
+			Password: os.Getenv("REDIS_PASSWORD"),
+			Database: index,
+		}
+		context.AfterFunc(ctx, func() { redisClient.Close() })
+	}
+	// Choosing where rate limit counters live
+	var store ratelimit.Store
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "memory":
+		store = ratelimit.Memory{}
+	case "sql":
+		store = &ratelimit.SQL{DB: server.db}
+	case "redis":
+		store = &ratelimit.Redis{Client: redisClient, Prefix: "based:"}
+	default:
+		log.Fatal("rate limit store unsupported")
+	}
+	// Rate limiting by the named policy in the enviroment falling back to a default one, the gRPC methods
+	// of the routes get the same limiters
+	limiters := map[string]*middleware.Limiter{}
+	limit := func(name, fallback string) func(http.Handler) http.Handler {
+		if limiters[name] == nil {
+			value := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name))
+			if value == "" {
+				value = fallback
+			}
+			policy, err := ratelimit.ParsePolicy(value)
+			if err != nil {
+				log.Fatal("bad rate limit policy", "name", name, "err", err)
+			}
+			limiters[name] = middleware.NewLimiter(name, policy, store)
+		}
+		return middleware.RateLimit(limiters[name], config.TokenAuth)
+	}
 	// Creating a router
 	router := chi.NewRouter()
-	// Rate limiting everything reasonably
-	router.Use(httprate.LimitByIP(50, time.Hour/2))
 	// Enabling CORS if the origins are set
 	if os.Getenv("CORS_ORIGINS") != "" {
 		origins := strings.Split(os.Getenv("CORS_ORIGINS"), " ")
@@ -110,24 +205,28 @@ func (server *API) Run() error {
 		router.Use(cors.Handler(cors.Options{
 			AllowedOrigins:   origins,
 			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Challenge"},
+			ExposedHeaders:   []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
 			AllowCredentials: true,
 			MaxAge:           300,
 		}))
 	}
-	// Ensuring the log directory exists
-	if err := os.MkdirAll("log", 0755); err != nil {
-		log.Fatal("failed to create log directory", "err", err)
-	}
-	// Opening a file for logging
-	file, err := os.OpenFile("log/api.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal("failed to open log file", "err", err)
+	if server.logs == nil {
+		// Ensuring the log directory exists
+		if err := os.MkdirAll("log", 0755); err != nil {
+			log.Fatal("failed to create log directory", "err", err)
+		}
+		// Opening a file for logging
+		file, err := os.OpenFile("log/api.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal("failed to open log file", "err", err)
+		}
+		context.AfterFunc(ctx, func() { file.Close() })
+		server.logs = io.MultiWriter(os.Stdout, file)
 	}
-	defer file.Close()
 	// Creating a logger
-	multiWriter := io.MultiWriter(os.Stdout, file)
-	logger := log.New(multiWriter)
+	logger := log.New(server.logs)
+	server.logger = logger
 	logger.SetReportTimestamp(false)
 	logger.SetReportCaller(false)
 	logger.SetLevel(log.InfoLevel)
@@ -137,64 +236,444 @@ func (server *API) Run() error {
 	router.Mount("/api/v"+os.Getenv("API_VERSION"), subrouter)
 	// Creating services
 	accountService := &services.AccountsService{DB: server.db}
-	emailService := &services.EmailService{FS: templateFS, DB: server.db}
-	totpService := &services.TotpService{DB: server.db}
+	mail := server.mailer
+	if mail == nil {
+		var err error
+		if mail, err = mailer.FromEnv(); err != nil {
+			log.Fatal("failed to create mailer", "err", err)
+		}
+	}
+	if mail != nil {
+		context.AfterFunc(ctx, func() { mail.Close() })
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_EMAIL")
+	}
+	// Queueing emails in the outbox unless they have to be sent right away
+	var outboxService *services.OutboxService
+	if workers := intFromEnv("MAIL_OUTBOX_WORKERS", 2); mail != nil && workers > 0 {
+		// Encrypting queued emails with their own key or one derived from the jwt secret
+		key, err := hex.DecodeString(os.Getenv("MAIL_OUTBOX_KEY"))
+		if err != nil || (len(key) != 0 && len(key) != 32) {
+			log.Fatal("the outbox key has to be 64 hexadecimal characters")
+		}
+		if len(key) == 0 {
+			mac := hmac.New(sha256.New, []byte(os.Getenv("API_JWT_SECRET")))
+			mac.Write([]byte("based outbox"))
+			key = mac.Sum(nil)
+		}
+		outboxService = &services.OutboxService{
+			DB:          server.db,
+			Mailer:      mail,
+			Key:         key,
+			MaxAttempts: intFromEnv("MAIL_OUTBOX_ATTEMPTS", 8),
+			Backoff:     durationFromEnv("MAIL_OUTBOX_BACKOFF", 30*time.Second),
+			Lease:       durationFromEnv("MAIL_OUTBOX_LEASE", 5*time.Minute),
+		}
+		if err := outboxService.Run(ctx, workers, 5*time.Second); err != nil {
+			log.Fatal("failed to run the outbox", "err", err)
+		}
+		mail = outboxService
+	}
+	// Templates on disk override the embedded ones file by file
+	embedded, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		log.Fatal("failed to open embedded templates", "err", err)
+	}
+	name := os.Getenv("MAIL_PRODUCT_NAME")
+	if name == "" {
+		name = "Based"
+	}
+	emailService := &services.EmailService{
+		FS:     utils.OverlayFS{Dir: os.Getenv("MAIL_TEMPLATES_DIR"), Fallback: embedded},
+		DB:     server.db,
+		Mailer: mail,
+		From:   from,
+		Brand: services.Brand{
+			Name:    name,
+			Logo:    os.Getenv("MAIL_LOGO_URL"),
+			Website: os.Getenv("MAIL_WEBSITE_URL"),
+			Support: os.Getenv("MAIL_SUPPORT_EMAIL"),
+			Help:    os.Getenv("MAIL_HELP_URL"),
+		},
+	}
+	if unsubscribe := os.Getenv("MAIL_UNSUBSCRIBE"); unsubscribe != "" {
+		emailService.Unsubscribe = strings.Split(unsubscribe, ",")
+	}
+	emailService.CancelURL = os.Getenv("MAIL_CANCEL_URL")
+	changesService := &services.ChangesService{
+		DB:       server.db,
+		Validity: time.Duration(intFromEnv("API_EMAIL_CHANGE_DAYS", 7)) * 24 * time.Hour,
+	}
+	emailService.UnlockURL = os.Getenv("MAIL_UNLOCK_URL")
+	emailService.InvitationURL = os.Getenv("MAIL_INVITATION_URL")
+	emailService.SecureURL = os.Getenv("MAIL_SECURE_URL")
+	// Noticing sign-ins from new devices or locations
+	signInsService := &services.SignInsService{
+		DB:       server.db,
+		Size:     intFromEnv("SIGNIN_HISTORY", 20),
+		Verify:   os.Getenv("SIGNIN_VERIFY") == "true",
+		Validity: time.Duration(intFromEnv("SIGNIN_SECURE_DAYS", 7)) * 24 * time.Hour,
+	}
+	// Deciding who can register
+	invitationsService := &services.InvitationsService{
+		DB:         server.db,
+		Mode:       services.RegistrationOpen,
+		Validity:   time.Duration(intFromEnv("INVITATION_DAYS", 7)) * 24 * time.Hour,
+		PerAccount: intFromEnv("INVITATIONS_PER_ACCOUNT", 5),
+	}
+	if mode := os.Getenv("REGISTRATION_MODE"); mode != "" {
+		switch mode {
+		case services.RegistrationOpen, services.RegistrationInvite, services.RegistrationClosed:
+			invitationsService.Mode = mode
+		default:
+			log.Fatal("unknown registration mode", "mode", mode)
+		}
+	}
+	totpService := &services.TotpService{DB: server.db, Pepper: []byte(os.Getenv("PASSWORD_PEPPER"))}
+	// Checking new passwords against one policy, optionally refusing the ones known from breaches
+	passwordService := &services.PasswordService{
+		MinLength: intFromEnv("PASSWORD_MIN_LENGTH", 12),
+		MaxLength: intFromEnv("PASSWORD_MAX_LENGTH", 128),
+		MinScore:  intFromEnv("PASSWORD_MIN_SCORE", 3),
+	}
+	if dir := os.Getenv("PASSWORD_BREACHED_DIR"); dir != "" {
+		passwordService.Breached = &services.BreachedRanges{FS: os.DirFS(dir), MinCount: intFromEnv("PASSWORD_BREACHED_MIN_COUNT", 1)}
+	}
+	// Restricting the email domains accounts can use
+	domainService := &services.DomainService{
+		Allow:      domainsFromEnv("EMAIL_DOMAINS_ALLOW"),
+		Deny:       domainsFromEnv("EMAIL_DOMAINS_DENY"),
+		Disposable: os.Getenv("EMAIL_BLOCK_DISPOSABLE") == "true",
+		File:       os.Getenv("EMAIL_DISPOSABLE_FILE"),
+	}
+	if domainService.Disposable {
+		if err := domainService.LoadDisposable(); err != nil {
+			log.Fatal("failed to load disposable domains", "err", err)
+		}
+		domainService.WatchDisposable(ctx, minutesFromEnv("EMAIL_DISPOSABLE_REFRESH", 5*time.Minute))
+	}
+	if os.Getenv("EMAIL_CHECK_MX") == "true" {
+		domainService.Resolver = net.DefaultResolver
+	}
+	// Refusing passwords accounts had recently
+	historyService := &services.HistoryService{DB: server.db, Size: intFromEnv("PASSWORD_HISTORY", 5)}
+	// Hashing passwords with argon2id, hashes made with other parameters are upgraded on login
+	utils.Hasher.Time = uint32(intFromEnv("PASSWORD_HASH_TIME", int(utils.Hasher.Time)))
+	utils.Hasher.Memory = uint32(intFromEnv("PASSWORD_HASH_MEMORY", int(utils.Hasher.Memory)))
+	utils.Hasher.Threads = uint8(intFromEnv("PASSWORD_HASH_THREADS", int(utils.Hasher.Threads)))
+	if utils.Hasher.Time < 1 || utils.Hasher.Memory < 8*uint32(utils.Hasher.Threads) || utils.Hasher.Threads < 1 {
+		log.Fatal("invalid argon2id parameters")
+	}
+	utils.Hasher.Pepper = []byte(os.Getenv("PASSWORD_PEPPER"))
+	// Slowing down and locking accounts failing to log in whatever ip the attempts come from
+	lockoutService := &services.LockoutService{
+		DB:        server.db,
+		Free:      intFromEnv("LOGIN_FREE_ATTEMPTS", 3),
+		Delay:     durationFromEnv("LOGIN_DELAY", time.Second),
+		MaxDelay:  durationFromEnv("LOGIN_MAX_DELAY", 15*time.Minute),
+		Threshold: intFromEnv("LOGIN_LOCKOUT_THRESHOLD", 10),
+		Duration:  minutesFromEnv("LOGIN_LOCKOUT_DURATION", time.Hour),
+		Window:    minutesFromEnv("LOGIN_ATTEMPTS_WINDOW", 24*time.Hour),
+	}
+	// Making clients solve a challenge before the chosen routes, either a proof of work or a CAPTCHA
+	var (
+		verifier challenge.Verifier
+		pow      *challenge.ProofOfWork
+	)
+	switch os.Getenv("CHALLENGE") {
+	case "":
+	case "pow":
+		if len(os.Getenv("CHALLENGE_SECRET")) < 32 {
+			log.Fatal("challenge secret has to be at least 32 characters")
+		}
+		pow = &challenge.ProofOfWork{
+			Secret:     []byte(os.Getenv("CHALLENGE_SECRET")),
+			Difficulty: intFromEnv("CHALLENGE_DIFFICULTY", 20),
+			Validity:   durationFromEnv("CHALLENGE_VALIDITY", 5*time.Minute),
+		}
+		verifier = pow
+	case "hcaptcha":
+		verifier = challenge.HCaptcha(os.Getenv("CHALLENGE_SECRET"))
+	case "turnstile":
+		verifier = challenge.Turnstile(os.Getenv("CHALLENGE_SECRET"))
+	case "siteverify":
+		verifier = &challenge.Remote{URL: os.Getenv("CHALLENGE_URL"), Secret: os.Getenv("CHALLENGE_SECRET")}
+	default:
+		log.Fatal("challenge unsupported")
+	}
+	challengeRoutes := strings.Fields(os.Getenv("CHALLENGE_ROUTES"))
+	if os.Getenv("CHALLENGE_ROUTES") == "" {
+		challengeRoutes = []string{"register", "recovery"}
+	}
+	for _, route := range challengeRoutes {
+		if route != "register" && route != "recovery" {
+			log.Fatal("challenge route unsupported", "route", route)
+		}
+	}
+	// Enforcing the challenge on a route if it's one of the chosen ones
+	challenged := func(name string) func(http.Handler) http.Handler {
+		if !slices.Contains(challengeRoutes, name) {
+			return middleware.Challenge(nil)
+		}
+		return middleware.Challenge(verifier)
+	}
 	blacklistService := &services.BlacklistService{DB: server.db}
+	// Optionally caching revocations in front of the blacklist table
+	switch os.Getenv("REVOCATION_CACHE") {
+	case "":
+	case "memory":
+		size, err := strconv.Atoi(os.Getenv("REVOCATION_CACHE_SIZE"))
+		if err != nil || size <= 0 {
+			size = 100000
+		}
+		blacklistService.Cache = cache.NewLocal(size)
+	case "redis":
+		blacklistService.Cache = &cache.Redis{Client: redisClient, Prefix: "based:"}
+	default:
+		log.Fatal("revocation cache unsupported")
+	}
+	blacklistService.WatchCache(ctx, minutesFromEnv("REVOCATION_CACHE_REFRESH", 5*time.Minute))
+	// Broadcasting revocations to other instances if the database supports it
+	if server.notifier != nil {
+		blacklistService.Notifier = server.notifier
+		if err := blacklistService.ListenRevocations(ctx); err != nil {
+			log.Fatal("failed to listen for revocations", "err", err)
+		}
+	}
 	// Creating handlers
-	accountHandler := &handlers.AccountsHandler{AS: accountService, ES: emailService, TS: totpService}
-	authHandler := &handlers.AuthHandler{AS: accountService, ES: emailService, TS: totpService, BS: blacklistService}
-	// Using the real ip middleware
-	subrouter.Use(chiddlware.RealIP)
+	accountHandler := &handlers.AccountsHandler{AS: accountService, ES: emailService, TS: totpService, BS: blacklistService, CS: changesService, PS: passwordService, HS: historyService, DS: domainService}
+	authHandler := &handlers.AuthHandler{AS: accountService, ES: emailService, TS: totpService, BS: blacklistService, LS: lockoutService, PS: passwordService, DS: domainService, IS: invitationsService, SS: signInsService}
+	adminHandler := &handlers.AdminHandler{OS: outboxService, LS: lockoutService, JS: server.janitor}
+	invitationsHandler := &handlers.InvitationsHandler{IS: invitationsService, ES: emailService, AS: accountService}
+	openapiHandler := &handlers.OpenAPIHandler{}
+	challengeHandler := &handlers.ChallengeHandler{PoW: pow}
+	// Only trusting the forwarded client address from the proxies in front of the API
+	proxies := prefixesFromEnv("TRUSTED_PROXIES")
+	subrouter.Use(middleware.RealIP(proxies))
 	// Using the logger middleware
 	subrouter.Use(middleware.Logger(*logger))
+	subrouter.Use(middleware.Locale)
+	// Restricting the networks requests are accepted from, everywhere and by route group
+	filters := map[string]middleware.IPFilter{}
+	for _, group := range []string{"", "auth", "account", "admin"} {
+		suffix := ""
+		if group != "" {
+			suffix = "_" + strings.ToUpper(group)
+		}
+		filters[group] = middleware.IPFilter{Allow: prefixesFromEnv("IP_ALLOW" + suffix), Deny: prefixesFromEnv("IP_DENY" + suffix)}
+	}
+	subrouter.Use(middleware.FilterIPs(filters[""]))
+	// Rate limiting everything reasonably, by account when logged in so clients behind a nat don't share a limit
+	subrouter.Use(limit("global", "50/30m account"))
+	// Bounding requests duration, routes sending emails get their own deadline
+	timeout := middleware.Timeout(durationFromEnv("API_TIMEOUT", 10*time.Second))
+	emailTimeout := middleware.Timeout(durationFromEnv("API_EMAIL_TIMEOUT", 30*time.Second))
 	// Registering the routes
 	subrouter.Route("/auth", func(r chi.Router) {
-		r.With(httprate.LimitByIP(20, time.Hour)).
+		r.Use(middleware.FilterIPs(filters["auth"]))
+		r.With(limit("register", "20/1h ip")).
+			With(challenged("register")).
+			With(emailTimeout).
 			Post("/register", authHandler.Register)
-		r.Post("/login", authHandler.Login)
-		r.With(jwtauth.Verifier(config.TokenAuth)).
-			With(jwtauth.Authenticator(config.TokenAuth)).
+		r.With(limit("login", "20/1h email")).
+			With(emailTimeout).
+			Post("/login", authHandler.Login)
+		r.With(timeout).
+			With(jwtauth.Verifier(config.TokenAuth)).
+			With(middleware.Authenticator(config.TokenAuth)).
 			With(middleware.Revocation(authHandler)).
 			Post("/logout", authHandler.Logout)
-		if os.Getenv("SMTP_ADDRESS") != "" {
-			r.With(httprate.LimitByIP(5, time.Hour*24)).
+		if emailService.Enabled() {
+			r.With(limit("verification", "5/24h account")).
+				With(timeout).
 				With(jwtauth.Verifier(config.TokenAuth)).
-				With(jwtauth.Authenticator(config.TokenAuth)).
+				With(middleware.Authenticator(config.TokenAuth)).
 				With(middleware.Revocation(authHandler)).
 				Post("/verification", authHandler.Verification)
-			r.With(jwtauth.Verifier(config.TokenAuth)).
-				With(jwtauth.Authenticator(config.TokenAuth)).
+			r.With(emailTimeout).
+				With(jwtauth.Verifier(config.TokenAuth)).
+				With(middleware.Authenticator(config.TokenAuth)).
 				With(middleware.Revocation(authHandler)).
-				With(httprate.LimitByIP(5, time.Hour*24)).
+				With(limit("resend", "5/24h account")).
 				Get("/resend", authHandler.ResendVerification)
 		}
-		r.With(httprate.LimitByIP(5, time.Hour*24)).
+		r.With(limit("backup", "5/24h ip")).
+			With(emailTimeout).
 			Post("/backup", authHandler.LoginWithBackupCode)
+		if emailService.Enabled() {
+			r.With(limit("unlock", "10/1h ip")).
+				With(timeout).
+				Post("/unlock", authHandler.Unlock)
+			r.With(limit("secure", "10/1h ip")).
+				With(timeout).
+				Post("/secure", authHandler.Secure)
+		}
+		if pow != nil {
+			r.With(limit("challenge", "60/1h ip")).
+				With(timeout).
+				Get("/challenge", challengeHandler.Issue)
+		}
 	})
 	subrouter.Route("/account", func(r chi.Router) {
+		r.Use(middleware.FilterIPs(filters["account"]))
 		r.Group(func(r chi.Router) {
 			r.Use(jwtauth.Verifier(config.TokenAuth))
-			r.Use(jwtauth.Authenticator(config.TokenAuth))
-			r.Use(middleware.Revocation(authHandler))
-			r.Use(middleware.Verified(authHandler))
-			if os.Getenv("SMTP_ADDRESS") != "" {
-				r.With(httprate.LimitByIP(5, 24*time.Hour)).
-					Get("/confirmation", accountHandler.SendConfirmationEmail)
-			}
-			r.Put("/update/email", accountHandler.UpdateEmail)
-			r.Put("/update/password", accountHandler.UpdatePassword)
-			r.Put("/totp/enable", accountHandler.AccountEnableTOTP)
-			r.Put("/totp/disable", accountHandler.AccountDisableTOTP)
-			r.With(httprate.LimitByIP(5, 24*time.Hour)).
-				Delete("/delete", accountHandler.DeleteAccount)
+			r.Use(middleware.Authenticator(config.TokenAuth))
+			r.Group(func(r chi.Router) {
+				r.Use(timeout)
+				r.Use(middleware.Revocation(authHandler))
+				r.Use(middleware.Verified(authHandler))
+				r.Put("/totp/enable", accountHandler.AccountEnableTOTP)
+				r.Put("/totp/disable", accountHandler.AccountDisableTOTP)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(emailTimeout)
+				r.Use(middleware.Revocation(authHandler))
+				r.Use(middleware.Verified(authHandler))
+				if emailService.Enabled() {
+					r.With(limit("confirmation", "5/24h account")).
+						Get("/confirmation", accountHandler.SendConfirmationEmail)
+				}
+				r.Put("/update/email", accountHandler.UpdateEmail)
+				r.Put("/update/password", accountHandler.UpdatePassword)
+				r.Put("/update/locale", accountHandler.UpdateLocale)
+				r.With(limit("delete", "5/24h account")).
+					Delete("/delete", accountHandler.DeleteAccount)
+				if invitationsService.Mode == services.RegistrationInvite {
+					r.With(limit("invitations", "20/24h account")).
+						Post("/invitations", invitationsHandler.Create)
+					r.Get("/invitations", invitationsHandler.List)
+					r.Delete("/invitations/{id}", invitationsHandler.Revoke)
+				}
+			})
 		})
-		if os.Getenv("SMTP_ADDRESS") != "" {
-			r.With(httprate.LimitByIP(5, 24*time.Hour)).
+		if emailService.Enabled() {
+			r.With(limit("recovery", "5/24h ip")).
+				With(challenged("recovery")).
+				With(emailTimeout).
 				Get("/recovery", accountHandler.Recovery)
-			r.Post("/reset", accountHandler.Reset)
+			r.With(timeout).
+				Post("/reset", accountHandler.Reset)
+			r.With(limit("cancel", "10/1h ip")).
+				With(timeout).
+				Post("/email/cancel", accountHandler.CancelEmailChange)
 		}
 	})
-	// Listening
-	logger.Printf("running on %s", server.addr)
-	return http.ListenAndServe(server.addr, router)
+	// Registering admin routes if an admin token is set
+	if os.Getenv("API_ADMIN_TOKEN") != "" {
+		subrouter.Route("/admin", func(r chi.Router) {
+			r.Use(middleware.FilterIPs(filters["admin"]))
+			r.Use(timeout)
+			r.Use(middleware.Admin(os.Getenv("API_ADMIN_TOKEN")))
+			if outboxService != nil {
+				r.Get("/outbox", adminHandler.ListOutbox)
+				r.Post("/outbox/{id}/retry", adminHandler.RetryOutbox)
+			}
+			r.Post("/accounts/{id}/unlock", adminHandler.UnlockAccount)
+			r.Get("/janitor", adminHandler.JanitorStats)
+			if invitationsService.Mode == services.RegistrationInvite {
+				r.With(emailTimeout).
+					Post("/invitations", invitationsHandler.AdminCreate)
+				r.Get("/invitations", invitationsHandler.AdminList)
+				r.Delete("/invitations/{id}", invitationsHandler.AdminRevoke)
+			}
+		})
+	}
+	// Serving the openapi document of the registered routes, refusing to start if a route isn't documented
+	subrouter.Get("/openapi.json", openapiHandler.Document)
+	openapiHandler.Spec, err = openapi.New(os.Getenv("API_VERSION")).Sync(subrouter)
+	if err != nil {
+		log.Fatal("the openapi document is out of sync with the router", "err", err)
+	}
+	// Optionally serving the gRPC API, on its own port if set or next to the REST one otherwise
+	var handler http.Handler = router
+	if os.Getenv("GRPC_ENABLED") == "true" {
+		grpcServer := rpc.NewServer(
+			&rpc.AuthServer{AS: accountService, ES: emailService, TS: totpService, BS: blacklistService, LS: lockoutService, PS: passwordService, DS: domainService, IS: invitationsService, SS: signInsService, PoW: pow},
+			&rpc.AccountServer{AS: accountService, ES: emailService, TS: totpService, BS: blacklistService, CS: changesService, PS: passwordService, HS: historyService, DS: domainService, IS: invitationsService},
+			logger,
+			durationFromEnv("API_EMAIL_TIMEOUT", 30*time.Second),
+			verifier,
+			challengeRoutes,
+			proxies,
+			filters,
+			limiters,
+		)
+		context.AfterFunc(ctx, grpcServer.Stop)
+		if address := os.Getenv("GRPC_ADDRESS"); address != "" {
+			grpcListener, err := net.Listen("tcp", address)
+			if err != nil {
+				log.Fatal("failed to listen for grpc", "err", err)
+			}
+			go func() {
+				if err := grpcServer.Serve(grpcListener); err != nil {
+					log.Error("grpc server stopped", "err", err)
+				}
+			}()
+			logger.Printf("grpc running on %s", address)
+		} else {
+			handler = rpc.Multiplex(grpcServer, router)
+			logger.Printf("grpc running on %s", server.addr)
+		}
+	}
+	return handler
+}
+
+// Reads a duration in seconds from the enviroment falling back to a default
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || seconds <= 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Reads a duration in minutes from the enviroment falling back to a default, zero is kept to allow disabling
+func minutesFromEnv(name string, fallback time.Duration) time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// Reads an integer from the enviroment falling back to a default, zero is kept to allow disabling
+func intFromEnv(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Reads a space separated list of email domains from the enviroment in the form they are compared in
+func domainsFromEnv(name string) []string {
+	var domains []string
+	for _, domain := range strings.Fields(os.Getenv(name)) {
+		ascii, err := idna.Lookup.ToASCII(strings.TrimPrefix(domain, "@"))
+		if err != nil {
+			log.Fatal("bad email domain", "name", name, "domain", domain)
+		}
+		domains = append(domains, strings.ToLower(ascii))
+	}
+	return domains
+}
+
+// Parses a space separated list of networks in CIDR notation or single addresses
+func prefixesFromEnv(name string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, value := range strings.Fields(os.Getenv(name)) {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				log.Fatal("bad network", "name", name, "network", value)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
 }
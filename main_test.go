@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/0xalby/based/client"
+	"github.com/0xalby/based/config"
+	"github.com/0xalby/based/mailer"
+	"github.com/0xalby/based/services"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed database/migrations/*.sql
+var migrations embed.FS
+
+const (
+	testPassword   = "correct horse battery staple 42!"
+	testAdminToken = "ce8b9e0b3f0a4c0b9c1b1d6e3f0d9a7a"
+)
+
+// An API running on a fresh SQLite database keeping emails in memory
+type testAPI struct {
+	URL     string
+	DB      *sql.DB
+	Mail    *mailer.Memory // Nil if MAIL_TRANSPORT was given
+	Handler http.Handler
+}
+
+// Starts an API configured by the enviroment variables on top of the defaults tests run with,
+// emails are kept in memory unless MAIL_TRANSPORT is given
+func newTestAPI(t *testing.T, env map[string]string) *testAPI {
+	t.Helper()
+	defaults := map[string]string{
+		"API_VERSION":           "1",
+		"API_JWT_SECRET":        "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+		"API_ADMIN_TOKEN":       testAdminToken,
+		"MAIL_FROM":             "based@example.com",
+		"MAIL_OUTBOX_WORKERS":   "0",
+		"RATE_LIMIT_GLOBAL":     "off",
+		"PASSWORD_HASH_TIME":    "1",
+		"PASSWORD_HASH_MEMORY":  "64",
+		"PASSWORD_HASH_THREADS": "1",
+	}
+	for name, value := range env {
+		defaults[name] = value
+	}
+	for name, value := range defaults {
+		t.Setenv(name, value)
+	}
+	config.InitJWT(defaults["API_JWT_SECRET"])
+	db := migrate(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	api := NewAPI("", db)
+	api.janitor = &services.JanitorService{DB: db}
+	mail := &mailer.Memory{}
+	if _, ok := env["MAIL_TRANSPORT"]; !ok {
+		api.mailer = mail
+	} else {
+		mail = nil
+	}
+	api.logs = io.Discard
+	handler := api.Handler(ctx)
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+	return &testAPI{URL: server.URL + "/api/v1", DB: db, Mail: mail, Handler: handler}
+}
+
+// Creates a client of the API
+func (api *testAPI) Client() *client.Client {
+	c := client.New(api.URL)
+	c.AdminToken = testAdminToken
+	return c
+}
+
+var (
+	codePattern  = regexp.MustCompile(`<p>([A-Z0-9]{6})</p>`)
+	tokenPattern = regexp.MustCompile(`<p>([0-9a-f]{64})</p>`)
+)
+
+// Gets the code or token in the last email with the subject sent to a recipient
+func (api *testAPI) Emailed(t *testing.T, recipient, subject string, pattern *regexp.Regexp) string {
+	t.Helper()
+	messages := api.Mail.To(recipient)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Subject != subject {
+			continue
+		}
+		match := pattern.FindStringSubmatch(messages[i].HTML)
+		if match == nil {
+			t.Fatalf("no code or token in the %q email to %s", subject, recipient)
+		}
+		return match[1]
+	}
+	t.Fatalf("no %q email sent to %s", subject, recipient)
+	return ""
+}
+
+// Creates a SQLite database with every migration applied
+func migrate(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "based.db")+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	files, err := fs.Glob(migrations, "database/migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		content, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		up, _, _ := strings.Cut(string(content), "-- +goose Down")
+		if _, err := db.Exec(up); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+	return db
+}
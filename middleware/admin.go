@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/0xalby/based/utils"
+)
+
+// Problem responded with to requests without the admin token
+var ErrInvalidAdminToken = utils.NewProblem(http.StatusUnauthorized, "invalid_admin_token", "invalid admin token")
+
+// Middleware restricting access to requests bearing the admin token
+func Admin(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Comparing in constant time to avoid leaking the token
+			bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				utils.WriteProblem(w, r, ErrInvalidAdminToken)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
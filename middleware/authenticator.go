@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/0xalby/based/handlers"
+	"github.com/go-chi/jwtauth/v5"
+)
+
+// Middleware denying requests without a token verified by jwtauth.Verifier responding with problems
+func Authenticator(ja *jwtauth.JWTAuth) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, _, err := jwtauth.FromContext(r.Context())
+			if err != nil && errors.Is(jwtauth.ErrorReason(err), jwtauth.ErrExpired) {
+				handlers.Fail(w, r, handlers.ErrTokenExpired)
+				return
+			}
+			if err != nil || token == nil {
+				handlers.Fail(w, r, handlers.ErrInvalidToken)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
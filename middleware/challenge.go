@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/0xalby/based/challenge"
+	"github.com/0xalby/based/handlers"
+	"github.com/0xalby/based/utils"
+)
+
+// Problems responded with to requests without a valid challenge response
+var (
+	ErrChallengeRequired = utils.NewProblem(http.StatusForbidden, "challenge_required", "a solved challenge is required")
+	ErrChallengeFailed   = utils.NewProblem(http.StatusForbidden, "challenge_failed", "invalid or expired challenge response")
+)
+
+// Middleware letting through requests whose X-Challenge header passes the verifier, a nil verifier lets everything through
+func Challenge(verifier challenge.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if verifier == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := verifier.Verify(r.Context(), r.Header.Get("X-Challenge"), utils.ClientIP(r))
+			switch {
+			case errors.Is(err, challenge.ErrMissing):
+				utils.WriteProblem(w, r, ErrChallengeRequired)
+			case errors.Is(err, challenge.ErrFailed):
+				utils.WriteProblem(w, r, ErrChallengeFailed)
+			case err != nil:
+				handlers.Fail(w, r, err)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/0xalby/based/locale"
+)
+
+// Middleware resolving the request's locale from the Accept-Language header, accounts' preferences override it later on
+func Locale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := locale.WithLocale(r.Context(), locale.Negotiate(r.Header.Get("Accept-Language")))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/0xalby/based/utils"
+)
+
+// Problem responded with to requests from addresses the allow and deny lists refuse
+var ErrAddressNotAllowed = utils.NewProblem(http.StatusForbidden, "address_not_allowed", "requests from this address aren't allowed")
+
+// Networks requests are accepted from, denied networks win over allowed ones and an empty allow list allows
+// every network which isn't denied
+type IPFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// Reports if the filter has any list set
+func (filter IPFilter) Enabled() bool {
+	return len(filter.Allow) > 0 || len(filter.Deny) > 0
+}
+
+// Reports if an address passes the lists, addresses which can't be parsed only pass a filter without lists
+func (filter IPFilter) Allows(ip string) bool {
+	if !filter.Enabled() {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	if contains(filter.Deny, addr) {
+		return false
+	}
+	return len(filter.Allow) == 0 || contains(filter.Allow, addr)
+}
+
+// Middleware refusing requests from addresses the filter doesn't allow, a filter without lists lets everything through
+func FilterIPs(filter IPFilter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !filter.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !filter.Allows(utils.ClientIP(r)) {
+				utils.WriteProblem(w, r, ErrAddressNotAllowed)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware replacing the request address with the client one told by X-Forwarded-For or X-Real-IP, only
+// if the request comes from a trusted proxy so clients can't spoof their address past rate limits and filters
+func RealIP(proxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(proxies) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := ForwardedIP(utils.ClientIP(r), r.Header.Values("X-Forwarded-For"), r.Header.Get("X-Real-IP"), proxies); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Finds the client address a trusted proxy forwarded, empty if the peer isn't a trusted proxy or forwarded
+// nothing usable. The X-Forwarded-For chain is walked from the nearest hop skipping trusted proxies since
+// anything past the first untrusted hop could be made up by the client
+func ForwardedIP(peer string, forwardedFor []string, realIP string, proxies []netip.Prefix) string {
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !contains(proxies, addr) {
+		return ""
+	}
+	var hops []string
+	for _, value := range forwardedFor {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		client = hop.Unmap().String()
+		if !contains(proxies, hop) {
+			return client
+		}
+	}
+	if client != "" {
+		return client
+	}
+	if hop, err := netip.ParseAddr(strings.TrimSpace(realIP)); err == nil {
+		return hop.Unmap().String()
+	}
+	return ""
+}
+
+// Reports if an address belongs to one of the networks
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xalby/based/handlers"
+	"github.com/0xalby/based/ratelimit"
+	"github.com/0xalby/based/utils"
+	"github.com/go-chi/jwtauth/v5"
+)
+
+// Problem responded with to requests over a rate limit
+var ErrRateLimited = utils.NewProblem(http.StatusTooManyRequests, "rate_limited", "too many requests, try again later")
+
+// Returned by Limiter.Allow over the limit, wraps ErrRateLimited
+type LimitedError struct {
+	RetryAfter time.Duration // How long until the request is counted again
+}
+
+func (e *LimitedError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+func (e *LimitedError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// Who a request is counted by, the client address stands in for a missing account or email
+type Caller struct {
+	IP      string
+	Account int    // Zero without a valid token
+	Email   string // From the request body, empty if there is none
+	Route   string // The route or method called
+}
+
+// A named rate limit policy with its counters in a sliding window, the REST routes and the gRPC methods under the
+// same name share a limiter so calls count the same whichever API they go through
+type Limiter struct {
+	Policy  ratelimit.Policy
+	counter ratelimit.Counter
+}
+
+// Creates the limiter of a named policy with counters in a store, named policies sharing a store count separately
+func NewLimiter(name string, policy ratelimit.Policy, store ratelimit.Store) *Limiter {
+	limiter := &Limiter{Policy: policy}
+	if policy.Limit > 0 {
+		limiter.counter = store.Counter(name, policy.Window)
+	}
+	return limiter
+}
+
+// Counts a request returning how many are left in the window, over the limit it fails with a *LimitedError.
+// Requests over the limit are counted too since the store increments before the limit is checked
+func (limiter *Limiter) Allow(ctx context.Context, caller Caller) (int, error) {
+	if limiter.Policy.Limit == 0 {
+		return 0, nil
+	}
+	now := time.Now().UTC()
+	current := now.Truncate(limiter.Policy.Window)
+	// The store counts atomically so concurrent requests, on this instance or another, can't all see the same count
+	hits, previous, err := limiter.counter.Increment(ctx, limiter.key(caller), current, current.Add(-limiter.Policy.Window))
+	if err != nil {
+		return 0, err
+	}
+	// The previous window counts as much as it still overlaps with the sliding one
+	overlap := float64(limiter.Policy.Window-now.Sub(current)) / float64(limiter.Policy.Window)
+	rate := int(math.Round(float64(previous)*overlap)) + hits
+	if rate > limiter.Policy.Limit {
+		return 0, &LimitedError{RetryAfter: limiter.Policy.Window}
+	}
+	return limiter.Policy.Limit - rate, nil
+}
+
+// Combines the keys of the policy into the one the caller is counted by
+func (limiter *Limiter) key(caller Caller) string {
+	var key strings.Builder
+	for _, name := range limiter.Policy.Keys {
+		switch {
+		case name == ratelimit.KeyAccount && caller.Account != 0:
+			key.WriteString("account:" + strconv.Itoa(caller.Account))
+		case name == ratelimit.KeyEmail && caller.Email != "":
+			key.WriteString("email:" + caller.Email)
+		case name == ratelimit.KeyRoute:
+			key.WriteString(caller.Route)
+		default:
+			key.WriteString("ip:" + network(caller.IP))
+		}
+		key.WriteRune(':')
+	}
+	return key.String()
+}
+
+// Middleware limiting requests by a limiter, responses carry the RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers
+func RateLimit(limiter *Limiter, ja *jwtauth.JWTAuth) func(http.Handler) http.Handler {
+	policy := limiter.Policy
+	if policy.Limit == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, err := callerOf(r, policy, ja)
+			if err != nil {
+				handlers.Fail(w, r, err)
+				return
+			}
+			// Routes under many policies list all of them while the other headers follow the innermost one
+			now := time.Now().UTC()
+			reset := now.Truncate(policy.Window).Add(policy.Window).Sub(now)
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset.Seconds())))
+			w.Header().Add("RateLimit-Policy", policy.String())
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+			remaining, err := limiter.Allow(r.Context(), caller)
+			var limited *LimitedError
+			if errors.As(err, &limited) {
+				w.Header().Set("RateLimit-Remaining", "0")
+				w.Header().Set("Retry-After", strconv.Itoa(int(limited.RetryAfter.Seconds())))
+				utils.WriteProblem(w, r, ErrRateLimited)
+				return
+			}
+			if err != nil {
+				handlers.Fail(w, r, err)
+				return
+			}
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Gets who a request is counted by, only looking at the token and the body if the policy counts by them
+func callerOf(r *http.Request, policy ratelimit.Policy, ja *jwtauth.JWTAuth) (Caller, error) {
+	caller := Caller{IP: utils.ClientIP(r), Route: r.URL.Path}
+	for _, key := range policy.Keys {
+		switch key {
+		case ratelimit.KeyAccount:
+			// Counting by account so clients sharing an address don't share a limit
+			token, err := jwtauth.VerifyRequest(ja, r, jwtauth.TokenFromHeader, jwtauth.TokenFromCookie)
+			if err == nil {
+				if id, ok := token.PrivateClaims()["account"].(float64); ok {
+					caller.Account = int(id)
+				}
+			}
+		case ratelimit.KeyEmail:
+			// Counting by email so guessing the password of one account from many addresses is limited
+			if r.Body == nil {
+				continue
+			}
+			// Reading the body leaving it in place for the handler
+			body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+			if err != nil {
+				return Caller{}, err
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var payload struct {
+				Email string `json:"email"`
+			}
+			if json.Unmarshal(body, &payload) == nil {
+				if email, err := utils.NormalizeEmail(payload.Email); err == nil {
+					caller.Email = email
+				}
+			}
+		}
+	}
+	return caller, nil
+}
+
+// Counts ipv6 clients by their /64 network since a single one usually gets all of it
+func network(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.Addr().String()
+}
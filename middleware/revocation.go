@@ -15,31 +15,33 @@ func Revocation(handler *handlers.AuthHandler) func(http.Handler) http.Handler {
 			// Getting the token from the request context
 			token, _, err := jwtauth.FromContext(r.Context())
 			if err != nil {
-				utils.Response(w, http.StatusUnauthorized,
-					map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-				)
+				handlers.Fail(w, r, handlers.ErrInvalidToken)
 				return
 			}
 			tokenID := token.JwtID()
 			if tokenID == "" {
-				utils.Response(w, http.StatusUnauthorized,
-					map[string]interface{}{"message": "missing token", "status": http.StatusUnauthorized},
-				)
+				handlers.Fail(w, r, handlers.ErrInvalidToken)
 				return
 			}
-			// Querying the database for the token
-			exists, err := handler.BS.FindToken(tokenID)
+			// Claiming the account id from request context
+			id, err := utils.ContextClaimID(r)
 			if err != nil {
-				utils.Response(w, http.StatusInternalServerError,
-					map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-				)
+				handlers.Fail(w, r, handlers.ErrInvalidToken)
 				return
 			}
-			// Denying access if the token is blacklisted
-			if exists {
-				utils.Response(w, http.StatusUnauthorized,
-					map[string]interface{}{"message": "token revoked", "status": http.StatusUnauthorized},
-				)
+			issued, err := utils.ClaimIssued(r.Context())
+			if err != nil {
+				handlers.Fail(w, r, handlers.ErrInvalidToken)
+				return
+			}
+			// Denying access if the token or every token of the account issued before now was revoked
+			revoked, err := handler.BS.IsRevoked(r.Context(), tokenID, id, issued)
+			if err != nil {
+				handlers.Fail(w, r, err)
+				return
+			}
+			if revoked {
+				handlers.Fail(w, r, handlers.ErrTokenRevoked)
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/0xalby/based/utils"
+)
+
+// Wrapper around http.ResponseWriter buffering the response until the handler returns
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	body        bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.body.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	tw.wroteHeader = true
+	tw.statusCode = code
+}
+
+// Problems responded with when a request doesn't complete
+var (
+	ErrTimedOut  = utils.NewProblem(http.StatusGatewayTimeout, "timed_out", "request timed out")
+	ErrCancelled = utils.NewProblem(http.StatusServiceUnavailable, "cancelled", "request cancelled")
+)
+
+// Timeout middleware bounding how long a request can take
+func Timeout(timeout time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Deriving a context cancelled on deadline or client disconnection
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+			// Running the handler while buffering its response
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+				// Flushing the buffered response
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				for key, values := range tw.header {
+					w.Header()[key] = values
+				}
+				if !tw.wroteHeader {
+					tw.statusCode = http.StatusOK
+				}
+				w.WriteHeader(tw.statusCode)
+				w.Write(tw.body.Bytes())
+			case <-ctx.Done():
+				// Discarding whatever the handler writes from now on
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					utils.WriteProblem(w, r, ErrTimedOut)
+					return
+				}
+				utils.WriteProblem(w, r, ErrCancelled)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/0xalby/based/handlers"
+	"github.com/0xalby/based/locale"
 	"github.com/0xalby/based/utils"
 )
 
@@ -19,39 +20,23 @@ func Verified(handler *handlers.AuthHandler) func(http.Handler) http.Handler {
 			// Claiming the account id from request context
 			id, err := utils.ContextClaimID(r)
 			if err != nil {
-				if err.Error() == "failed to get claims" || err.Error() == "account not found in claims or not a float64" {
-					utils.Response(w, http.StatusUnauthorized,
-						map[string]interface{}{"message": "invalid token", "status": http.StatusUnauthorized},
-					)
-					return
-				}
-				utils.Response(w, http.StatusInternalServerError,
-					map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-				)
+				handlers.Fail(w, r, err)
 				return
 			}
 			// Getting the account
-			account, err := handler.AS.GetAccountByID(id)
+			account, err := handler.AS.GetAccountByID(r.Context(), id)
 			if err != nil {
-				if err.Error() == "account not found" {
-					utils.Response(w, http.StatusBadRequest,
-						map[string]interface{}{"message": "account not existing", "status": http.StatusBadRequest},
-					)
-					return
-				}
-				utils.Response(w, http.StatusInternalServerError,
-					map[string]interface{}{"message": "internal server error", "status": http.StatusInternalServerError},
-				)
+				handlers.Fail(w, r, err)
 				return
 			}
 			// Checking for email verification
 			if !account.Verified {
-				utils.Response(w, http.StatusForbidden,
-					map[string]interface{}{"message": "email not verified", "status": http.StatusForbidden},
-				)
+				handlers.Fail(w, r, handlers.ErrNotVerified)
 				return
 			}
-			ctx := context.WithValue(r.Context(), userKey, account)
+			// Responding in the account's preferred locale
+			ctx := locale.Prefer(r.Context(), account.Locale)
+			ctx = context.WithValue(ctx, userKey, account)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
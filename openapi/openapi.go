@@ -0,0 +1,263 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// An OpenAPI 3.1 document, only the parts the API uses
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Tags       []Tag                 `json:"tags,omitempty"`
+	routes     map[string]*Operation // Operations by "METHOD path" for the router check
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Operations of a path by lowercase http method
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+	Ref         string               `json:"$ref,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	Responses       map[string]Response       `json:"responses"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// A JSON schema, only the keywords the validator tags translate to
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []any              `json:"enum,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+}
+
+// Generates the schema of a payload struct from its json and validate tags
+func SchemaOf(v any) *Schema {
+	return schemaOf(reflect.TypeOf(v))
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Struct:
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := schemaOf(field.Type)
+			if constrain(property, field.Tag.Get("validate")) {
+				schema.Required = append(schema.Required, name)
+			}
+			schema.Properties[name] = property
+		}
+		return schema
+	}
+	return &Schema{}
+}
+
+// Translates validator rules into schema keywords reporting whether the field is required
+func constrain(schema *Schema, tag string) (required bool) {
+	if tag == "" || tag == "-" {
+		return false
+	}
+	rules := strings.Split(tag, ",")
+	optional := slices.Contains(rules, "omitempty")
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "email":
+			schema.Format = "email"
+		case "min":
+			if n, err := strconv.Atoi(param); err == nil {
+				schema.MinLength = &n
+			}
+		case "max":
+			if n, err := strconv.Atoi(param); err == nil {
+				schema.MaxLength = &n
+			}
+		case "len":
+			if n, err := strconv.Atoi(param); err == nil {
+				schema.MinLength, schema.MaxLength = &n, &n
+			}
+		case "oneof":
+			if optional {
+				schema.Enum = append(schema.Enum, "")
+			}
+			for _, value := range strings.Fields(param) {
+				schema.Enum = append(schema.Enum, value)
+			}
+		case "hexadecimal":
+			schema.Pattern = "^(0[xX])?[0-9a-fA-F]+$"
+		case "ascii":
+			schema.Pattern = "^[\\x00-\\x7F]*$"
+		case "containsany":
+			// Patterns aren't anchored so a character class matches anywhere
+			schema.Pattern = "[" + escapeClass(param) + "]"
+		}
+	}
+	return required && !optional
+}
+
+// Escapes characters with a meaning inside a regular expression character class
+func escapeClass(chars string) string {
+	var b strings.Builder
+	for _, c := range chars {
+		if strings.ContainsRune(`\]^-[`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Adds an operation to the document
+func (doc *Document) add(method, path string, operation *Operation) {
+	if doc.Paths == nil {
+		doc.Paths = map[string]PathItem{}
+		doc.routes = map[string]*Operation{}
+	}
+	item, ok := doc.Paths[path]
+	if !ok {
+		item = PathItem{}
+		doc.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = operation
+	doc.routes[method+" "+path] = operation
+}
+
+// Checks every route registered on the router is documented and returns the document restricted to them
+func (doc *Document) Sync(router chi.Routes) (*Document, error) {
+	registered := map[string]bool{}
+	var undocumented []string
+	err := chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		// Chi reports subrouter routes with a trailing slash and wildcard
+		route = strings.TrimSuffix(strings.TrimSuffix(route, "/*"), "/")
+		key := method + " " + route
+		registered[key] = true
+		if _, ok := doc.routes[key]; !ok {
+			undocumented = append(undocumented, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(undocumented) > 0 {
+		sort.Strings(undocumented)
+		return nil, fmt.Errorf("routes missing from the openapi document: %s", strings.Join(undocumented, ", "))
+	}
+	// Leaving out routes disabled by the configuration
+	synced := *doc
+	synced.Paths = map[string]PathItem{}
+	for path, item := range doc.Paths {
+		for method, operation := range item {
+			if !registered[strings.ToUpper(method)+" "+path] {
+				continue
+			}
+			if synced.Paths[path] == nil {
+				synced.Paths[path] = PathItem{}
+			}
+			synced.Paths[path][method] = operation
+		}
+	}
+	return &synced, nil
+}
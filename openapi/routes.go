@@ -0,0 +1,329 @@
+package openapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/0xalby/based/types"
+	"github.com/0xalby/based/utils"
+)
+
+// A documented route
+type route struct {
+	method      string
+	path        string
+	tag         string
+	summary     string
+	description string
+	security    string // Either bearer, admin or empty for public routes
+	payload     any    // Request body, nil if the route has none
+	parameters  []Parameter
+	status      int
+	response    map[string]*Schema // Fields responded with besides message and status
+}
+
+// Every route the API can register, routes disabled by the configuration are left out by Sync
+var routes = []route{
+	{
+		method: http.MethodPost, path: "/auth/register", tag: "auth",
+		summary: "Register an account", description: "Emails a verification code if email is enabled",
+		payload: types.PayloadRegister{}, parameters: []Parameter{challengeHeader}, status: http.StatusCreated,
+	},
+	{
+		method: http.MethodPost, path: "/auth/login", tag: "auth",
+		summary: "Log into an account", description: "The token is also set as the jwt cookie, sign-ins from new devices or locations are emailed or, with SIGNIN_VERIFY, need the emailed signin_code",
+		payload: types.PayloadLogin{}, status: http.StatusOK,
+		response: map[string]*Schema{"token": {Type: "string"}, "redirect": {Type: "string"}},
+	},
+	{
+		method: http.MethodPost, path: "/auth/logout", tag: "auth", security: "bearer",
+		summary: "Log out revoking the token", status: http.StatusOK,
+	},
+	{
+		method: http.MethodPost, path: "/auth/verification", tag: "auth", security: "bearer",
+		summary: "Verify the account with the emailed code",
+		payload: types.PayloadVerification{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodGet, path: "/auth/resend", tag: "auth", security: "bearer",
+		summary: "Resend the verification email", status: http.StatusOK,
+	},
+	{
+		method: http.MethodPost, path: "/auth/backup", tag: "auth",
+		summary: "Log in with a 2FA backup code", description: "Generates a new TOTP secret and backup codes",
+		payload: types.PayloadLoginWithBackupCode{}, status: http.StatusOK,
+		response: totpResponse,
+	},
+	{
+		method: http.MethodPost, path: "/auth/unlock", tag: "auth",
+		summary: "Unlock an account locked after too many failed logins", description: "The token is emailed once the account gets locked",
+		payload: types.PayloadUnlock{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodPost, path: "/auth/secure", tag: "auth",
+		summary: "Log out every session after a sign-in from a new device or location", description: "The token is emailed on every sign-in from a new device or location",
+		payload: types.PayloadSecure{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodGet, path: "/auth/challenge", tag: "auth",
+		summary: "Issue a proof of work challenge", description: "Only registered if CHALLENGE is pow, the challenge is bound to the client address",
+		status: http.StatusOK,
+		response: map[string]*Schema{
+			"challenge":  {Type: "string", Description: "Find a counter such that SHA-256(\"<challenge>:<counter>\") starts with difficulty zero bits and send \"<challenge>:<counter>\" as X-Challenge"},
+			"difficulty": {Type: "integer"},
+			"expiration": {Type: "string", Format: "date-time"},
+		},
+	},
+	{
+		method: http.MethodPut, path: "/account/totp/enable", tag: "account", security: "bearer",
+		summary: "Enable 2FA(TOTP)", status: http.StatusOK,
+		response: totpResponse,
+	},
+	{
+		method: http.MethodPut, path: "/account/totp/disable", tag: "account", security: "bearer",
+		summary: "Disable 2FA(TOTP)", status: http.StatusOK,
+	},
+	{
+		method: http.MethodGet, path: "/account/confirmation", tag: "account", security: "bearer",
+		summary: "Send the account changes confirmation email", description: "Emails a code to the new address and a cancellation link to the old one",
+		payload: types.PayloadAccountSendConfirmationEmail{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodPut, path: "/account/update/email", tag: "account", security: "bearer",
+		summary: "Update the account email", description: "The old address is emailed a link to revert the change",
+		payload: types.PayloadAccountUpdateEmail{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodPut, path: "/account/update/password", tag: "account", security: "bearer",
+		summary: "Update the account password",
+		payload: types.PayloadAccountUpdatePassword{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodPut, path: "/account/update/locale", tag: "account", security: "bearer",
+		summary: "Update the account preferred locale", description: "An empty locale follows the Accept-Language header",
+		payload: types.PayloadAccountUpdateLocale{}, status: http.StatusOK,
+		response: map[string]*Schema{"locale": {Type: "string"}},
+	},
+	{
+		method: http.MethodDelete, path: "/account/delete", tag: "account", security: "bearer",
+		summary: "Delete the account",
+		payload: types.PayloadAccountDelete{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodPost, path: "/account/invitations", tag: "account", security: "bearer",
+		summary: "Create an invitation", description: "Only registered if REGISTRATION_MODE is invite, single use and lasting INVITATION_DAYS, emailed if an address is given",
+		payload: types.PayloadInvitation{}, status: http.StatusCreated,
+		response: invitationResponse,
+	},
+	{
+		method: http.MethodGet, path: "/account/invitations", tag: "account", security: "bearer",
+		summary: "List the account invitations", status: http.StatusOK,
+		response: invitationsResponse,
+	},
+	{
+		method: http.MethodDelete, path: "/account/invitations/{id}", tag: "account", security: "bearer",
+		summary: "Revoke an invitation of the account",
+		parameters: []Parameter{{
+			Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"},
+		}},
+		status: http.StatusOK,
+	},
+	{
+		method: http.MethodGet, path: "/account/recovery", tag: "account",
+		summary: "Email a password reset code",
+		payload: types.PayloadAccountRecovery{}, parameters: []Parameter{challengeHeader}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodPost, path: "/account/reset", tag: "account",
+		summary: "Reset the password with the emailed code",
+		payload: types.PayloadAccountReset{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodPost, path: "/account/email/cancel", tag: "account",
+		summary: "Cancel or revert an email change", description: "The token is emailed to the old address, every session gets logged out",
+		payload: types.PayloadAccountCancelEmailChange{}, status: http.StatusOK,
+	},
+	{
+		method: http.MethodGet, path: "/admin/outbox", tag: "admin", security: "admin",
+		summary: "List outbox emails",
+		parameters: []Parameter{{
+			Name: "status", In: "query", Description: "Only list emails with this status",
+			Schema: &Schema{Type: "string", Enum: []any{"pending", "sending", "sent", "dead"}},
+		}},
+		status:   http.StatusOK,
+		response: map[string]*Schema{"emails": {Type: "array", Items: &Schema{Ref: "#/components/schemas/Email"}}},
+	},
+	{
+		method: http.MethodPost, path: "/admin/outbox/{id}/retry", tag: "admin", security: "admin",
+		summary: "Retry a dead outbox email",
+		parameters: []Parameter{{
+			Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"},
+		}},
+		status: http.StatusOK,
+	},
+	{
+		method: http.MethodPost, path: "/admin/accounts/{id}/unlock", tag: "admin", security: "admin",
+		summary: "Unlock an account and forget its failed logins",
+		parameters: []Parameter{{
+			Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"},
+		}},
+		status: http.StatusOK,
+	},
+	{
+		method: http.MethodGet, path: "/admin/janitor", tag: "admin", security: "admin",
+		summary: "Rows the janitor purged", description: "Counted per table since the instance started",
+		status:   http.StatusOK,
+		response: map[string]*Schema{"purged": {Ref: "#/components/schemas/Purged"}},
+	},
+	{
+		method: http.MethodPost, path: "/admin/invitations", tag: "admin", security: "admin",
+		summary: "Create an invitation", description: "Only registered if REGISTRATION_MODE is invite, uses and days override the defaults",
+		payload: types.PayloadInvitation{}, status: http.StatusCreated,
+		response: invitationResponse,
+	},
+	{
+		method: http.MethodGet, path: "/admin/invitations", tag: "admin", security: "admin",
+		summary: "List every invitation", status: http.StatusOK,
+		response: invitationsResponse,
+	},
+	{
+		method: http.MethodDelete, path: "/admin/invitations/{id}", tag: "admin", security: "admin",
+		summary: "Revoke any invitation",
+		parameters: []Parameter{{
+			Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"},
+		}},
+		status: http.StatusOK,
+	},
+	{
+		method: http.MethodGet, path: "/openapi.json", tag: "meta",
+		summary: "This document", status: http.StatusOK,
+	},
+}
+
+// Header carrying the challenge response on the routes it can be enforced on
+var challengeHeader = Parameter{
+	Name: "X-Challenge", In: "header", Description: "A CAPTCHA token or a solved proof of work, required if CHALLENGE_ROUTES lists the route",
+	Schema: &Schema{Type: "string"},
+}
+
+// Fields responded with when a TOTP secret is generated
+var totpResponse = map[string]*Schema{
+	"secret":  {Type: "string"},
+	"qr_code": {Type: "string", Format: "byte", Description: "Base64 encoded png"},
+	"backup":  {Type: "array", Items: &Schema{Type: "string"}},
+}
+
+// Fields responded with when an invitation is created, the token is never shown again
+var invitationResponse = map[string]*Schema{
+	"invitation": {Ref: "#/components/schemas/Invitation"},
+	"token":      {Type: "string"},
+}
+
+// Fields responded with when listing invitations
+var invitationsResponse = map[string]*Schema{
+	"invitations": {Type: "array", Items: &Schema{Ref: "#/components/schemas/Invitation"}},
+}
+
+// Creates the document of the API mounted under /api/v{version}
+func New(version string) *Document {
+	doc := &Document{
+		OpenAPI: "3.1.0",
+		Info: Info{
+			Title:       "Based",
+			Description: "Authentication with email verification and 2FA TOTP",
+			Version:     version,
+		},
+		Servers: []Server{{URL: "/api/v" + version}},
+		Tags: []Tag{
+			{Name: "auth", Description: "Registration and sessions"},
+			{Name: "account", Description: "Account management and recovery"},
+			{Name: "admin", Description: "Operations requiring the admin token"},
+			{Name: "meta", Description: "The API itself"},
+		},
+		Components: Components{
+			Schemas: map[string]*Schema{
+				"Problem":    SchemaOf(utils.Problem{}),
+				"FieldError": SchemaOf(utils.FieldError{}),
+				"Email":      SchemaOf(types.Email{}),
+				"Invitation": SchemaOf(types.Invitation{}),
+				"Purged":     SchemaOf(types.Purged{}),
+			},
+			Responses: map[string]Response{
+				"Problem": {
+					Description: "Problem details, rely on code rather than the localized detail",
+					Content:     map[string]MediaType{"application/problem+json": {Schema: &Schema{Ref: "#/components/schemas/Problem"}}},
+				},
+			},
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				"cookie": {Type: "apiKey", In: "cookie", Name: "jwt", Description: "Set by login"},
+				"admin":  {Type: "http", Scheme: "bearer", Description: "The API_ADMIN_TOKEN"},
+			},
+		},
+	}
+	doc.Components.Schemas["Problem"].Properties["errors"].Items = &Schema{Ref: "#/components/schemas/FieldError"}
+	for _, route := range routes {
+		operation := &Operation{
+			Summary:     route.summary,
+			Description: route.description,
+			OperationID: operationID(route.method, route.path),
+			Tags:        []string{route.tag},
+			Parameters:  route.parameters,
+			Responses: map[string]Response{
+				strconv.Itoa(route.status): {
+					Description: http.StatusText(route.status),
+					Content:     map[string]MediaType{"application/json": {Schema: message(route.response)}},
+				},
+				"default": {Ref: "#/components/responses/Problem"},
+			},
+		}
+		switch route.security {
+		case "bearer":
+			operation.Security = []map[string][]string{{"bearer": {}}, {"cookie": {}}}
+		case "admin":
+			operation.Security = []map[string][]string{{"admin": {}}}
+		}
+		if route.payload != nil {
+			operation.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{"application/json": {Schema: SchemaOf(route.payload)}},
+			}
+		}
+		// The document itself isn't a message
+		if route.path == "/openapi.json" {
+			operation.Responses[strconv.Itoa(route.status)] = Response{
+				Description: http.StatusText(route.status),
+				Content:     map[string]MediaType{"application/json": {Schema: &Schema{Type: "object"}}},
+			}
+		}
+		doc.add(route.method, route.path, operation)
+	}
+	return doc
+}
+
+// Schema of the messages responded with on success
+func message(fields map[string]*Schema) *Schema {
+	schema := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"message": {Type: "string", Description: "Localized by Accept-Language"},
+			"status":  {Type: "integer"},
+		},
+		Required: []string{"status"},
+	}
+	for name, field := range fields {
+		schema.Properties[name] = field
+	}
+	return schema
+}
+
+// Derives an operation id from the method and path(example "postAuthRegister")
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '.' || r == '{' || r == '}' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xalby/based/mailer"
+	"github.com/0xalby/based/services"
+)
+
+// Fails every delivery until told otherwise
+type flakyMailer struct {
+	mailer.Memory
+	failing atomic.Bool
+}
+
+func (m *flakyMailer) Send(ctx context.Context, message *mailer.Message) error {
+	if m.failing.Load() {
+		return errors.New("unreachable")
+	}
+	return m.Memory.Send(ctx, message)
+}
+
+func newOutbox(t *testing.T) (*services.OutboxService, *flakyMailer) {
+	t.Helper()
+	mail := &flakyMailer{}
+	return &services.OutboxService{
+		DB:          migrate(t),
+		Mailer:      mail,
+		Key:         []byte(strings.Repeat("k", 32)),
+		MaxAttempts: 3,
+		Backoff:     time.Hour,
+		Lease:       time.Minute,
+	}, mail
+}
+
+// Waits for the outbox to deliver a number of emails
+func delivered(t *testing.T, mail *flakyMailer, count int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(mail.Messages()) < count; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d emails delivered, want %d", len(mail.Messages()), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutboxEncryptsAndForgetsEmails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outbox, mail := newOutbox(t)
+	mail.failing.Store(true)
+	if err := outbox.Run(ctx, 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	message := &mailer.Message{To: "someone@example.com", Subject: "Account Recovery", HTML: "<p>SECRET</p>"}
+	if err := outbox.Send(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := outbox.DB.QueryRow("SELECT message FROM outbox").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == "" || strings.Contains(stored, "SECRET") || strings.Contains(stored, "someone@example.com") {
+		t.Fatalf("queued email stored in the clear: %q", stored)
+	}
+	// Waiting for the failed attempt to be recorded so it can't push the next one back
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var attempts int
+		if err := outbox.DB.QueryRow("SELECT attempts FROM outbox").Scan(&attempts); err != nil {
+			t.Fatal(err)
+		}
+		if attempts > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the email wasn't attempted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Delivered emails are decrypted and their content dropped
+	mail.failing.Store(false)
+	if _, err := outbox.DB.Exec("UPDATE outbox SET next = ?", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Send(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	delivered(t, mail, 2)
+	if mail.Messages()[0].HTML != message.HTML {
+		t.Fatalf("delivered %q, want %q", mail.Messages()[0].HTML, message.HTML)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var left int
+		if err := outbox.DB.QueryRow("SELECT COUNT(*) FROM outbox WHERE status <> ? OR message <> ''", services.OutboxSent).Scan(&left); err != nil {
+			t.Fatal(err)
+		}
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d emails kept their content after being sent", left)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutboxLeases(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outbox, mail := newOutbox(t)
+	if err := outbox.Send(ctx, &mailer.Message{To: "held@example.com", Subject: "held"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Send(ctx, &mailer.Message{To: "expired@example.com", Subject: "expired"}); err != nil {
+		t.Fatal(err)
+	}
+	// Another instance is sending one and stopped while sending the other
+	_, err := outbox.DB.Exec("UPDATE outbox SET status = ?, owner = 'other', lease = ? WHERE recipient = 'held@example.com'", services.OutboxSending, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = outbox.DB.Exec("UPDATE outbox SET status = ?, owner = 'other', lease = ? WHERE recipient = 'expired@example.com'", services.OutboxSending, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Run(ctx, 2, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	delivered(t, mail, 1)
+	time.Sleep(100 * time.Millisecond)
+	messages := mail.Messages()
+	if len(messages) != 1 || messages[0].To != "expired@example.com" {
+		t.Fatalf("delivered %d emails, want only the one whose lease expired", len(messages))
+	}
+	var status string
+	if err := outbox.DB.QueryRow("SELECT status FROM outbox WHERE recipient = 'held@example.com'").Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != services.OutboxSending {
+		t.Fatalf("email leased by another instance is %s, want it left alone", status)
+	}
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What requests are counted by
+const (
+	KeyIP      = "ip"      // The client address
+	KeyAccount = "account" // The account id in the token, the client address if there is none
+	KeyEmail   = "email"   // The email in the request body, the client address if there is none
+	KeyRoute   = "route"   // The request path
+)
+
+// A limit of requests in a sliding window counted by a combination of keys
+type Policy struct {
+	Limit  int           // Zero disables the policy
+	Window time.Duration // Length of the window
+	Keys   []string      // Combined into the key requests are counted by, every request shares one if empty
+}
+
+// Parses a policy written as "<limit>/<window> <key>[,<key>]"(example "20/1h ip" or "5/24h email,route") or "off"
+func ParsePolicy(s string) (Policy, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" {
+		return Policy{}, nil
+	}
+	rate, keys, _ := strings.Cut(s, " ")
+	limit, window, found := strings.Cut(rate, "/")
+	if !found {
+		return Policy{}, fmt.Errorf("rate limit policy %q isn't <limit>/<window>", s)
+	}
+	var (
+		policy Policy
+		err    error
+	)
+	if policy.Limit, err = strconv.Atoi(limit); err != nil || policy.Limit < 0 {
+		return Policy{}, fmt.Errorf("rate limit policy %q has a bad limit", s)
+	}
+	if policy.Window, err = time.ParseDuration(window); err != nil || policy.Window < time.Second {
+		return Policy{}, fmt.Errorf("rate limit policy %q has a bad window", s)
+	}
+	for _, key := range strings.Split(strings.TrimSpace(keys), ",") {
+		switch key = strings.TrimSpace(key); key {
+		case "":
+		case KeyIP, KeyAccount, KeyEmail, KeyRoute:
+			policy.Keys = append(policy.Keys, key)
+		default:
+			return Policy{}, fmt.Errorf("rate limit policy %q has an unknown key %q", s, key)
+		}
+	}
+	return policy, nil
+}
+
+// Formats the policy as a RateLimit-Policy header item(example "20;w=3600")
+func (policy Policy) String() string {
+	return fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window.Seconds()))
+}
+
+// Counts requests by key in fixed windows which limiters weigh into sliding ones
+type Counter interface {
+	// Atomically adds a request to a key's current window returning the requests in it, this one included, and
+	// the ones in the previous window
+	Increment(ctx context.Context, key string, current, previous time.Time) (int, int, error)
+}
+
+// Where counters live, each limiter gets its own counter with its window
+type Store interface {
+	// Creates the counter of a named limiter, names keep limiters sharing a store apart
+	Counter(name string, window time.Duration) Counter
+}
+
+// Counters in memory, reset on restart and per instance
+type Memory struct{}
+
+func (Memory) Counter(name string, window time.Duration) Counter {
+	return &memoryCounter{window: window, windows: make(map[string]*memoryWindow)}
+}
+
+type memoryCounter struct {
+	mu      sync.Mutex
+	window  time.Duration
+	windows map[string]*memoryWindow
+	swept   time.Time
+}
+
+// A key's current window along with the hits of the one before it
+type memoryWindow struct {
+	start    time.Time
+	hits     int
+	previous int
+}
+
+func (c *memoryCounter) Increment(ctx context.Context, key string, current, previous time.Time) (int, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Forgetting keys whose windows are too old to count at most once per window
+	if current.After(c.swept) {
+		for key, window := range c.windows {
+			if window.start.Before(previous) {
+				delete(c.windows, key)
+			}
+		}
+		c.swept = current
+	}
+	window, ok := c.windows[key]
+	switch {
+	case !ok:
+		window = &memoryWindow{start: current}
+		c.windows[key] = window
+	case window.start.Equal(previous):
+		window.start, window.previous, window.hits = current, window.hits, 0
+	case !window.start.Equal(current):
+		window.start, window.previous, window.hits = current, 0, 0
+	}
+	window.hits++
+	return window.hits, window.previous, nil
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/0xalby/based/database/redis"
+)
+
+// Counters shared by every instance using the server, windows expire on their own
+type Redis struct {
+	Client *redis.Client
+	Prefix string // Key prefix(example "based:")
+}
+
+func (store *Redis) Counter(name string, window time.Duration) Counter {
+	return &redisCounter{client: store.Client, prefix: store.Prefix + "ratelimit:" + name + ":", window: window}
+}
+
+type redisCounter struct {
+	client *redis.Client
+	prefix string
+	window time.Duration
+}
+
+func (c *redisCounter) Increment(ctx context.Context, key string, current, previous time.Time) (int, int, error) {
+	name := c.key(key, current)
+	// The previous window is still needed while the current one lasts
+	expiration := strconv.FormatInt(current.Add(2*c.window).UnixMilli(), 10)
+	// INCRBY counts atomically so concurrent requests each get their own count
+	replies, err := c.client.Pipeline(ctx, [][]string{
+		{"INCRBY", name, "1"},
+		{"PEXPIREAT", name, expiration},
+		{"GET", c.key(key, previous)},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return 0, 0, err
+		}
+	}
+	hits, _ := replies[0].(int64)
+	// A missing previous window is nil
+	var before int
+	if value, ok := replies[2].(string); ok {
+		if before, err = strconv.Atoi(value); err != nil {
+			return 0, 0, err
+		}
+	}
+	return int(hits), before, nil
+}
+
+// Gets the name of a key's counter in a window
+func (c *redisCounter) key(key string, window time.Time) string {
+	return c.prefix + key + ":" + strconv.FormatInt(window.Unix(), 10)
+}
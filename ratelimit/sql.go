@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Counters in the ratelimits table shared by every instance using the database, the janitor purges old windows
+type SQL struct {
+	DB *sql.DB
+}
+
+func (store *SQL) Counter(name string, window time.Duration) Counter {
+	return &sqlCounter{db: store.DB, name: name, window: window}
+}
+
+type sqlCounter struct {
+	db     *sql.DB
+	name   string
+	window time.Duration
+}
+
+func (c *sqlCounter) Increment(ctx context.Context, key string, current, previous time.Time) (int, int, error) {
+	bucket := c.name + ":" + key
+	// The previous window is still needed while the current one lasts
+	expiration := current.Add(2 * c.window).Unix()
+	// Upserting and returning the hits in one statement so concurrent requests each get their own count
+	var hits int
+	err := c.db.QueryRowContext(ctx,
+		`INSERT INTO ratelimits (bucket, start, hits, expiration) VALUES (?, ?, 1, ?)
+		ON CONFLICT (bucket, start) DO UPDATE SET hits = ratelimits.hits + excluded.hits RETURNING hits`,
+		bucket, current.Unix(), expiration).Scan(&hits)
+	if err != nil {
+		log.Error("failed to database upsert", "err", err)
+		return 0, 0, err
+	}
+	var before int
+	err = c.db.QueryRowContext(ctx, "SELECT hits FROM ratelimits WHERE bucket = ? AND start = ?", bucket, previous.Unix()).Scan(&before)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error("failed to database select", "err", err)
+		return 0, 0, err
+	}
+	return hits, before, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xalby/based/database/redis"
+	"github.com/0xalby/based/database/redis/redistest"
+	"github.com/0xalby/based/middleware"
+	"github.com/0xalby/based/ratelimit"
+)
+
+func TestLimiterStores(t *testing.T) {
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &redis.Client{Address: server.Addr}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	stores := map[string]ratelimit.Store{
+		"memory": ratelimit.Memory{},
+		"sql":    &ratelimit.SQL{DB: migrate(t)},
+		"redis":  &ratelimit.Redis{Client: client, Prefix: "test:"},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			limiter := middleware.NewLimiter("test", ratelimit.Policy{Limit: 10, Window: time.Hour, Keys: []string{ratelimit.KeyIP}}, store)
+			// Concurrent requests each get their own count so no more than the limit get through
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				allowed int
+			)
+			for range 30 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := limiter.Allow(ctx, middleware.Caller{IP: "192.0.2.1"})
+					var limited *middleware.LimitedError
+					if err != nil && !errors.As(err, &limited) {
+						t.Error(err)
+						return
+					}
+					if err == nil {
+						mu.Lock()
+						allowed++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if allowed != 10 {
+				t.Fatalf("%d requests allowed, want 10", allowed)
+			}
+			// Other callers have their own count
+			remaining, err := limiter.Allow(ctx, middleware.Caller{IP: "192.0.2.2"})
+			if err != nil || remaining != 9 {
+				t.Fatalf("another caller has %d requests left, err %v, want 9", remaining, err)
+			}
+			// Shared stores give up on the request once its deadline passed
+			if name != "memory" {
+				expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+				defer cancel()
+				_, err := limiter.Allow(expired, middleware.Caller{IP: "192.0.2.3"})
+				var limited *middleware.LimitedError
+				if err == nil || errors.As(err, &limited) {
+					t.Fatalf("got %v past the deadline, want the store's error", err)
+				}
+			}
+		})
+	}
+}
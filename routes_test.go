@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/0xalby/based/openapi"
+	"github.com/go-chi/chi/v5"
+)
+
+// Every optional route group enabled
+var everything = map[string]string{
+	"MAIL_OUTBOX_WORKERS": "1",
+	"REGISTRATION_MODE":   "invite",
+	"CHALLENGE":           "pow",
+	"CHALLENGE_SECRET":    "a challenge secret of at least 32 characters",
+	"CHALLENGE_ROUTES":    "recovery",
+}
+
+// Lists the routes of the router as "METHOD path" relative to where the API is mounted
+func walk(t *testing.T, router http.Handler) []string {
+	t.Helper()
+	routes, ok := router.(chi.Routes)
+	if !ok {
+		t.Fatalf("%T isn't a chi router", router)
+	}
+	var registered []string
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		route = strings.TrimSuffix(strings.TrimSuffix(route, "/*"), "/")
+		registered = append(registered, method+" "+strings.TrimPrefix(route, "/api/v1"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(registered)
+	return registered
+}
+
+// Lists the operations of a document as "METHOD path"
+func operations(paths map[string]openapi.PathItem) []string {
+	var documented []string
+	for path, item := range paths {
+		for method := range item {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(documented)
+	return documented
+}
+
+// Fetches the document served by the API
+func served(t *testing.T, api *testAPI) map[string]openapi.PathItem {
+	t.Helper()
+	raw, err := api.Client().OpenAPI(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document openapi.Document
+	if err := json.Unmarshal(raw, &document); err != nil {
+		t.Fatal(err)
+	}
+	return document.Paths
+}
+
+func diff(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	wanted := map[string]bool{}
+	for _, route := range want {
+		wanted[route] = true
+	}
+	for _, route := range got {
+		if !wanted[route] {
+			t.Errorf("%s: unexpected %s", what, route)
+		}
+		delete(wanted, route)
+	}
+	for route := range wanted {
+		t.Errorf("%s: missing %s", what, route)
+	}
+}
+
+func TestRoutesMatchTheDocument(t *testing.T) {
+	for name, env := range map[string]map[string]string{
+		"everything": everything,
+		"defaults":   nil,
+		"no email":   {"MAIL_TRANSPORT": ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := newTestAPI(t, env)
+			registered := walk(t, api.Handler)
+			if name == "everything" {
+				// With every option on the router has every route of the document
+				diff(t, "router against the whole document", registered, operations(openapi.New("1").Paths))
+			}
+			// And the served document has exactly the registered routes whatever the options
+			diff(t, "router against the served document", registered, operations(served(t, api)))
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/0xalby/based/handlers"
+	"github.com/0xalby/based/locale"
+	basedv1 "github.com/0xalby/based/proto/based/v1"
+	"github.com/0xalby/based/services"
+	"github.com/0xalby/based/types"
+	"github.com/0xalby/based/utils"
+)
+
+// The gRPC counterpart of handlers.AccountsHandler
+type AccountServer struct {
+	basedv1.UnimplementedAccountServiceServer
+	AS *services.AccountsService
+	ES *services.EmailService
+	TS *services.TotpService
+	BS *services.BlacklistService
+	CS *services.ChangesService
+	PS *services.PasswordService
+	HS *services.HistoryService
+	DS *services.DomainService
+	IS *services.InvitationsService
+}
+
+func (server *AccountServer) EnableTOTP(ctx context.Context, req *basedv1.EnableTOTPRequest) (*basedv1.EnableTOTPResponse, error) {
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if account.TotpEnabled {
+		return nil, fail(ctx, handlers.ErrTOTPEnabled)
+	}
+	totp, err := newTOTP(ctx, server.TS, account)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.EnableTOTP(ctx, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.EnableTOTPResponse{Message: locale.T(ctx, "enabled"), Totp: totp}, nil
+}
+
+func (server *AccountServer) DisableTOTP(ctx context.Context, req *basedv1.DisableTOTPRequest) (*basedv1.DisableTOTPResponse, error) {
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if !account.TotpEnabled {
+		return nil, fail(ctx, handlers.ErrTOTPDisabled)
+	}
+	if err := server.AS.DisableTOTP(ctx, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Deleting leftover backup codes
+	if err := server.TS.DeleteBackupCodes(ctx, account.ID); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.DisableTOTPResponse{Message: locale.T(ctx, "disabled")}, nil
+}
+
+func (server *AccountServer) SendConfirmationEmail(ctx context.Context, req *basedv1.SendConfirmationEmailRequest) (*basedv1.SendConfirmationEmailResponse, error) {
+	if !server.ES.Enabled() {
+		return nil, errEmailDisabled
+	}
+	payload := types.PayloadAccountSendConfirmationEmail{Email: req.New}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	email, err := utils.NormalizeEmail(payload.Email)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	payload.Email = email
+	if err := server.DS.Check(ctx, payload.Email); err != nil {
+		return nil, fail(ctx, err)
+	}
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if account.Email == payload.Email {
+		return nil, fail(ctx, handlers.ErrSameEmail)
+	}
+	// Letting the old address cancel the change in case the account was taken over, before any code is out
+	token, expiration, err := server.CS.RequestEmailChange(ctx, id, account.Email, payload.Email)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.SendEmailChangeEmail(ctx, account.Email, payload.Email, token, false, expiration); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.AddConfirmationCode(ctx, code, id, payload.Email); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.SavePending(ctx, payload.Email, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.SendVerificationEmail(ctx, payload.Email, code); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.SendConfirmationEmailResponse{Message: locale.T(ctx, "confirmation email sent")}, nil
+}
+
+func (server *AccountServer) UpdateEmail(ctx context.Context, req *basedv1.UpdateEmailRequest) (*basedv1.UpdateEmailResponse, error) {
+	payload := types.PayloadAccountUpdateEmail{Code: req.Code}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if account.Pending == "" {
+		return nil, fail(ctx, services.ErrInvalidCode)
+	}
+	if err := server.ES.CompareCodes(ctx, payload.Code, id, account.Pending); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Letting the old address revert the change for a while, without a recorded change it can't so the email stays
+	change, token, err := server.CS.ConfirmEmailChange(ctx, id, account.Pending)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.UpdateAccountEmail(ctx, account.Pending, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.CleanPendingEmail(ctx, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if server.ES.Enabled() {
+		if err := server.ES.SendNotificationEmail(ctx, account.Pending, "Updated email address", "Your email address has been updated"); err != nil {
+			return nil, fail(ctx, err)
+		}
+		if err := server.ES.SendEmailChangeEmail(ctx, change.Old, change.New, token, true, change.Expiration); err != nil {
+			return nil, fail(ctx, err)
+		}
+	}
+	return &basedv1.UpdateEmailResponse{Message: locale.T(ctx, "updated")}, nil
+}
+
+func (server *AccountServer) CancelEmailChange(ctx context.Context, req *basedv1.CancelEmailChangeRequest) (*basedv1.CancelEmailChangeResponse, error) {
+	if !server.ES.Enabled() {
+		return nil, errEmailDisabled
+	}
+	payload := types.PayloadAccountCancelEmailChange{Token: req.Token}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	change, err := server.CS.CancelEmailChange(ctx, payload.Token)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Giving the old address back to its owner
+	if change.Status == services.ChangeReverted {
+		if err := server.AS.UpdateAccountEmail(ctx, change.Old, change.Account); err != nil {
+			return nil, fail(ctx, err)
+		}
+	}
+	// Forgetting the pending email and its confirmation code
+	if err := server.AS.CleanPendingEmail(ctx, change.Account); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.DeleteConfirmationCodes(ctx, change.Account, change.New); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Logging out every session since whoever asked for the change might be in
+	if err := server.BS.RevokeAccount(ctx, change.Account); err != nil {
+		return nil, fail(ctx, err)
+	}
+	message := "email change cancelled"
+	if change.Status == services.ChangeReverted {
+		message = "email change reverted"
+	}
+	return &basedv1.CancelEmailChangeResponse{Message: locale.T(ctx, message)}, nil
+}
+
+func (server *AccountServer) UpdatePassword(ctx context.Context, req *basedv1.UpdatePasswordRequest) (*basedv1.UpdatePasswordResponse, error) {
+	payload := types.PayloadAccountUpdatePassword{Old: req.Old, New: req.New}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if payload.Old == payload.New {
+		return nil, fail(ctx, handlers.ErrSamePassword)
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if !utils.CompareHashedAndPlain(account.Password, payload.Old) {
+		return nil, fail(ctx, handlers.ErrWrongPassword)
+	}
+	if err := server.PS.Check(ctx, payload.New, account.Email); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.HS.Check(ctx, account, payload.New); err != nil {
+		return nil, fail(ctx, err)
+	}
+	hashed, err := utils.Hash(payload.New)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.UpdateAccountPassword(ctx, hashed, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.HS.Record(ctx, id, account.Password); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if server.ES.Enabled() {
+		if err := server.ES.SendNotificationEmail(ctx, account.Email, "Updated password", "Your password has been updated"); err != nil {
+			return nil, fail(ctx, err)
+		}
+	}
+	return &basedv1.UpdatePasswordResponse{Message: locale.T(ctx, "updated")}, nil
+}
+
+func (server *AccountServer) UpdateLocale(ctx context.Context, req *basedv1.UpdateLocaleRequest) (*basedv1.UpdateLocaleResponse, error) {
+	payload := types.PayloadAccountUpdateLocale{Locale: req.Locale}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.UpdateLocale(ctx, id, payload.Locale); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Responding in the new locale right away
+	ctx = locale.Prefer(ctx, payload.Locale)
+	return &basedv1.UpdateLocaleResponse{Message: locale.T(ctx, "updated"), Locale: payload.Locale}, nil
+}
+
+func (server *AccountServer) DeleteAccount(ctx context.Context, req *basedv1.DeleteAccountRequest) (*basedv1.DeleteAccountResponse, error) {
+	payload := types.PayloadAccountDelete{Password: req.Password}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if !utils.CompareHashedAndPlain(account.Password, payload.Password) {
+		return nil, fail(ctx, handlers.ErrWrongPassword)
+	}
+	if err := server.HS.Clear(ctx, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.DeleteAccount(ctx, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Deleting leftover account codes
+	if err := server.ES.DeleteCodes(ctx, id); err != nil && !errors.Is(err, services.ErrNoRowsAffected) {
+		return nil, fail(ctx, err)
+	}
+	if server.ES.Enabled() {
+		if err := server.ES.SendNotificationEmail(ctx, account.Email, "Deleted account", "Your account has been deleted, goodbye"); err != nil {
+			return nil, fail(ctx, err)
+		}
+	}
+	return &basedv1.DeleteAccountResponse{Message: locale.T(ctx, "deleted")}, nil
+}
+
+func (server *AccountServer) Recovery(ctx context.Context, req *basedv1.RecoveryRequest) (*basedv1.RecoveryResponse, error) {
+	if !server.ES.Enabled() {
+		return nil, errEmailDisabled
+	}
+	payload := types.PayloadAccountRecovery{Email: req.Email}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByEmail(ctx, payload.Email)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Emailing and responding in the account's preferred locale
+	ctx = locale.Prefer(ctx, account.Locale)
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.AddRecoveryCode(ctx, code, account.ID); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.SendRecoveryEmail(ctx, account.Email, code); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.RecoveryResponse{Message: locale.T(ctx, "recovery email sent")}, nil
+}
+
+func (server *AccountServer) Reset(ctx context.Context, req *basedv1.ResetRequest) (*basedv1.ResetResponse, error) {
+	if !server.ES.Enabled() {
+		return nil, errEmailDisabled
+	}
+	payload := types.PayloadAccountReset{Code: req.Code, Password: req.Password}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	id, err := server.ES.GetAccountIDByCodeOwnership(ctx, payload.Code)
+	if err != nil {
+		// Only recovery codes are expected here
+		if errors.Is(err, services.ErrInvalidCode) {
+			err = handlers.ErrInvalidRecoveryCode
+		}
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Checking the new password before the code is used up
+	if err := server.PS.Check(ctx, payload.Password, account.Email); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.HS.Check(ctx, account, payload.Password); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.CompareRecoveryCodes(ctx, payload.Code, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	hashed, err := utils.Hash(payload.Password)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.UpdateAccountPassword(ctx, hashed, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.HS.Record(ctx, id, account.Password); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Logging out every session since the password might have been compromised
+	if err := server.BS.RevokeAccount(ctx, id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.ResetResponse{Message: locale.T(ctx, "recovered")}, nil
+}
+
+func (server *AccountServer) CreateInvitation(ctx context.Context, req *basedv1.CreateInvitationRequest) (*basedv1.CreateInvitationResponse, error) {
+	if server.IS.Mode != services.RegistrationInvite {
+		return nil, errInvitationsDisabled
+	}
+	payload := types.PayloadInvitation{Email: req.Email, Bind: req.Bind}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if payload.Bind && payload.Email == "" {
+		return nil, fail(ctx, handlers.ErrInvalidParameter)
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	bound := ""
+	if payload.Bind {
+		bound = payload.Email
+	}
+	token, invitation, err := server.IS.Create(ctx, id, bound, 0, 0)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Optionally emailing the invitation naming the account which sent it
+	if payload.Email != "" && server.ES.Enabled() {
+		account, err := server.AS.GetAccountByID(ctx, id)
+		if err != nil {
+			return nil, fail(ctx, err)
+		}
+		if err := server.ES.SendInvitationEmail(ctx, payload.Email, token, account.Email, invitation.Expiration); err != nil {
+			return nil, fail(ctx, err)
+		}
+	}
+	return &basedv1.CreateInvitationResponse{
+		Message:    locale.T(ctx, "invitation created"),
+		Invitation: newInvitation(invitation),
+		Token:      token,
+	}, nil
+}
+
+func (server *AccountServer) ListInvitations(ctx context.Context, req *basedv1.ListInvitationsRequest) (*basedv1.ListInvitationsResponse, error) {
+	if server.IS.Mode != services.RegistrationInvite {
+		return nil, errInvitationsDisabled
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	invitations, err := server.IS.List(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	resp := &basedv1.ListInvitationsResponse{}
+	for i := range invitations {
+		resp.Invitations = append(resp.Invitations, newInvitation(&invitations[i]))
+	}
+	return resp, nil
+}
+
+func (server *AccountServer) RevokeInvitation(ctx context.Context, req *basedv1.RevokeInvitationRequest) (*basedv1.RevokeInvitationResponse, error) {
+	if server.IS.Mode != services.RegistrationInvite {
+		return nil, errInvitationsDisabled
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.IS.Revoke(ctx, int(req.Id), id); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.RevokeInvitationResponse{Message: locale.T(ctx, "invitation revoked")}, nil
+}
+
+// Converts an invitation to its message
+func newInvitation(invitation *types.Invitation) *basedv1.Invitation {
+	return &basedv1.Invitation{
+		Id:         int64(invitation.ID),
+		Email:      invitation.Email,
+		Uses:       int32(invitation.Uses),
+		MaxUses:    int32(invitation.MaxUses),
+		Expiration: invitation.Expiration.Unix(),
+		Created:    invitation.Created.Unix(),
+	}
+}
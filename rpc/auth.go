@@ -0,0 +1,387 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/0xalby/based/challenge"
+	"github.com/0xalby/based/config"
+	"github.com/0xalby/based/handlers"
+	"github.com/0xalby/based/locale"
+	basedv1 "github.com/0xalby/based/proto/based/v1"
+	"github.com/0xalby/based/services"
+	"github.com/0xalby/based/types"
+	"github.com/0xalby/based/utils"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/jwtauth/v5"
+)
+
+// The gRPC counterpart of handlers.AuthHandler
+type AuthServer struct {
+	basedv1.UnimplementedAuthServiceServer
+	AS *services.AccountsService
+	ES *services.EmailService
+	TS *services.TotpService
+	BS *services.BlacklistService
+	LS *services.LockoutService
+	PS *services.PasswordService
+	DS *services.DomainService
+	IS *services.InvitationsService
+	SS *services.SignInsService
+	// Issues proof of work challenges, nil unless they are the configured challenge
+	PoW *challenge.ProofOfWork
+}
+
+func (server *AuthServer) Register(ctx context.Context, req *basedv1.RegisterRequest) (*basedv1.RegisterResponse, error) {
+	payload := types.PayloadRegister{Email: req.Email, Password: req.Password, Locale: req.Locale, Invitation: req.Invitation}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.DS.Check(ctx, payload.Email); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.PS.Check(ctx, payload.Password, payload.Email); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Hashing the password
+	hashed, err := utils.Hash(payload.Password)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Creating an account
+	account := &types.Account{
+		Email:    payload.Email,
+		Password: hashed,
+		Locale:   payload.Locale,
+	}
+	// Responding and emailing in the preferred locale if given
+	ctx = locale.Prefer(ctx, payload.Locale)
+	if err := server.IS.Admit(ctx, payload.Invitation, payload.Email); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.CreateAccount(ctx, account); err != nil {
+		// Giving the invitation back since nobody registered with it
+		server.IS.Release(ctx, payload.Invitation)
+		return nil, fail(ctx, err)
+	}
+	// Optionally sending a verification email
+	if server.ES.Enabled() {
+		code, err := utils.GenerateRandomCode(6)
+		if err != nil {
+			return nil, fail(ctx, err)
+		}
+		if err := server.ES.SendVerificationEmail(ctx, account.Email, code); err != nil {
+			return nil, fail(ctx, err)
+		}
+		account, err = server.AS.GetAccountByEmail(ctx, account.Email)
+		if err != nil {
+			return nil, fail(ctx, err)
+		}
+		if err := server.ES.AddVerificationCode(ctx, code, account.ID); err != nil {
+			return nil, fail(ctx, err)
+		}
+	}
+	return &basedv1.RegisterResponse{Message: locale.T(ctx, "created")}, nil
+}
+
+func (server *AuthServer) Login(ctx context.Context, req *basedv1.LoginRequest) (*basedv1.LoginResponse, error) {
+	payload := types.PayloadLogin{Email: req.Email, Password: req.Password, TOTP: req.Totp, SignInCode: req.SigninCode}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Getting the account
+	account, err := server.AS.GetAccountByEmail(ctx, payload.Email)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Reserving the attempt before comparing anything and giving it back if the login neither fails nor succeeds
+	attempt, err := server.LS.Reserve(ctx, account.ID)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	defer server.LS.Release(ctx, attempt)
+	// Comparing passwords
+	if !utils.CompareHashedAndPlain(account.Password, payload.Password) {
+		return nil, server.failedLogin(ctx, account, attempt, handlers.ErrInvalidCredentials)
+	}
+	// Asking for totp validation if the account has it enabled
+	if account.TotpEnabled {
+		valid, err := server.TS.ValidateTOTP(ctx, account.ID, payload.TOTP)
+		if err != nil {
+			return nil, fail(ctx, err)
+		}
+		if !valid {
+			return nil, server.failedLogin(ctx, account, attempt, handlers.ErrWrongTOTP)
+		}
+	}
+	if err := server.signIn(ctx, account, attempt, payload.SignInCode); err != nil {
+		return nil, err
+	}
+	if err := server.LS.Succeed(ctx, attempt); err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Upgrading the hash to the current algorithm and parameters while the password is at hand
+	if utils.NeedsRehash(account.Password) {
+		hashed, err := utils.Hash(payload.Password)
+		if err == nil {
+			err = server.AS.UpdateAccountPassword(ctx, hashed, account.ID)
+		}
+		// Not failing the login over it, the hash gets upgraded on the next one
+		if err != nil {
+			log.Error("failed to rehash the password", "err", err)
+		}
+	}
+	token, _, err := config.IssueToken(account.ID)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.LoginResponse{Message: locale.T(ctx, "token generated"), Token: token}, nil
+}
+
+func (server *AuthServer) Logout(ctx context.Context, req *basedv1.LogoutRequest) (*basedv1.LogoutResponse, error) {
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	token, _, err := jwtauth.FromContext(ctx)
+	if err != nil || token.JwtID() == "" {
+		return nil, fail(ctx, handlers.ErrInvalidToken)
+	}
+	exp, err := utils.ClaimExpiration(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if exp.Before(time.Now()) {
+		return nil, fail(ctx, handlers.ErrTokenExpired)
+	}
+	// Revoking the jwt token
+	if err := server.BS.RevokeToken(ctx, token.JwtID(), id, exp); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.LogoutResponse{Message: locale.T(ctx, "logged out")}, nil
+}
+
+func (server *AuthServer) Verify(ctx context.Context, req *basedv1.VerifyRequest) (*basedv1.VerifyResponse, error) {
+	if !server.ES.Enabled() {
+		return nil, errEmailDisabled
+	}
+	payload := types.PayloadVerification{Code: req.Code}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Responding in the account's preferred locale
+	ctx = locale.Prefer(ctx, account.Locale)
+	if account.Verified {
+		return nil, fail(ctx, handlers.ErrAlreadyVerified)
+	}
+	// Comparing verification codes
+	if err := server.ES.CompareCodes(ctx, payload.Code, account.ID, ""); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.AS.MarkAccountAsVerified(ctx, account.ID); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.VerifyResponse{Message: locale.T(ctx, "verified")}, nil
+}
+
+func (server *AuthServer) ResendVerification(ctx context.Context, req *basedv1.ResendVerificationRequest) (*basedv1.ResendVerificationResponse, error) {
+	if !server.ES.Enabled() {
+		return nil, errEmailDisabled
+	}
+	id, err := utils.ClaimID(ctx)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	// Responding in the account's preferred locale
+	ctx = locale.Prefer(ctx, account.Locale)
+	if account.Verified {
+		return nil, fail(ctx, handlers.ErrAlreadyVerified)
+	}
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.SendVerificationEmail(ctx, account.Email, code); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.ES.AddVerificationCode(ctx, code, account.ID); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.ResendVerificationResponse{Message: locale.T(ctx, "verification email resent")}, nil
+}
+
+func (server *AuthServer) LoginWithBackupCode(ctx context.Context, req *basedv1.LoginWithBackupCodeRequest) (*basedv1.LoginWithBackupCodeResponse, error) {
+	payload := types.PayloadLoginWithBackupCode{Email: req.Email, BackupCode: req.Code, SignInCode: req.SigninCode}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.AS.GetAccountByEmail(ctx, payload.Email)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if !account.Verified {
+		return nil, fail(ctx, handlers.ErrNotVerified)
+	}
+	// Reserving the attempt before comparing anything and giving it back if the login neither fails nor succeeds
+	attempt, err := server.LS.Reserve(ctx, account.ID)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	defer server.LS.Release(ctx, attempt)
+	// Validating and then deleting every backup code of the account
+	if err := server.TS.ValidateBackupCode(ctx, account.ID, payload.BackupCode); err != nil {
+		if errors.Is(err, services.ErrInvalidBackupCode) || errors.Is(err, services.ErrBackupCodeNotFound) {
+			return nil, server.failedLogin(ctx, account, attempt, err)
+		}
+		return nil, fail(ctx, err)
+	}
+	if err := server.signIn(ctx, account, attempt, payload.SignInCode); err != nil {
+		return nil, err
+	}
+	if err := server.LS.Succeed(ctx, attempt); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.TS.DeleteBackupCodes(ctx, account.ID); err != nil {
+		return nil, fail(ctx, err)
+	}
+	totp, err := newTOTP(ctx, server.TS, account)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.LoginWithBackupCodeResponse{Message: locale.T(ctx, "enabled"), Totp: totp}, nil
+}
+
+func (server *AuthServer) Unlock(ctx context.Context, req *basedv1.UnlockRequest) (*basedv1.UnlockResponse, error) {
+	if !server.ES.Enabled() {
+		return nil, errEmailDisabled
+	}
+	payload := types.PayloadUnlock{Token: req.Token}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	if _, err := server.LS.Unlock(ctx, payload.Token); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.UnlockResponse{Message: locale.T(ctx, "unlocked")}, nil
+}
+
+func (server *AuthServer) Secure(ctx context.Context, req *basedv1.SecureRequest) (*basedv1.SecureResponse, error) {
+	if !server.ES.Enabled() {
+		return nil, errEmailDisabled
+	}
+	payload := types.PayloadSecure{Token: req.Token}
+	if err := utils.Check(ctx, &payload); err != nil {
+		return nil, fail(ctx, err)
+	}
+	account, err := server.SS.Secure(ctx, payload.Token)
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	if err := server.BS.RevokeAccount(ctx, account); err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.SecureResponse{Message: locale.T(ctx, "every session logged out")}, nil
+}
+
+// Compares the context of a sign-in with the earlier ones like handlers.AuthHandler does returning the status
+// to fail with if the login can't go on
+func (server *AuthServer) signIn(ctx context.Context, account *types.Account, attempt *services.Attempt, code string) error {
+	network, agent := services.Fingerprint(peerIP(ctx), peerUserAgent(ctx))
+	known, err := server.SS.Known(ctx, account.ID, network, agent)
+	if err != nil {
+		return fail(ctx, err)
+	}
+	mailCtx := locale.Prefer(ctx, account.Locale)
+	if !known && server.SS.Verify && server.ES.Enabled() {
+		if code == "" {
+			code, err := server.SS.Challenge(ctx, account.ID, network, agent)
+			if err != nil {
+				return fail(ctx, err)
+			}
+			if err := server.ES.SendVerificationEmail(mailCtx, account.Email, code); err != nil {
+				return fail(ctx, err)
+			}
+			return fail(ctx, handlers.ErrSignInUnconfirmed)
+		}
+		if err := server.SS.Confirm(ctx, account.ID, network, agent, code); err != nil {
+			if errors.Is(err, services.ErrInvalidSignInCode) {
+				return server.failedLogin(ctx, account, attempt, err)
+			}
+			return fail(ctx, err)
+		}
+		return nil
+	}
+	token, err := server.SS.Record(ctx, account.ID, network, agent)
+	if err != nil {
+		return fail(ctx, err)
+	}
+	if !known && server.ES.Enabled() {
+		if err := server.ES.SendSignInEmail(mailCtx, account.Email, token, network, agent, time.Now()); err != nil {
+			log.Error("failed to send new sign-in email", "err", err)
+		}
+	}
+	return nil
+}
+
+// Settles an attempt as failed like handlers.AuthHandler does returning the status to fail with
+func (server *AuthServer) failedLogin(ctx context.Context, account *types.Account, attempt *services.Attempt, reason error) error {
+	token, until := server.LS.Fail(attempt)
+	if token == "" {
+		return fail(ctx, reason)
+	}
+	if server.ES.Enabled() {
+		if err := server.ES.SendUnlockEmail(locale.Prefer(ctx, account.Locale), account.Email, token, until); err != nil {
+			return fail(ctx, err)
+		}
+	}
+	return fail(ctx, &services.LockedError{Err: services.ErrAccountLocked, Until: until})
+}
+
+// Generates a totp secret, its qrcode and backup codes for an account
+func newTOTP(ctx context.Context, totp *services.TotpService, account *types.Account) (*basedv1.TOTP, error) {
+	key, err := totp.GenerateTOTPSecret(ctx, account.Email, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	qrCode, err := totp.GenerateQRCode(key)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := totp.GenerateBackupCodes(12, 8)
+	if err != nil {
+		return nil, err
+	}
+	if err := totp.AddBackupCodes(ctx, codes, account.ID); err != nil {
+		return nil, err
+	}
+	return &basedv1.TOTP{Secret: key.Secret(), QrCode: qrCode, Backup: codes}, nil
+}
+
+func (server *AuthServer) Challenge(ctx context.Context, req *basedv1.ChallengeRequest) (*basedv1.ChallengeResponse, error) {
+	if server.PoW == nil {
+		return nil, errPoWDisabled
+	}
+	puzzle, err := server.PoW.Issue(peerIP(ctx))
+	if err != nil {
+		return nil, fail(ctx, err)
+	}
+	return &basedv1.ChallengeResponse{
+		Message:    locale.T(ctx, "challenge issued"),
+		Challenge:  puzzle.Challenge,
+		Difficulty: int32(puzzle.Difficulty),
+		Expiration: puzzle.Expiration.Unix(),
+	}, nil
+}
@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/0xalby/based/handlers"
+	"github.com/0xalby/based/locale"
+	"github.com/0xalby/based/middleware"
+	"github.com/0xalby/based/services"
+	"github.com/charmbracelet/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Grpc codes problems map to by their http status
+var codesByStatus = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusLocked:              codes.PermissionDenied,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// Returned by methods whose REST routes aren't registered without a mailer
+var errEmailDisabled = status.Error(codes.Unimplemented, "email is disabled")
+
+// Returned by the challenge method unless proof of work is the configured challenge
+var errPoWDisabled = status.Error(codes.Unimplemented, "proof of work is disabled")
+
+// Returned by the invitation methods unless registrations are invite only
+var errInvitationsDisabled = status.Error(codes.Unimplemented, "invitations are disabled")
+
+// Maps an error to a status carrying the problem code as ErrorInfo reason, the same codes as the REST API
+func fail(ctx context.Context, err error) error {
+	problem := handlers.ProblemFor(err)
+	if problem == handlers.ErrInternal {
+		log.Error("internal error", "err", err)
+	}
+	code, ok := codesByStatus[problem.Status]
+	if !ok {
+		code = codes.Unknown
+	}
+	st := status.New(code, locale.T(ctx, problem.Detail))
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: problem.Code, Domain: "based"}}
+	if len(problem.Errors) > 0 {
+		violations := &errdetails.BadRequest{}
+		for _, field := range problem.Errors {
+			violations.FieldViolations = append(violations.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       field.Field,
+				Description: field.Message,
+				Reason:      field.Code,
+			})
+		}
+		details = append(details, violations)
+	}
+	// Telling clients when to try logging in again
+	var locked *services.LockedError
+	if errors.As(err, &locked) {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(time.Until(locked.Until))})
+	}
+	// And after how long calls over a rate limit are counted again
+	var limited *middleware.LimitedError
+	if errors.As(err, &limited) {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(limited.RetryAfter)})
+	}
+	if detailed, err := st.WithDetails(details...); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
@@ -0,0 +1,240 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/0xalby/based/challenge"
+	"github.com/0xalby/based/handlers"
+	"github.com/0xalby/based/locale"
+	"github.com/0xalby/based/middleware"
+	"github.com/0xalby/based/services"
+	"github.com/0xalby/based/utils"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/jwtauth/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Logs calls like middleware.Logger
+func Logger(logger *log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		var from string
+		if p, ok := peer.FromContext(ctx); ok {
+			from = p.Addr.String()
+		}
+		logger.Infof("received %s from %s", info.FullMethod, from)
+		resp, err := handler(ctx, req)
+		logger.Infof("responded with %s in %s", status.Code(err), time.Since(start))
+		return resp, err
+	}
+}
+
+// Replaces the peer address with the client one a trusted proxy forwarded in the x-forwarded-for or x-real-ip
+// metadata like middleware.RealIP
+func RealIP(proxies []netip.Prefix) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		p, ok := peer.FromContext(ctx)
+		if !ok || len(proxies) == 0 {
+			return handler(ctx, req)
+		}
+		var realIP string
+		if values := metadata.ValueFromIncomingContext(ctx, "x-real-ip"); len(values) > 0 {
+			realIP = values[0]
+		}
+		forwarded := middleware.ForwardedIP(peerIP(ctx), metadata.ValueFromIncomingContext(ctx, "x-forwarded-for"), realIP, proxies)
+		if addr, err := netip.ParseAddr(forwarded); err == nil {
+			client := *p
+			client.Addr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, 0))
+			ctx = peer.NewContext(ctx, &client)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Refuses calls from addresses the filter of their service or the global one doesn't allow like middleware.FilterIPs
+func FilterIPs(global middleware.IPFilter, services map[string]middleware.IPFilter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ip := peerIP(ctx)
+		// Methods are named "/<service>/<method>"
+		service, _, _ := strings.Cut(strings.TrimPrefix(info.FullMethod, "/"), "/")
+		if !global.Allows(ip) || !services[service].Allows(ip) {
+			return nil, fail(ctx, middleware.ErrAddressNotAllowed)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Limits calls by the global limiter and the one of their route like middleware.RateLimit, sharing the
+// limiters with the REST routes so both APIs count together
+func RateLimit(ja *jwtauth.JWTAuth, limiters map[string]*middleware.Limiter, methods map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		caller := middleware.Caller{IP: peerIP(ctx), Route: info.FullMethod}
+		// Counting by account when the token is valid whether or not it was revoked, like the REST routes
+		if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+			bearer, _ := strings.CutPrefix(values[0], "Bearer ")
+			if token, err := jwtauth.VerifyToken(ja, bearer); err == nil {
+				if id, ok := token.PrivateClaims()["account"].(float64); ok {
+					caller.Account = int(id)
+				}
+			}
+		}
+		if payload, ok := req.(interface{ GetEmail() string }); ok {
+			if email, err := utils.NormalizeEmail(payload.GetEmail()); err == nil {
+				caller.Email = email
+			}
+		}
+		for _, name := range []string{"global", methods[info.FullMethod]} {
+			limiter, ok := limiters[name]
+			if !ok {
+				continue
+			}
+			if _, err := limiter.Allow(ctx, caller); err != nil {
+				return nil, fail(ctx, err)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Negotiates the locale from the accept-language metadata like middleware.Locale
+func Locale(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	lang := locale.Default
+	if values := metadata.ValueFromIncomingContext(ctx, "accept-language"); len(values) > 0 {
+		lang = locale.Negotiate(values[0])
+	}
+	return handler(locale.WithLocale(ctx, lang), req)
+}
+
+// Bounds calls duration like middleware.Timeout
+func Timeout(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		resp, err := handler(ctx, req)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fail(ctx, middleware.ErrTimedOut)
+		}
+		return resp, err
+	}
+}
+
+// Verifies the bearer token in the authorization metadata and denies revoked ones like
+// jwtauth.Verifier, middleware.Authenticator and middleware.Revocation, public methods are let through
+func Authenticate(ja *jwtauth.JWTAuth, blacklist *services.BlacklistService, public map[string]bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if public[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		// Getting the token from the metadata
+		var bearer string
+		if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+			bearer, _ = strings.CutPrefix(values[0], "Bearer ")
+		}
+		if bearer == "" {
+			return nil, fail(ctx, handlers.ErrInvalidToken)
+		}
+		token, err := jwtauth.VerifyToken(ja, bearer)
+		if errors.Is(err, jwtauth.ErrExpired) {
+			return nil, fail(ctx, handlers.ErrTokenExpired)
+		}
+		if err != nil || token.JwtID() == "" {
+			return nil, fail(ctx, handlers.ErrInvalidToken)
+		}
+		ctx = jwtauth.NewContext(ctx, token, nil)
+		// Claiming the account id from the token
+		id, err := utils.ClaimID(ctx)
+		if err != nil {
+			return nil, fail(ctx, handlers.ErrInvalidToken)
+		}
+		issued, err := utils.ClaimIssued(ctx)
+		if err != nil {
+			return nil, fail(ctx, handlers.ErrInvalidToken)
+		}
+		// Denying access if the token or every token of the account issued before now was revoked
+		revoked, err := blacklist.IsRevoked(ctx, token.JwtID(), id, issued)
+		if err != nil {
+			return nil, fail(ctx, err)
+		}
+		if revoked {
+			return nil, fail(ctx, handlers.ErrTokenRevoked)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Assures accounts calling the given methods are verified like middleware.Verified
+func Verified(accounts *services.AccountsService, methods map[string]bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		// Claiming the account id from the token
+		id, err := utils.ClaimID(ctx)
+		if err != nil {
+			return nil, fail(ctx, err)
+		}
+		// Getting the account
+		account, err := accounts.GetAccountByID(ctx, id)
+		if err != nil {
+			return nil, fail(ctx, err)
+		}
+		// Checking for email verification
+		if !account.Verified {
+			return nil, fail(ctx, handlers.ErrNotVerified)
+		}
+		// Responding in the account's preferred locale
+		return handler(locale.Prefer(ctx, account.Locale), req)
+	}
+}
+
+// Checks the challenge response in the x-challenge metadata of the given methods like middleware.Challenge
+func Challenge(verifier challenge.Verifier, methods map[string]bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if verifier == nil || !methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		var response string
+		if values := metadata.ValueFromIncomingContext(ctx, "x-challenge"); len(values) > 0 {
+			response = values[0]
+		}
+		err := verifier.Verify(ctx, response, peerIP(ctx))
+		switch {
+		case errors.Is(err, challenge.ErrMissing):
+			return nil, fail(ctx, middleware.ErrChallengeRequired)
+		case errors.Is(err, challenge.ErrFailed):
+			return nil, fail(ctx, middleware.ErrChallengeFailed)
+		case err != nil:
+			return nil, fail(ctx, err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Gets the caller address without the port like utils.ClientIP
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// Gets the caller user agent, grpc-go appends its own to the one set by the application
+func peerUserAgent(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, "user-agent"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
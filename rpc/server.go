@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/0xalby/based/challenge"
+	"github.com/0xalby/based/config"
+	"github.com/0xalby/based/middleware"
+	basedv1 "github.com/0xalby/based/proto/based/v1"
+	"github.com/charmbracelet/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// Methods callable without a token, like the routes outside the jwtauth groups
+var public = map[string]bool{
+	basedv1.AuthService_Register_FullMethodName:             true,
+	basedv1.AuthService_Login_FullMethodName:                true,
+	basedv1.AuthService_LoginWithBackupCode_FullMethodName:  true,
+	basedv1.AuthService_Unlock_FullMethodName:               true,
+	basedv1.AuthService_Secure_FullMethodName:               true,
+	basedv1.AuthService_Challenge_FullMethodName:            true,
+	basedv1.AccountService_CancelEmailChange_FullMethodName: true,
+	basedv1.AccountService_Recovery_FullMethodName:          true,
+	basedv1.AccountService_Reset_FullMethodName:             true,
+}
+
+// Methods requiring a verified account, like the routes behind middleware.Verified
+var verified = map[string]bool{
+	basedv1.AccountService_EnableTOTP_FullMethodName:            true,
+	basedv1.AccountService_DisableTOTP_FullMethodName:           true,
+	basedv1.AccountService_SendConfirmationEmail_FullMethodName: true,
+	basedv1.AccountService_UpdateEmail_FullMethodName:           true,
+	basedv1.AccountService_UpdatePassword_FullMethodName:        true,
+	basedv1.AccountService_UpdateLocale_FullMethodName:          true,
+	basedv1.AccountService_DeleteAccount_FullMethodName:         true,
+	basedv1.AccountService_CreateInvitation_FullMethodName:      true,
+	basedv1.AccountService_ListInvitations_FullMethodName:       true,
+	basedv1.AccountService_RevokeInvitation_FullMethodName:      true,
+}
+
+// Methods a challenge can be enforced on by the name of their route
+var challengeable = map[string]string{
+	"register": basedv1.AuthService_Register_FullMethodName,
+	"recovery": basedv1.AccountService_Recovery_FullMethodName,
+}
+
+// Rate limit policies methods are under by the name of their route, on top of the global one every method is under
+var limited = map[string]string{
+	basedv1.AuthService_Register_FullMethodName:                 "register",
+	basedv1.AuthService_Login_FullMethodName:                    "login",
+	basedv1.AuthService_Verify_FullMethodName:                   "verification",
+	basedv1.AuthService_ResendVerification_FullMethodName:       "resend",
+	basedv1.AuthService_LoginWithBackupCode_FullMethodName:      "backup",
+	basedv1.AuthService_Unlock_FullMethodName:                   "unlock",
+	basedv1.AuthService_Secure_FullMethodName:                   "secure",
+	basedv1.AuthService_Challenge_FullMethodName:                "challenge",
+	basedv1.AccountService_SendConfirmationEmail_FullMethodName: "confirmation",
+	basedv1.AccountService_DeleteAccount_FullMethodName:         "delete",
+	basedv1.AccountService_CreateInvitation_FullMethodName:      "invitations",
+	basedv1.AccountService_Recovery_FullMethodName:              "recovery",
+	basedv1.AccountService_CancelEmailChange_FullMethodName:     "cancel",
+}
+
+// Services route group address filters apply to
+var groups = map[string]string{
+	"auth":    basedv1.AuthService_ServiceDesc.ServiceName,
+	"account": basedv1.AccountService_ServiceDesc.ServiceName,
+}
+
+// Creates a gRPC server with the auth and account services behind the same checks as the REST routes,
+// the verifier is enforced on the methods of the named routes, the filters are keyed by route group with
+// the empty one applying to every method, the addresses come from the proxies if the peer is one of them and
+// the limiters are keyed by the name of their policy
+func NewServer(auth *AuthServer, account *AccountServer, logger *log.Logger, timeout time.Duration, verifier challenge.Verifier, routes []string, proxies []netip.Prefix, filters map[string]middleware.IPFilter, limiters map[string]*middleware.Limiter) *grpc.Server {
+	challenged := map[string]bool{}
+	for _, route := range routes {
+		challenged[challengeable[route]] = true
+	}
+	services := map[string]middleware.IPFilter{}
+	for group, service := range groups {
+		services[service] = filters[group]
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		RealIP(proxies),
+		Logger(logger),
+		FilterIPs(filters[""], services),
+		Locale,
+		RateLimit(config.TokenAuth, limiters, limited),
+		Timeout(timeout),
+		Challenge(verifier, challenged),
+		Authenticate(config.TokenAuth, auth.BS, public),
+		Verified(auth.AS, verified),
+	))
+	basedv1.RegisterAuthServiceServer(server, auth)
+	basedv1.RegisterAccountServiceServer(server, account)
+	// Letting tools like grpcurl list the services
+	reflection.Register(server)
+	return server
+}
+
+// Routes gRPC requests to the gRPC server and everything else to the REST router sharing a port
+func Multiplex(server *grpc.Server, router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			server.ServeHTTP(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
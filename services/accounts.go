@@ -1,11 +1,13 @@
 package services
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
 	"strings"
+	"time"
 
 	"github.com/0xalby/based/types"
+	"github.com/0xalby/based/utils"
 	"github.com/charmbracelet/log"
 )
 
@@ -14,12 +16,20 @@ type AccountsService struct {
 }
 
 // Creates an account in the database
-func (service *AccountsService) CreateAccount(account *types.Account) error {
-	rows, err := service.DB.Exec("INSERT INTO accounts (email, password) VALUES (?,?)", account.Email, account.Password)
+func (service *AccountsService) CreateAccount(ctx context.Context, account *types.Account) error {
+	email, err := utils.NormalizeEmail(account.Email)
+	if err != nil {
+		return err
+	}
+	account.Email = email
+	if err := service.ensureNotReserved(ctx, account.Email, 0); err != nil {
+		return err
+	}
+	rows, err := service.DB.ExecContext(ctx, "INSERT INTO accounts (email, password, locale) VALUES (?,?,?)", account.Email, account.Password, account.Locale)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE") {
 			if strings.Contains(err.Error(), "email") {
-				return fmt.Errorf("email already used")
+				return ErrEmailUsed
 			}
 		}
 		log.Error("failed to database insert", "err", err)
@@ -33,15 +43,25 @@ func (service *AccountsService) CreateAccount(account *types.Account) error {
 	}
 	if affected == 0 {
 		log.Error("failed to create account")
-		return fmt.Errorf("no rows affected")
+		return ErrNoRowsAffected
 	}
 	return nil
 }
 
 // Updates account email in the database
-func (service *AccountsService) UpdateAccountEmail(email string, id int) error {
-	rows, err := service.DB.Exec("UPDATE accounts SET email = ? WHERE id = ?", email, id)
+func (service *AccountsService) UpdateAccountEmail(ctx context.Context, email string, id int) error {
+	email, err := utils.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if err := service.ensureNotReserved(ctx, email, id); err != nil {
+		return err
+	}
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET email = ? WHERE id = ?", email, id)
 	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return ErrEmailUsed
+		}
 		log.Error("failed to update the database", "err", err)
 		return err
 	}
@@ -52,14 +72,14 @@ func (service *AccountsService) UpdateAccountEmail(email string, id int) error {
 	}
 	if affected == 0 {
 		log.Error("failed to update account email")
-		return fmt.Errorf("no rows affected")
+		return ErrNoRowsAffected
 	}
 	return nil
 }
 
 // Updates account password in the database
-func (service *AccountsService) UpdateAccountPassword(password string, id int) error {
-	rows, err := service.DB.Exec("UPDATE accounts SET password = ? WHERE id = ?", password, id)
+func (service *AccountsService) UpdateAccountPassword(ctx context.Context, password string, id int) error {
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET password = ? WHERE id = ?", password, id)
 	if err != nil {
 		log.Error("failed to update the database", "err", err)
 		return err
@@ -71,14 +91,14 @@ func (service *AccountsService) UpdateAccountPassword(password string, id int) e
 	}
 	if affected == 0 {
 		log.Error("failed to update account password")
-		return fmt.Errorf("no rows affected")
+		return ErrNoRowsAffected
 	}
 	return nil
 }
 
 // Deletes an account in the database
-func (service *AccountsService) DeleteAccount(id int) error {
-	rows, err := service.DB.Exec("DELETE FROM accounts WHERE id = ?", id)
+func (service *AccountsService) DeleteAccount(ctx context.Context, id int) error {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM accounts WHERE id = ?", id)
 	if err != nil {
 		log.Error("failed to delete account", "err", err)
 		return err
@@ -90,15 +110,15 @@ func (service *AccountsService) DeleteAccount(id int) error {
 	}
 	if affected == 0 {
 		log.Error("failed to delete account")
-		return fmt.Errorf("no rows affected")
+		return ErrNoRowsAffected
 	}
 	return nil
 }
 
 // Gets an account by id
-func (service *AccountsService) GetAccountByID(id int) (*types.Account, error) {
+func (service *AccountsService) GetAccountByID(ctx context.Context, id int) (*types.Account, error) {
 	// Querying the database
-	rows, err := service.DB.Query("SELECT * FROM accounts WHERE id = ?", id)
+	rows, err := service.DB.QueryContext(ctx, "SELECT * FROM accounts WHERE id = ?", id)
 	if err != nil {
 		log.Error("failed to database query", "err", err)
 		return nil, err
@@ -114,7 +134,7 @@ func (service *AccountsService) GetAccountByID(id int) (*types.Account, error) {
 	}
 	// Checking if the account was found
 	if account == nil || account.ID == 0 {
-		return nil, fmt.Errorf("account not found")
+		return nil, ErrAccountNotFound
 	}
 	// Checking for row iteration errors
 	if err = rows.Err(); err != nil {
@@ -125,9 +145,13 @@ func (service *AccountsService) GetAccountByID(id int) (*types.Account, error) {
 }
 
 // Gets an account by email
-func (service *AccountsService) GetAccountByEmail(email string) (*types.Account, error) {
+func (service *AccountsService) GetAccountByEmail(ctx context.Context, email string) (*types.Account, error) {
+	email, err := utils.NormalizeEmail(email)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
 	// Querying the database
-	rows, err := service.DB.Query("SELECT * FROM accounts WHERE email = ?", email)
+	rows, err := service.DB.QueryContext(ctx, "SELECT * FROM accounts WHERE email = ?", email)
 	if err != nil {
 		log.Error("failed to database query", "err", err)
 		return nil, err
@@ -147,107 +171,147 @@ func (service *AccountsService) GetAccountByEmail(email string) (*types.Account,
 	}
 	if account == nil || account.ID == 0 {
 		log.Error("account not found")
-		return nil, fmt.Errorf("account not found")
+		return nil, ErrAccountNotFound
 	}
 	return account, nil
 }
 
 // Marks the account as verified
-func (service *AccountsService) MarkAccountAsVerified(id int) error {
-	rows, err := service.DB.Exec("UPDATE accounts SET verified = 1 WHERE id = ?", id)
+func (service *AccountsService) MarkAccountAsVerified(ctx context.Context, id int) error {
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET verified = 1 WHERE id = ?", id)
 	if err != nil {
-		// Checking for affected rows
-		affected, err := rows.RowsAffected()
-		if err != nil {
-			log.Error("failed to get affacted rows", "err", err)
-			return err
-		}
-		if affected == 0 {
-			log.Error("failed to add verification code")
-			return fmt.Errorf("no rows affected")
-		}
 		log.Error("failed to database update", "err", err)
 		return err
 	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to add verification code")
+		return ErrNoRowsAffected
+	}
 	return nil
 }
 
 // Saves pending email before confirmation
-func (service *AccountsService) SavePending(email string, account int) error {
-	rows, err := service.DB.Exec("UPDATE accounts SET pending = ? WHERE id = ?", email, account)
+func (service *AccountsService) SavePending(ctx context.Context, email string, account int) error {
+	email, err := utils.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET pending = ? WHERE id = ?", email, account)
 	if err != nil {
-		// Checking for affected rows
-		affected, err := rows.RowsAffected()
-		if err != nil {
-			log.Error("failed to get affacted rows", "err", err)
-			return err
-		}
-		if affected == 0 {
-			log.Error("failed to add pending email")
-			return fmt.Errorf("no rows affected")
-		}
 		log.Error("failed to database update", "err", err)
 		return err
 	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to add pending email")
+		return ErrNoRowsAffected
+	}
 	return nil
 }
 
-func (service *AccountsService) CleanPendingEmail(id int) error {
-	rows, err := service.DB.Exec("UPDATE accounts SET pending = ? WHERE id = ?", "", id)
+func (service *AccountsService) CleanPendingEmail(ctx context.Context, id int) error {
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET pending = ? WHERE id = ?", "", id)
+	if err != nil {
+		log.Error("failed to database update", "err", err)
+		return err
+	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to clean pending email")
+		return ErrNoRowsAffected
+	}
+	return nil
+}
+
+// Updates an account's preferred locale
+func (service *AccountsService) UpdateLocale(ctx context.Context, id int, locale string) error {
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET locale = ? WHERE id = ?", locale, id)
 	if err != nil {
-		// Checking for affected rows
-		affected, err := rows.RowsAffected()
-		if err != nil {
-			log.Error("failed to get affacted rows", "err", err)
-			return err
-		}
-		if affected == 0 {
-			log.Error("failed to clean pending email")
-			return fmt.Errorf("no rows affected")
-		}
 		log.Error("failed to database update", "err", err)
 		return err
 	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to update locale")
+		return ErrNoRowsAffected
+	}
 	return nil
 }
 
 // Enables 2fa totp for an account
-func (service *AccountsService) EnableTOTP(id int) error {
-	rows, err := service.DB.Exec("UPDATE accounts SET totp = 1 WHERE id = ?", id)
+func (service *AccountsService) EnableTOTP(ctx context.Context, id int) error {
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET totp = 1 WHERE id = ?", id)
 	if err != nil {
-		// Checking for affected rows
-		affected, err := rows.RowsAffected()
-		if err != nil {
-			log.Error("failed to get affacted rows", "err", err)
-			return err
-		}
-		if affected == 0 {
-			log.Error("failed to enable or disable 2fa totp")
-			return fmt.Errorf("no rows affected")
-		}
 		log.Error("failed to database update", "err", err)
 		return err
 	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to enable or disable 2fa totp")
+		return ErrNoRowsAffected
+	}
 	return nil
 }
 
 // Disables 2fa totp for an account
-func (service *AccountsService) DisableTOTP(id int) error {
-	rows, err := service.DB.Exec("UPDATE accounts SET totp = 0 WHERE id = ?", id)
+func (service *AccountsService) DisableTOTP(ctx context.Context, id int) error {
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET totp = 0 WHERE id = ?", id)
 	if err != nil {
-		// Checking for affected rows
-		affected, err := rows.RowsAffected()
-		if err != nil {
-			log.Error("failed to get affacted rows", "err", err)
-			return err
-		}
-		if affected == 0 {
-			log.Error("failed to disable 2fa totp")
-			return fmt.Errorf("no rows affected")
-		}
 		log.Error("failed to database update", "err", err)
 		return err
 	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to disable 2fa totp")
+		return ErrNoRowsAffected
+	}
+	return nil
+}
+
+// Ensures an address isn't kept for another account which can still revert a change away from it
+func (service *AccountsService) ensureNotReserved(ctx context.Context, email string, id int) error {
+	var reserved bool
+	err := service.DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM changes WHERE lower(old) = lower(?) AND account <> ? AND status = 'confirmed' AND expiration > ?)",
+		email, id, time.Now()).Scan(&reserved)
+	if err != nil {
+		log.Error("failed to database select", "err", err)
+		return err
+	}
+	if reserved {
+		return ErrEmailUsed
+	}
 	return nil
 }
 
@@ -265,6 +329,7 @@ func scanAccounts(row *sql.Rows) (*types.Account, error) {
 		&account.TotpSecret,
 		&account.Updated,
 		&account.Created,
+		&account.Locale,
 	)
 	if err != nil {
 		log.Error("failed to database scan", "err", err)
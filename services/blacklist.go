@@ -1,20 +1,36 @@
 package services
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"encoding/json"
 	"time"
 
+	"github.com/0xalby/based/cache"
+	"github.com/0xalby/based/database"
 	"github.com/charmbracelet/log"
 )
 
+// Channel revocations are broadcasted on
+const revocationsChannel = "based_revocations"
+
 type BlacklistService struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Cache    cache.Revocations // Optional cache consulted before the database
+	Notifier database.Notifier // Optional broadcaster keeping other instances' caches up to date
+}
+
+// A revocation broadcasted to other instances
+type revocation struct {
+	Token      string `json:"token,omitempty"`
+	Expiration int64  `json:"expiration,omitempty"`
+	Account    int    `json:"account,omitempty"`
+	Revoked    int64  `json:"revoked,omitempty"` // Microseconds
 }
 
 // Revokes jwt tokens
-func (service *BlacklistService) RevokeToken(tokenID string, id int, expiration time.Time) error {
-	rows, err := service.DB.Exec("INSERT INTO blacklist (token, account, expiration) VALUES (?, ?, ?)", tokenID, id, expiration)
+func (service *BlacklistService) RevokeToken(ctx context.Context, tokenID string, id int, expiration time.Time) error {
+	rows, err := service.DB.ExecContext(ctx, "INSERT INTO blacklist (token, account, expiration) VALUES (?, ?, ?)", tokenID, id, expiration)
 	if err != nil {
 		log.Error("failed to database insert", "err", err)
 		return err
@@ -27,21 +43,241 @@ func (service *BlacklistService) RevokeToken(tokenID string, id int, expiration
 	}
 	if affected == 0 {
 		log.Error("failed to revoke jwt token")
-		return fmt.Errorf("no rows affected")
+		return ErrNoRowsAffected
+	}
+	// Updating the cache, the database stays the source of truth
+	if service.Cache != nil {
+		if err := service.Cache.Revoke(ctx, tokenID, expiration); err != nil {
+			log.Error("failed to cache revoked token", "err", err)
+		}
+	}
+	service.broadcast(ctx, revocation{Token: tokenID, Expiration: expiration.Unix()})
+	return nil
+}
+
+// Revokes every jwt token issued to an account until now
+func (service *BlacklistService) RevokeAccount(ctx context.Context, account int) error {
+	// Tokens carry their issue time in microseconds, as precise as Postgres timestamps
+	revoked := time.Now().Truncate(time.Microsecond)
+	rows, err := service.DB.ExecContext(ctx,
+		"INSERT INTO invalidations (account, revoked) VALUES (?, ?) ON CONFLICT (account) DO UPDATE SET revoked = excluded.revoked",
+		account, revoked)
+	if err != nil {
+		log.Error("failed to database insert", "err", err)
+		return err
+	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to revoke account tokens")
+		return ErrNoRowsAffected
+	}
+	if service.Cache != nil {
+		if err := service.Cache.RevokeAccount(ctx, account, revoked); err != nil {
+			log.Error("failed to cache account revocation", "err", err)
+		}
 	}
-	return err
+	service.broadcast(ctx, revocation{Account: account, Revoked: revoked.UnixMicro()})
+	return nil
+}
+
+// Finds since when an account's tokens are revoked, zero if they aren't
+func (service *BlacklistService) FindAccountRevocation(ctx context.Context, account int) (time.Time, error) {
+	if service.Cache != nil {
+		revoked, known, err := service.Cache.LookupAccount(ctx, account)
+		if err != nil {
+			log.Error("failed to lookup revocation cache", "err", err)
+		} else if known {
+			return revoked, nil
+		}
+	}
+	var revoked time.Time
+	err := service.DB.QueryRowContext(ctx, "SELECT revoked FROM invalidations WHERE account = ?", account).Scan(&revoked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		log.Error("failed to database select", "err", err)
+		return time.Time{}, err
+	}
+	return revoked, nil
 }
 
 // Tries to find a blacklisted token
-func (service *BlacklistService) FindToken(tokenID string) (bool, error) {
+func (service *BlacklistService) FindToken(ctx context.Context, tokenID string) (bool, error) {
+	// Asking the cache first and falling back to the database if it doesn't know or fails
+	if service.Cache != nil {
+		revoked, known, err := service.Cache.Lookup(ctx, tokenID)
+		if err != nil {
+			log.Error("failed to lookup revocation cache", "err", err)
+		} else if known {
+			return revoked, nil
+		}
+	}
+	// Letting the cache tell if a revocation raced the query
+	asked := time.Now()
 	var exists bool
-	err := service.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM blacklist WHERE token = ?)", tokenID).Scan(&exists)
+	err := service.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM blacklist WHERE token = ?)", tokenID).Scan(&exists)
 	if err != nil {
 		log.Error("failed to scan")
 		return false, err
 	}
 	if !exists {
+		if service.Cache != nil {
+			if err := service.Cache.Miss(ctx, tokenID, asked); err != nil {
+				log.Error("failed to cache token", "err", err)
+			}
+		}
 		return false, nil
 	}
 	return true, nil
 }
+
+// Tells whether a token was revoked either by itself or along with every token of its account
+func (service *BlacklistService) IsRevoked(ctx context.Context, tokenID string, account int, issued time.Time) (bool, error) {
+	exists, err := service.FindToken(ctx, tokenID)
+	if err != nil || exists {
+		return exists, err
+	}
+	revoked, err := service.FindAccountRevocation(ctx, account)
+	if err != nil {
+		return false, err
+	}
+	// Only tokens issued after the revocation are still valid, even within the same second
+	return !revoked.IsZero() && !issued.After(revoked), nil
+}
+
+// Loads still valid revoked tokens into the cache
+func (service *BlacklistService) RefreshCache(ctx context.Context) error {
+	return service.Cache.Load(ctx, service.loadRevocations)
+}
+
+// Loads still valid revoked tokens and account-wide invalidations
+func (service *BlacklistService) loadRevocations(ctx context.Context) (map[string]time.Time, map[int]time.Time, error) {
+	rows, err := service.DB.QueryContext(ctx, "SELECT token, expiration FROM blacklist WHERE expiration > ?", time.Now())
+	if err != nil {
+		log.Error("failed to database query", "err", err)
+		return nil, nil, err
+	}
+	defer rows.Close()
+	tokens := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			tokenID    string
+			expiration time.Time
+		)
+		if err := rows.Scan(&tokenID, &expiration); err != nil {
+			log.Error("failed to database scan", "err", err)
+			return nil, nil, err
+		}
+		tokens[tokenID] = expiration
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("failed iterating rows", "err", err)
+		return nil, nil, err
+	}
+	accounts, err := service.loadInvalidations(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, accounts, nil
+}
+
+// Loads account-wide invalidations
+func (service *BlacklistService) loadInvalidations(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := service.DB.QueryContext(ctx, "SELECT account, revoked FROM invalidations")
+	if err != nil {
+		log.Error("failed to database query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	accounts := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			account int
+			revoked time.Time
+		)
+		if err := rows.Scan(&account, &revoked); err != nil {
+			log.Error("failed to database scan", "err", err)
+			return nil, err
+		}
+		accounts[account] = revoked
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("failed iterating rows", "err", err)
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// Refreshes the cache right away and then periodically until the context is done
+func (service *BlacklistService) WatchCache(ctx context.Context, interval time.Duration) {
+	if service.Cache == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := service.RefreshCache(ctx); err != nil {
+				log.Error("failed to refresh revocation cache", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Keeps the cache up to date with revocations broadcasted by other instances
+func (service *BlacklistService) ListenRevocations(ctx context.Context) error {
+	if service.Notifier == nil || service.Cache == nil {
+		return nil
+	}
+	return service.Notifier.Listen(ctx, revocationsChannel, func(payload string) {
+		// Reloading everything if notifications might have been missed
+		if payload == "" {
+			if err := service.RefreshCache(ctx); err != nil {
+				log.Error("failed to refresh revocation cache", "err", err)
+			}
+			return
+		}
+		var message revocation
+		if err := json.Unmarshal([]byte(payload), &message); err != nil {
+			log.Error("failed to unmarshal revocation", "err", err)
+			return
+		}
+		var err error
+		if message.Token != "" {
+			err = service.Cache.Revoke(ctx, message.Token, time.Unix(message.Expiration, 0))
+		}
+		if message.Account != 0 {
+			err = service.Cache.RevokeAccount(ctx, message.Account, time.UnixMicro(message.Revoked))
+		}
+		if err != nil {
+			log.Error("failed to cache broadcasted revocation", "err", err)
+		}
+	})
+}
+
+// Broadcasts a revocation to other instances
+func (service *BlacklistService) broadcast(ctx context.Context, message revocation) {
+	if service.Notifier == nil {
+		return
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Error("failed to marshal revocation", "err", err)
+		return
+	}
+	// Other instances catch up on their next refresh if this fails
+	if err := service.Notifier.Notify(ctx, revocationsChannel, string(payload)); err != nil {
+		log.Error("failed to broadcast revocation", "err", err)
+	}
+}
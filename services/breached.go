@@ -0,0 +1,60 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// Breached passwords as k-anonymity ranges like the ones served by the Pwned Passwords api, one file per
+// uppercase five characters SHA-1 prefix(example "21BD1.txt") holding "<35 characters suffix>:<count>" lines
+type BreachedRanges struct {
+	FS       fs.FS // Directory of range files, missing ones count as empty
+	MinCount int   // Times a password has to appear in breaches to be refused
+}
+
+func (ranges *BreachedRanges) Contains(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+	file, err := ranges.FS.Open(prefix + ".txt")
+	if errors.Is(err, fs.ErrNotExist) {
+		file, err = ranges.FS.Open(prefix)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		log.Error("failed to open breached passwords range", "err", err)
+		return false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		line, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(line, suffix) {
+			continue
+		}
+		// Padding entries have a zero count
+		times, err := strconv.Atoi(count)
+		if err != nil {
+			times = 1
+		}
+		return times > 0 && times >= ranges.MinCount, nil
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error("failed to read breached passwords range", "err", err)
+		return false, err
+	}
+	return false, nil
+}
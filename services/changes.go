@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/0xalby/based/types"
+	"github.com/charmbracelet/log"
+)
+
+// Email change statuses
+const (
+	ChangePending   = "pending"
+	ChangeConfirmed = "confirmed"
+	ChangeCancelled = "cancelled"
+	ChangeReverted  = "reverted"
+)
+
+// Tracks email changes so the old address can cancel them or take the account back
+type ChangesService struct {
+	DB       *sql.DB
+	Validity time.Duration // How long the old address can cancel or revert a change
+}
+
+// Records a requested email change returning the token for the old address' cancel link
+func (service *ChangesService) RequestEmailChange(ctx context.Context, account int, old, new string) (string, time.Time, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiration := time.Now().Add(service.Validity)
+	// Only one change at a time, older pending requests are superseded
+	if _, err := service.DB.ExecContext(ctx, "UPDATE changes SET status = ? WHERE account = ? AND status = ?",
+		ChangeCancelled, account, ChangePending); err != nil {
+		log.Error("failed to database update", "err", err)
+		return "", time.Time{}, err
+	}
+	// Along with the codes confirming them
+	if _, err := service.DB.ExecContext(ctx, "DELETE FROM codes WHERE account = ? AND pending <> ''", account); err != nil {
+		log.Error("failed to delete confirmation codes", "err", err)
+		return "", time.Time{}, err
+	}
+	rows, err := service.DB.ExecContext(ctx,
+		"INSERT INTO changes (account, old, new, token, status, expiration) VALUES (?, ?, ?, ?, ?, ?)",
+		account, old, new, hashToken(token), ChangePending, expiration)
+	if err != nil {
+		log.Error("failed to database insert", "err", err)
+		return "", time.Time{}, err
+	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return "", time.Time{}, err
+	}
+	if affected == 0 {
+		log.Error("failed to add email change")
+		return "", time.Time{}, ErrNoRowsAffected
+	}
+	return token, expiration, nil
+}
+
+// Marks the pending change to an address as confirmed returning the token for the old address' revert link,
+// the cancel link keeps working as a revert one too until the change expires
+func (service *ChangesService) ConfirmEmailChange(ctx context.Context, account int, new string) (*types.EmailChange, string, error) {
+	change, err := service.scan(service.DB.QueryRowContext(ctx,
+		"SELECT id, account, old, new, status, expiration, created FROM changes WHERE account = ? AND new = ? AND status = ? ORDER BY id DESC LIMIT 1",
+		account, new, ChangePending))
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	// Counting the revert window from the confirmation
+	change.Expiration = time.Now().Add(service.Validity)
+	if _, err := service.DB.ExecContext(ctx, "UPDATE changes SET status = ?, revert = ?, expiration = ? WHERE id = ?",
+		ChangeConfirmed, hashToken(token), change.Expiration, change.ID); err != nil {
+		log.Error("failed to database update", "err", err)
+		return nil, "", err
+	}
+	change.Status = ChangeConfirmed
+	return change, token, nil
+}
+
+// Cancels a pending change or reverts a confirmed one by the token sent to the old address
+func (service *ChangesService) CancelEmailChange(ctx context.Context, token string) (*types.EmailChange, error) {
+	change, err := service.scan(service.DB.QueryRowContext(ctx,
+		"SELECT id, account, old, new, status, expiration, created FROM changes WHERE (token = ? OR revert = ?) AND status IN (?, ?)",
+		hashToken(token), hashToken(token), ChangePending, ChangeConfirmed))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(change.Expiration) {
+		return nil, ErrEmailChangeNotFound
+	}
+	status := ChangeCancelled
+	if change.Status == ChangeConfirmed {
+		status = ChangeReverted
+	}
+	// Claiming the change so the link works once
+	rows, err := service.DB.ExecContext(ctx, "UPDATE changes SET status = ? WHERE id = ? AND status = ?", status, change.ID, change.Status)
+	if err != nil {
+		log.Error("failed to database update", "err", err)
+		return nil, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrEmailChangeNotFound
+	}
+	// Later changes made by whoever took the account over are void as well
+	if _, err := service.DB.ExecContext(ctx, "UPDATE changes SET status = ? WHERE account = ? AND id > ? AND status IN (?, ?)",
+		ChangeCancelled, change.Account, change.ID, ChangePending, ChangeConfirmed); err != nil {
+		log.Error("failed to database update", "err", err)
+		return nil, err
+	}
+	change.Status = status
+	return change, nil
+}
+
+func (service *ChangesService) scan(row *sql.Row) (*types.EmailChange, error) {
+	var change types.EmailChange
+	err := row.Scan(&change.ID, &change.Account, &change.Old, &change.New, &change.Status, &change.Expiration, &change.Created)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEmailChangeNotFound
+		}
+		log.Error("failed to database select", "err", err)
+		return nil, err
+	}
+	return &change, nil
+}
+
+// Generates a random token for links
+func generateToken() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		log.Error("failed to generate a token", "err", err)
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// Tokens are stored hashed since they grant control over an account
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xalby/based/utils"
+	"github.com/charmbracelet/log"
+	"golang.org/x/net/idna"
+)
+
+// Disposable domains bundled with the executable
+//
+//go:embed disposable.txt
+var bundledDisposable string
+
+// Looks up the mail servers of a domain and its addresses as the implicit one, satisfied by *net.Resolver
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Decides which email domains accounts can use, domains match themselves and their subdomains
+type DomainService struct {
+	Allow      []string   // Only these domains are accepted if any are set
+	Deny       []string   // These domains are refused
+	Disposable bool       // Refuses the disposable domains
+	File       string     // Disposable domains replacing the bundled ones, one per line, re-read when it changes
+	Resolver   MXResolver // Refuses domains without mail servers if set
+	mu         sync.RWMutex
+	disposable map[string]bool
+	modified   time.Time
+}
+
+// Fails with ErrEmailDomainNotAllowed, ErrEmailDisposable or ErrEmailDomainUnreachable if the address can't be used
+func (service *DomainService) Check(ctx context.Context, email string) error {
+	email, err := utils.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if len(service.Allow) > 0 && !matchDomain(domain, service.Allow) {
+		return ErrEmailDomainNotAllowed
+	}
+	if matchDomain(domain, service.Deny) {
+		return ErrEmailDomainNotAllowed
+	}
+	if service.Disposable && service.isDisposable(domain) {
+		return ErrEmailDisposable
+	}
+	if service.Resolver != nil {
+		return service.checkMX(ctx, domain)
+	}
+	return nil
+}
+
+// Reports if a domain or one of its parents is disposable
+func (service *DomainService) isDisposable(domain string) bool {
+	service.mu.RLock()
+	defer service.mu.RUnlock()
+	for {
+		if service.disposable[domain] {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return false
+		}
+		domain = parent
+	}
+}
+
+// Refuses domains without mail servers or addresses and the ones declaring they don't accept emails with a null MX
+// record, lookups failing otherwise let the address through so a flaky resolver doesn't stop registrations
+func (service *DomainService) checkMX(ctx context.Context, domain string) error {
+	records, err := service.Resolver.LookupMX(ctx, domain)
+	if isNotFound(err) {
+		// Mail goes to the domain itself without MX records
+		_, err = service.Resolver.LookupHost(ctx, domain)
+		if isNotFound(err) {
+			return ErrEmailDomainUnreachable
+		}
+	}
+	if err != nil {
+		log.Warn("failed to look up mx records", "domain", domain, "err", err)
+		return nil
+	}
+	if len(records) == 1 && records[0].Host == "." {
+		return ErrEmailDomainUnreachable
+	}
+	return nil
+}
+
+// Loads the disposable domains from the file if set or the bundled list, skipping the file if it didn't change
+func (service *DomainService) LoadDisposable() error {
+	var reader io.Reader = strings.NewReader(bundledDisposable)
+	var modified time.Time
+	if service.File != "" {
+		info, err := os.Stat(service.File)
+		if err != nil {
+			return err
+		}
+		service.mu.RLock()
+		unchanged := info.ModTime().Equal(service.modified)
+		service.mu.RUnlock()
+		if unchanged {
+			return nil
+		}
+		file, err := os.Open(service.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader, modified = file, info.ModTime()
+	}
+	domains := map[string]bool{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domain, err := idna.Lookup.ToASCII(line)
+		if err != nil {
+			continue
+		}
+		domains[strings.ToLower(domain)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	service.mu.Lock()
+	service.disposable, service.modified = domains, modified
+	service.mu.Unlock()
+	return nil
+}
+
+// Re-reads the disposable domains file in the background whenever it changes
+func (service *DomainService) WatchDisposable(ctx context.Context, interval time.Duration) {
+	if !service.Disposable || service.File == "" || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := service.LoadDisposable(); err != nil {
+				log.Error("failed to reload disposable domains", "err", err)
+			}
+		}
+	}()
+}
+
+// Reports if a lookup failed because there is no such record
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// Reports if a domain is one of the listed ones or a subdomain of one
+func matchDomain(domain string, list []string) bool {
+	for _, entry := range list {
+		if domain == entry || strings.HasSuffix(domain, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,125 +2,352 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
-	"embed"
 	"errors"
-	"fmt"
 	"html/template"
-	"os"
-	"strconv"
+	"io/fs"
+	"net/mail"
+	"net/url"
+	"path"
+	"strings"
+	texttemplate "text/template"
 	"time"
 
+	"github.com/0xalby/based/locale"
+	"github.com/0xalby/based/mailer"
 	"github.com/charmbracelet/log"
-	"gopkg.in/gomail.v2"
 )
 
 // ATTENTION in this file for slightly better structuring I declared relevant structs below the functions
 
 type EmailService struct {
-	FS embed.FS
-	DB *sql.DB
+	FS     fs.FS // Templates directory holding the layout, partials and emails
+	DB     *sql.DB
+	Mailer mailer.Mailer // Nil disables sending emails
+	From   string        // Sender address
+	Brand  Brand
+	// Unsubscribe links added to notification emails(mailto or https urls), omitted if empty
+	Unsubscribe []string
+	// Page cancelling email changes with the token appended as a query parameter, only the token is sent if empty
+	CancelURL string
+	// Page unlocking accounts locked after too many failed logins, works like CancelURL
+	UnlockURL string
+	// Registration page invitations link to, works like CancelURL
+	InvitationURL string
+	// Page logging out every session from the new sign-in emails, works like CancelURL
+	SecureURL string
 }
 
-// Sends emails based on template and data
-func (service *EmailService) SendEmail(email, subject, path string, data interface{}) error {
-	// Creating an smtp server
-	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
-	if err != nil {
-		log.Error("failed to convert SMTP_PORT", "err", err)
-		return err
+// Branding shown in every email
+type Brand struct {
+	Name    string // Product name
+	Logo    string // Logo url, omitted if empty
+	Website string // Website url
+	Support string // Support email address
+	Help    string // Help center url
+}
+
+// Files every html email is rendered with
+var layout = []string{"layout.html", "partials/header.html", "partials/footer.html"}
+
+// Reports whether emails can be sent
+func (service *EmailService) Enabled() bool {
+	return service.Mailer != nil
+}
+
+// Sends emails based on template and data in the context's locale, name is the template without extension
+func (service *EmailService) SendEmail(ctx context.Context, email, subject, name string, data interface{}) error {
+	return service.send(ctx, email, subject, name, data, nil)
+}
+
+func (service *EmailService) send(ctx context.Context, email, subject, name string, data interface{}, headers map[string]string) error {
+	if !service.Enabled() {
+		return ErrMailerDisabled
 	}
-	server := &smtpServer{
-		Email:    os.Getenv("SMTP_EMAIL"),
-		Address:  os.Getenv("SMTP_ADDRESS"),
-		Port:     port,
-		User:     os.Getenv("SMTP_USER"),
-		Password: os.Getenv("SMTP_PASSWORD"),
+	lang := locale.FromContext(ctx)
+	funcs := template.FuncMap{
+		"t":      func(message string) string { return locale.Translate(lang, message) },
+		"locale": func() string { return lang },
 	}
-	// Parsing the template from embedded filesystem
-	t, err := template.ParseFS(service.FS, path)
+	// Parsing the template within the layout preferring localized variants
+	files := make([]string, 0, len(layout)+1)
+	for _, file := range append(layout, name+".html") {
+		files = append(files, service.localized(lang, file))
+	}
+	t, err := template.New("layout.html").Funcs(funcs).ParseFS(service.FS, files...)
 	if err != nil {
 		log.Error("failed to parse email template", "err", err)
 		return err
 	}
 	// Executing the template
 	var body bytes.Buffer
-	if err := t.Execute(&body, data); err != nil {
+	if err := t.ExecuteTemplate(&body, "layout.html", data); err != nil {
 		log.Error("failed to execute template", "err", err)
 		return err
 	}
+	text, err := service.plainText(path.Dir(files[len(files)-1]), name, data, body.String())
+	if err != nil {
+		return err
+	}
+	// Identifying the email once so retries from the outbox don't look like new ones
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["Message-ID"] = mailer.MessageID(service.domain())
+	headers["Date"] = time.Now().Format(time.RFC1123Z)
 	// Sending the email
-	message := gomail.NewMessage()
-	message.SetHeader("From", server.Email)
-	message.SetHeader("To", email)
-	message.SetHeader("Subject", subject)
-	message.SetBody("text/html", body.String())
-	dialer := gomail.NewDialer(server.Address, server.Port, server.User, server.Password)
-	if err := dialer.DialAndSend(message); err != nil {
+	message := &mailer.Message{
+		From:    service.From,
+		To:      email,
+		Subject: locale.Translate(lang, subject),
+		HTML:    body.String(),
+		Text:    text,
+		Headers: headers,
+	}
+	if err := service.Mailer.Send(ctx, message); err != nil {
 		log.Error("failed to send email", "err", err)
 		return err
 	}
 	return nil
 }
 
-type smtpServer struct {
-	Email    string
-	Address  string
-	Port     int
-	User     string
-	Password string
+// Gets the domain of the sender address
+func (service *EmailService) domain() string {
+	if address, err := mail.ParseAddress(service.From); err == nil {
+		if _, domain, found := strings.Cut(address.Address, "@"); found {
+			return domain
+		}
+	}
+	return "localhost"
+}
+
+// Gets the path of a template's variant for a locale falling back to the default one
+func (service *EmailService) localized(lang, file string) string {
+	if lang == locale.Default {
+		return file
+	}
+	variant := path.Join(lang, file)
+	if _, err := fs.Stat(service.FS, variant); err != nil {
+		return file
+	}
+	return variant
+}
+
+// Renders the hand written plain text version of an email or generates it from the html one
+func (service *EmailService) plainText(dir, name string, data interface{}, html string) (string, error) {
+	// Only a text version next to the html one is in the same language
+	file := path.Join(dir, name+".txt")
+	if _, err := fs.Stat(service.FS, file); errors.Is(err, fs.ErrNotExist) {
+		return mailer.PlainText(html), nil
+	}
+	t, err := texttemplate.ParseFS(service.FS, file)
+	if err != nil {
+		log.Error("failed to parse email template", "err", err)
+		return "", err
+	}
+	var text strings.Builder
+	if err := t.Execute(&text, data); err != nil {
+		log.Error("failed to execute template", "err", err)
+		return "", err
+	}
+	return text.String(), nil
 }
 
 // Sends a verification email
-func (service *EmailService) SendVerificationEmail(email, code string) error {
+func (service *EmailService) SendVerificationEmail(ctx context.Context, email, code string) error {
 	data := verification{
 		Recipient: email,
 		Code:      code,
+		Brand:     service.Brand,
 	}
-	return service.SendEmail(email, "Email verification or account changes", "templates/verification.html", data)
+	return service.SendEmail(ctx, email, "Email verification or account changes", "verification", data)
 }
 
 type verification struct {
 	Recipient string
 	Code      string
+	Brand     Brand
 }
 
 // Sends an account recovery email
-func (service *EmailService) SendRecoveryEmail(email, code string) error {
+func (service *EmailService) SendRecoveryEmail(ctx context.Context, email, code string) error {
 	data := recovery{
 		Recipient: email,
 		Code:      code,
+		Brand:     service.Brand,
 	}
-	return service.SendEmail(email, "Account Recovery", "templates/recovery.html", data)
+	return service.SendEmail(ctx, email, "Account Recovery", "recovery", data)
 }
 
 type recovery struct {
 	Recipient string
 	Code      string
+	Brand     Brand
 }
 
 // Sends a notification email
-func (service *EmailService) SendNotificationEmail(email, subject, message string) error {
+func (service *EmailService) SendNotificationEmail(ctx context.Context, email, subject, message string) error {
+	data := notification{
+		Recipient: email,
+		Message:   locale.T(ctx, message),
+		Brand:     service.Brand,
+	}
+	return service.notify(ctx, email, subject, data)
+}
+
+// Tells the owner about a sign-in from a new device or location with a link logging out every session
+func (service *EmailService) SendSignInEmail(ctx context.Context, email, token, network, agent string, when time.Time) error {
+	link, err := tokenLink(service.SecureURL, token)
+	if err != nil {
+		return err
+	}
 	data := notification{
 		Recipient: email,
-		Message:   message,
+		Message:   locale.T(ctx, "Your account was signed into from a new device or location, if it wasn't you secure your account to log out everywhere and change your password"),
+		Details:   []string{agent, network, when.Format("2006-01-02 15:04 MST")},
+		Action:    locale.T(ctx, "Secure my account"),
+		Link:      link,
+		Token:     token,
+		Brand:     service.Brand,
+	}
+	return service.notify(ctx, email, "New sign-in", data)
+}
+
+func (service *EmailService) notify(ctx context.Context, email, subject string, data notification) error {
+	var headers map[string]string
+	if len(service.Unsubscribe) > 0 {
+		links := make([]string, len(service.Unsubscribe))
+		oneClick := false
+		for i, link := range service.Unsubscribe {
+			links[i] = "<" + link + ">"
+			oneClick = oneClick || strings.HasPrefix(link, "https://")
+		}
+		headers = map[string]string{"List-Unsubscribe": strings.Join(links, ", ")}
+		// One click unsubscribing as per RFC 8058 requires an https link
+		if oneClick {
+			headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+		}
 	}
-	return service.SendEmail(email, subject, "templates/notification.html", data)
+	return service.send(ctx, email, subject, "notification", data, headers)
 }
 
 type notification struct {
 	Recipient string
 	Message   string
+	Details   []string // Listed below the message, omitted if empty
+	Action    string   // Text of the link, omitted without a link
+	Link      string
+	Token     string // Shown below the link so it can be used without one, omitted if empty
+	Brand     Brand
+}
+
+// Tells the old address about an email change letting it cancel or revert it
+func (service *EmailService) SendEmailChangeEmail(ctx context.Context, email, new, token string, confirmed bool, expiration time.Time) error {
+	data := change{
+		Recipient:  email,
+		New:        new,
+		Token:      token,
+		Confirmed:  confirmed,
+		Expiration: expiration,
+		Brand:      service.Brand,
+	}
+	link, err := tokenLink(service.CancelURL, token)
+	if err != nil {
+		return err
+	}
+	data.Link = link
+	subject := "Email address change requested"
+	if confirmed {
+		subject = "Email address changed"
+	}
+	return service.SendEmail(ctx, email, subject, "change", data)
+}
+
+type change struct {
+	Recipient  string
+	New        string
+	Token      string
+	Link       string
+	Confirmed  bool
+	Expiration time.Time
+	Brand      Brand
+}
+
+// Tells the owner of a locked account how to unlock it
+func (service *EmailService) SendUnlockEmail(ctx context.Context, email, token string, until time.Time) error {
+	link, err := tokenLink(service.UnlockURL, token)
+	if err != nil {
+		return err
+	}
+	data := unlock{
+		Recipient: email,
+		Token:     token,
+		Link:      link,
+		Until:     until,
+		Brand:     service.Brand,
+	}
+	return service.SendEmail(ctx, email, "Account locked", "unlock", data)
+}
+
+type unlock struct {
+	Recipient string
+	Token     string
+	Link      string
+	Until     time.Time
+	Brand     Brand
+}
+
+// Invites an address to register, the inviter is empty for admins
+func (service *EmailService) SendInvitationEmail(ctx context.Context, email, token, inviter string, expiration time.Time) error {
+	link, err := tokenLink(service.InvitationURL, token)
+	if err != nil {
+		return err
+	}
+	data := invitation{
+		Recipient:  email,
+		Inviter:    inviter,
+		Token:      token,
+		Link:       link,
+		Expiration: expiration,
+		Brand:      service.Brand,
+	}
+	return service.SendEmail(ctx, email, "You're invited", "invitation", data)
+}
+
+type invitation struct {
+	Recipient  string
+	Inviter    string
+	Token      string
+	Link       string
+	Expiration time.Time
+	Brand      Brand
+}
+
+// Appends a token to a page url as a query parameter, empty if the page isn't set
+func tokenLink(page, token string) (string, error) {
+	if page == "" {
+		return "", nil
+	}
+	link, err := url.Parse(page)
+	if err != nil {
+		log.Error("failed to parse link url", "err", err)
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
 }
 
 // Gets an account by code ownership
-func (service *EmailService) GetAccountIDByCodeOwnership(code string) (int, error) {
+func (service *EmailService) GetAccountIDByCodeOwnership(ctx context.Context, code string) (int, error) {
 	var account int
-	err := service.DB.QueryRow("SELECT account FROM codes WHERE code = ? OR recovery = ?", code, code).
+	err := service.DB.QueryRowContext(ctx, "SELECT account FROM codes WHERE code = ? OR recovery = ?", code, code).
 		Scan(&account)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return 0, errors.New("invalid or expired code")
+			return 0, ErrInvalidCode
 		}
 		log.Error("failed to query database", "err", err)
 		return 0, err
@@ -129,10 +356,10 @@ func (service *EmailService) GetAccountIDByCodeOwnership(code string) (int, erro
 }
 
 // Adds the verification code to the database
-func (service *EmailService) AddVerificationCode(code string, account int) error {
+func (service *EmailService) AddVerificationCode(ctx context.Context, code string, account int) error {
 	// Executing on the database
 	expiration := time.Now().Add(15 * time.Minute) // expires in 15 minutes
-	rows, err := service.DB.Exec("INSERT INTO codes (code, expiration, account) VALUES (?,?,?)", code, expiration, account)
+	rows, err := service.DB.ExecContext(ctx, "INSERT INTO codes (code, expiration, account) VALUES (?,?,?)", code, expiration, account)
 	if err != nil {
 		log.Error("failed to database insert", "err", err)
 		return err
@@ -145,30 +372,53 @@ func (service *EmailService) AddVerificationCode(code string, account int) error
 	}
 	if affected == 0 {
 		log.Error("failed to add verification code")
-		return fmt.Errorf("no rows affected")
+		return ErrNoRowsAffected
 	}
 	return nil
 }
 
-// Compares the stored and the inputted verification codes
-func (service *EmailService) CompareCodes(code string, account int) error {
+// Adds a code confirming an email change to the database, tied to the new address so cancelling the change only deletes it
+func (service *EmailService) AddConfirmationCode(ctx context.Context, code string, account int, pending string) error {
+	expiration := time.Now().Add(15 * time.Minute) // expires in 15 minutes
+	rows, err := service.DB.ExecContext(ctx, "INSERT INTO codes (code, pending, expiration, account) VALUES (?,?,?,?)", code, pending, expiration, account)
+	if err != nil {
+		log.Error("failed to database insert", "err", err)
+		return err
+	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to add confirmation code")
+		return ErrNoRowsAffected
+	}
+	return nil
+}
+
+// Compares the stored and the inputted verification codes, confirmation ones only match the address they were sent to
+// while verification ones have no pending address
+func (service *EmailService) CompareCodes(ctx context.Context, code string, account int, pending string) error {
 	var (
 		storedCode string
 		expiration time.Time
 	)
-	err := service.DB.QueryRow("SELECT code, expiration FROM codes WHERE code = ? AND account = ?", code, account).
+	err := service.DB.QueryRowContext(ctx, "SELECT code, expiration FROM codes WHERE code = ? AND account = ? AND pending = ?", code, account, pending).
 		Scan(&storedCode, &expiration)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return fmt.Errorf("invalid verification or confirmation code")
+			return ErrInvalidCode
 		}
 		log.Error("failed to database select", "err", err)
 		return err
 	}
 	if time.Now().After(expiration) {
-		return fmt.Errorf("verification or confirmation code has expired")
+		return ErrExpiredCode
 	}
-	_, err = service.DB.Exec("DELETE FROM codes WHERE account = ?", account)
+	// Deleting the codes sent for the same purpose, recovery ones are left alone
+	_, err = service.DB.ExecContext(ctx, "DELETE FROM codes WHERE account = ? AND code <> '' AND pending = ?", account, pending)
 	if err != nil {
 		log.Error("failed to delete used codes", "err", err)
 		return err
@@ -177,10 +427,10 @@ func (service *EmailService) CompareCodes(code string, account int) error {
 }
 
 // Adds the recovery code to the database
-func (service *EmailService) AddRecoveryCode(code string, account int) error {
+func (service *EmailService) AddRecoveryCode(ctx context.Context, code string, account int) error {
 	// Executing on the database
 	expiration := time.Now().Add(15 * time.Minute) // expires in 15 minutes
-	rows, err := service.DB.Exec("INSERT INTO codes (recovery, expiration, account) VALUES (?,?,?)", code, expiration, account)
+	rows, err := service.DB.ExecContext(ctx, "INSERT INTO codes (recovery, expiration, account) VALUES (?,?,?)", code, expiration, account)
 	if err != nil {
 		log.Error("failed to database insert", "err", err)
 		return err
@@ -193,30 +443,30 @@ func (service *EmailService) AddRecoveryCode(code string, account int) error {
 	}
 	if affected == 0 {
 		log.Error("failed to add recovery code")
-		return fmt.Errorf("no rows affected")
+		return ErrNoRowsAffected
 	}
 	return nil
 }
 
 // Compares the stored and the inputted recovery codes
-func (service *EmailService) CompareRecoveryCodes(code string, account int) error {
+func (service *EmailService) CompareRecoveryCodes(ctx context.Context, code string, account int) error {
 	var (
 		storedCode string
 		expiration time.Time
 	)
-	err := service.DB.QueryRow("SELECT recovery, expiration FROM codes WHERE recovery = ? AND account = ?", code, account).
+	err := service.DB.QueryRowContext(ctx, "SELECT recovery, expiration FROM codes WHERE recovery = ? AND account = ?", code, account).
 		Scan(&storedCode, &expiration)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return fmt.Errorf("invalid recovery code")
+			return ErrInvalidRecoveryCode
 		}
 		log.Error("failed to database select", "err", err)
 		return err
 	}
 	if time.Now().After(expiration) {
-		return fmt.Errorf("recovery code has expired")
+		return ErrExpiredRecoveryCode
 	}
-	_, err = service.DB.Exec("DELETE FROM codes WHERE account = ?", account)
+	_, err = service.DB.ExecContext(ctx, "DELETE FROM codes WHERE account = ?", account)
 	if err != nil {
 		log.Error("failed to delete used codes", "err", err)
 		return err
@@ -224,9 +474,18 @@ func (service *EmailService) CompareRecoveryCodes(code string, account int) erro
 	return nil
 }
 
+// Deletes the codes confirming a change of the account email to an address
+func (service *EmailService) DeleteConfirmationCodes(ctx context.Context, account int, pending string) error {
+	if _, err := service.DB.ExecContext(ctx, "DELETE FROM codes WHERE account = ? AND pending = ?", account, pending); err != nil {
+		log.Error("failed to delete confirmation codes", "err", err)
+		return err
+	}
+	return nil
+}
+
 // Deletes the account codes
-func (service *EmailService) DeleteCodes(account int) error {
-	rows, err := service.DB.Exec("DELETE FROM codes WHERE account = ?", account)
+func (service *EmailService) DeleteCodes(ctx context.Context, account int) error {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM codes WHERE account = ?", account)
 	if err != nil {
 		log.Error("failed to delete codes", "err", err)
 		return err
@@ -237,7 +496,7 @@ func (service *EmailService) DeleteCodes(account int) error {
 		return err
 	}
 	if affected == 0 {
-		return fmt.Errorf("no rows affected")
+		return ErrNoRowsAffected
 	}
 	return nil
 }
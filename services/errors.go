@@ -0,0 +1,39 @@
+package services
+
+import "errors"
+
+// Errors returned by services, handlers map them to responses so check them with errors.Is
+var (
+	ErrNoRowsAffected         = errors.New("no rows affected")
+	ErrMailerDisabled         = errors.New("no mailer configured")
+	ErrAccountNotFound        = errors.New("account not found")
+	ErrEmailUsed              = errors.New("email already used")
+	ErrInvalidCode            = errors.New("invalid verification or confirmation code")
+	ErrExpiredCode            = errors.New("verification or confirmation code has expired")
+	ErrInvalidRecoveryCode    = errors.New("invalid recovery code")
+	ErrExpiredRecoveryCode    = errors.New("recovery code has expired")
+	ErrBackupCodeNotFound     = errors.New("code not found")
+	ErrInvalidBackupCode      = errors.New("invalid backup code")
+	ErrEmailNotFound          = errors.New("email not found")
+	ErrEmailChangeNotFound    = errors.New("email change not found")
+	ErrAccountLocked          = errors.New("account locked")
+	ErrLoginDelayed           = errors.New("login delayed")
+	ErrUnlockTokenNotFound    = errors.New("unlock token not found")
+	ErrAccountNotLocked       = errors.New("account not locked")
+	ErrPasswordTooShort       = errors.New("password too short")
+	ErrPasswordTooLong        = errors.New("password too long")
+	ErrPasswordTooWeak        = errors.New("password too weak")
+	ErrPasswordContainsEmail  = errors.New("password contains the email address")
+	ErrPasswordBreached       = errors.New("password appeared in a breach")
+	ErrPasswordReused         = errors.New("password used before")
+	ErrEmailDomainNotAllowed  = errors.New("email domain not allowed")
+	ErrEmailDisposable        = errors.New("disposable email address")
+	ErrEmailDomainUnreachable = errors.New("email domain can't receive emails")
+	ErrRegistrationClosed     = errors.New("registration closed")
+	ErrInvitationRequired     = errors.New("invitation required")
+	ErrInvalidInvitation      = errors.New("invalid or expired invitation")
+	ErrInvitationLimit        = errors.New("invitation limit reached")
+	ErrInvitationNotFound     = errors.New("invitation not found")
+	ErrInvalidSignInCode      = errors.New("invalid or expired sign-in code")
+	ErrSignInNotFound         = errors.New("sign-in not found")
+)
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/0xalby/based/types"
+	"github.com/0xalby/based/utils"
+	"github.com/charmbracelet/log"
+)
+
+// Remembers the hashes of the passwords accounts had before so they aren't used again
+type HistoryService struct {
+	DB   *sql.DB
+	Size int // Previous passwords remembered per account, the current one is always refused
+}
+
+// Fails with ErrPasswordReused if the password is the current one of the account or one of the remembered ones
+func (service *HistoryService) Check(ctx context.Context, account *types.Account, password string) error {
+	if utils.CompareHashedAndPlain(account.Password, password) {
+		return ErrPasswordReused
+	}
+	if service.Size <= 0 {
+		return nil
+	}
+	rows, err := service.DB.QueryContext(ctx, "SELECT hash FROM history WHERE account = ? ORDER BY id DESC LIMIT ?",
+		account.ID, service.Size)
+	if err != nil {
+		log.Error("failed to database select", "err", err)
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hashed string
+		if err := rows.Scan(&hashed); err != nil {
+			log.Error("failed to iterate over rows", "err", err)
+			return err
+		}
+		if utils.CompareHashedAndPlain(hashed, password) {
+			return ErrPasswordReused
+		}
+	}
+	return rows.Err()
+}
+
+// Remembers the hash of a replaced password forgetting the ones past the size of the history
+func (service *HistoryService) Record(ctx context.Context, account int, hashed string) error {
+	if service.Size <= 0 {
+		return nil
+	}
+	if _, err := service.DB.ExecContext(ctx, "INSERT INTO history (account, hash) VALUES (?, ?)", account, hashed); err != nil {
+		log.Error("failed to database insert", "err", err)
+		return err
+	}
+	if _, err := service.DB.ExecContext(ctx,
+		`DELETE FROM history WHERE account = ? AND id NOT IN (
+			SELECT id FROM history WHERE account = ? ORDER BY id DESC LIMIT ?
+		)`, account, account, service.Size); err != nil {
+		log.Error("failed to database delete", "err", err)
+		return err
+	}
+	return nil
+}
+
+// Forgets every remembered password of an account
+func (service *HistoryService) Clear(ctx context.Context, account int) error {
+	if _, err := service.DB.ExecContext(ctx, "DELETE FROM history WHERE account = ?", account); err != nil {
+		log.Error("failed to database delete", "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/0xalby/based/types"
+	"github.com/0xalby/based/utils"
+	"github.com/charmbracelet/log"
+)
+
+// Registration modes
+const (
+	RegistrationOpen   = "open"   // Anyone can register
+	RegistrationInvite = "invite" // Registering takes an invitation
+	RegistrationClosed = "closed" // Nobody can register
+)
+
+// Decides who can register and keeps the invitations admins and accounts create, tokens are random and stored hashed
+// rather than signed since revoking and counting uses needs a row anyway and a signature would only add a secret
+type InvitationsService struct {
+	DB         *sql.DB
+	Mode       string        // Either open, invite or closed
+	Validity   time.Duration // How long invitations last unless an admin sets otherwise
+	PerAccount int           // Usable invitations an account can have at once, zero stops accounts from inviting
+}
+
+// Lets a registration through depending on the mode using up the invitation if one is needed
+func (service *InvitationsService) Admit(ctx context.Context, token, email string) error {
+	switch service.Mode {
+	case RegistrationClosed:
+		return ErrRegistrationClosed
+	case RegistrationInvite:
+		if token == "" {
+			return ErrInvitationRequired
+		}
+		return service.redeem(ctx, token, email)
+	}
+	return nil
+}
+
+// Gives back the use of an invitation whose registration failed
+func (service *InvitationsService) Release(ctx context.Context, token string) {
+	if service.Mode != RegistrationInvite || token == "" {
+		return
+	}
+	if _, err := service.DB.ExecContext(ctx, "UPDATE invitations SET uses = uses - 1 WHERE token = ? AND uses > 0", hashToken(token)); err != nil {
+		log.Error("failed to database update", "err", err)
+	}
+}
+
+// Counts a registration with an invitation if it's still usable and bound to no address or the given one
+func (service *InvitationsService) redeem(ctx context.Context, token, email string) error {
+	email, err := utils.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	// Checking and counting in one statement so concurrent registrations can't go past the limit
+	rows, err := service.DB.ExecContext(ctx,
+		"UPDATE invitations SET uses = uses + 1 WHERE token = ? AND uses < max_uses AND expiration > ? AND (email = '' OR email = ?)",
+		hashToken(token), time.Now(), email)
+	if err != nil {
+		log.Error("failed to database update", "err", err)
+		return err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
+
+// Creates an invitation on behalf of an account, zero for an admin, returning its token, accounts are bound
+// to the default validity, a single use and their limit of usable invitations
+func (service *InvitationsService) Create(ctx context.Context, inviter int, email string, uses int, validity time.Duration) (string, *types.Invitation, error) {
+	if inviter != 0 {
+		if service.PerAccount <= 0 {
+			return "", nil, ErrInvitationLimit
+		}
+		var usable int
+		if err := service.DB.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM invitations WHERE inviter = ? AND expiration > ? AND uses < max_uses",
+			inviter, time.Now()).Scan(&usable); err != nil {
+			log.Error("failed to database select", "err", err)
+			return "", nil, err
+		}
+		if usable >= service.PerAccount {
+			return "", nil, ErrInvitationLimit
+		}
+		uses, validity = 1, 0
+	}
+	if uses <= 0 {
+		uses = 1
+	}
+	if validity <= 0 {
+		validity = service.Validity
+	}
+	if email != "" {
+		normalized, err := utils.NormalizeEmail(email)
+		if err != nil {
+			return "", nil, err
+		}
+		email = normalized
+	}
+	token, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+	invitation := &types.Invitation{
+		Inviter:    inviter,
+		Email:      email,
+		MaxUses:    uses,
+		Expiration: time.Now().Add(validity),
+		Created:    time.Now(),
+	}
+	var by any
+	if inviter != 0 {
+		by = inviter
+	}
+	rows, err := service.DB.ExecContext(ctx,
+		"INSERT INTO invitations (token, inviter, email, max_uses, expiration, created) VALUES (?, ?, ?, ?, ?, ?)",
+		hashToken(token), by, email, uses, invitation.Expiration, invitation.Created)
+	if err != nil {
+		log.Error("failed to database insert", "err", err)
+		return "", nil, err
+	}
+	id, err := rows.LastInsertId()
+	if err != nil {
+		// Postgres doesn't report the id, looking it up by the unique token instead
+		if err := service.DB.QueryRowContext(ctx, "SELECT id FROM invitations WHERE token = ?", hashToken(token)).Scan(&id); err != nil {
+			log.Error("failed to database select", "err", err)
+			return "", nil, err
+		}
+	}
+	invitation.ID = int(id)
+	return token, invitation, nil
+}
+
+// Lists the invitations of an account newest first, every invitation for zero
+func (service *InvitationsService) List(ctx context.Context, inviter int) ([]types.Invitation, error) {
+	rows, err := service.DB.QueryContext(ctx,
+		"SELECT id, inviter, email, uses, max_uses, expiration, created FROM invitations WHERE inviter = ? OR ? = 0 ORDER BY id DESC LIMIT 100",
+		inviter, inviter)
+	if err != nil {
+		log.Error("failed to database query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	invitations := []types.Invitation{}
+	for rows.Next() {
+		var (
+			invitation types.Invitation
+			by         sql.NullInt64
+		)
+		if err := rows.Scan(&invitation.ID, &by, &invitation.Email, &invitation.Uses, &invitation.MaxUses, &invitation.Expiration, &invitation.Created); err != nil {
+			log.Error("failed to database scan", "err", err)
+			return nil, err
+		}
+		invitation.Inviter = int(by.Int64)
+		invitations = append(invitations, invitation)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("failed iterating rows", "err", err)
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// Revokes an invitation of an account, any invitation for zero
+func (service *InvitationsService) Revoke(ctx context.Context, id, inviter int) error {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM invitations WHERE id = ? AND (inviter = ? OR ? = 0)", id, inviter, inviter)
+	if err != nil {
+		log.Error("failed to database delete", "err", err)
+		return err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xalby/based/types"
+	"github.com/charmbracelet/log"
+)
+
+type JanitorService struct {
+	DB *sql.DB
+	// Rows purged since startup
+	blacklist   atomic.Int64
+	codes       atomic.Int64
+	pending     atomic.Int64
+	outbox      atomic.Int64
+	changes     atomic.Int64
+	ratelimits  atomic.Int64
+	invitations atomic.Int64
+	signins     atomic.Int64
+}
+
+// Purges revoked tokens past their expiration since they can't be used anymore
+func (service *JanitorService) PurgeBlacklist(ctx context.Context) (int64, error) {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM blacklist WHERE expiration < ?", time.Now())
+	if err != nil {
+		log.Error("failed to purge blacklist", "err", err)
+		return 0, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return 0, err
+	}
+	service.blacklist.Add(affected)
+	return affected, nil
+}
+
+// Purges expired codes
+func (service *JanitorService) PurgeCodes(ctx context.Context) (int64, error) {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM codes WHERE expiration < ?", time.Now())
+	if err != nil {
+		log.Error("failed to purge codes", "err", err)
+		return 0, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return 0, err
+	}
+	service.codes.Add(affected)
+	return affected, nil
+}
+
+// Cleans pending emails which can't be confirmed anymore because no valid code is left
+func (service *JanitorService) PurgePending(ctx context.Context) (int64, error) {
+	rows, err := service.DB.ExecContext(ctx,
+		`UPDATE accounts SET pending = '' WHERE pending <> '' AND NOT EXISTS (
+			SELECT 1 FROM codes WHERE codes.account = accounts.id AND codes.code <> '' AND codes.expiration > ?
+		)`, time.Now())
+	if err != nil {
+		log.Error("failed to purge pending emails", "err", err)
+		return 0, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return 0, err
+	}
+	service.pending.Add(affected)
+	return affected, nil
+}
+
+// Purges sent outbox emails older than a week
+func (service *JanitorService) PurgeOutbox(ctx context.Context) (int64, error) {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM outbox WHERE status = 'sent' AND created < ?", time.Now().Add(-7*24*time.Hour))
+	if err != nil {
+		log.Error("failed to purge outbox", "err", err)
+		return 0, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return 0, err
+	}
+	service.outbox.Add(affected)
+	return affected, nil
+}
+
+// Purges email changes past the time the old address could cancel or revert them
+func (service *JanitorService) PurgeChanges(ctx context.Context) (int64, error) {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM changes WHERE expiration < ?", time.Now())
+	if err != nil {
+		log.Error("failed to purge email changes", "err", err)
+		return 0, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return 0, err
+	}
+	service.changes.Add(affected)
+	return affected, nil
+}
+
+// Purges rate limit windows past both the current and the previous one
+func (service *JanitorService) PurgeRateLimits(ctx context.Context) (int64, error) {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM ratelimits WHERE expiration < ?", time.Now().Unix())
+	if err != nil {
+		log.Error("failed to purge rate limits", "err", err)
+		return 0, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return 0, err
+	}
+	service.ratelimits.Add(affected)
+	return affected, nil
+}
+
+// Purges expired invitations, used up ones are kept until then so their uses can still be released and listed
+func (service *JanitorService) PurgeInvitations(ctx context.Context) (int64, error) {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM invitations WHERE expiration < ?", time.Now())
+	if err != nil {
+		log.Error("failed to purge invitations", "err", err)
+		return 0, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return 0, err
+	}
+	service.invitations.Add(affected)
+	return affected, nil
+}
+
+// Purges sign-ins from new contexts whose confirmation code expired without being used
+func (service *JanitorService) PurgeSignIns(ctx context.Context) (int64, error) {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM signins WHERE code <> '' AND expiration < ?", time.Now())
+	if err != nil {
+		log.Error("failed to purge sign-ins", "err", err)
+		return 0, err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return 0, err
+	}
+	service.signins.Add(affected)
+	return affected, nil
+}
+
+// Runs every purge once
+func (service *JanitorService) PurgeAll(ctx context.Context) (*types.Purged, error) {
+	var (
+		purged types.Purged
+		err    error
+	)
+	if purged.Blacklist, err = service.PurgeBlacklist(ctx); err != nil {
+		return nil, err
+	}
+	// Codes go first so pending emails left without one are cleaned in the same run
+	if purged.Codes, err = service.PurgeCodes(ctx); err != nil {
+		return nil, err
+	}
+	if purged.Pending, err = service.PurgePending(ctx); err != nil {
+		return nil, err
+	}
+	if purged.Outbox, err = service.PurgeOutbox(ctx); err != nil {
+		return nil, err
+	}
+	if purged.Changes, err = service.PurgeChanges(ctx); err != nil {
+		return nil, err
+	}
+	if purged.RateLimits, err = service.PurgeRateLimits(ctx); err != nil {
+		return nil, err
+	}
+	if purged.Invitations, err = service.PurgeInvitations(ctx); err != nil {
+		return nil, err
+	}
+	if purged.SignIns, err = service.PurgeSignIns(ctx); err != nil {
+		return nil, err
+	}
+	return &purged, nil
+}
+
+// Gets the rows purged since startup
+func (service *JanitorService) Stats() types.Purged {
+	return types.Purged{
+		Blacklist:   service.blacklist.Load(),
+		Codes:       service.codes.Load(),
+		Pending:     service.pending.Load(),
+		Outbox:      service.outbox.Load(),
+		Changes:     service.changes.Load(),
+		RateLimits:  service.ratelimits.Load(),
+		Invitations: service.invitations.Load(),
+		SignIns:     service.signins.Load(),
+	}
+}
+
+// Periodically runs a purge until the context is done, a non positive interval disables it
+func (service *JanitorService) Schedule(ctx context.Context, name string, interval time.Duration, purge func(context.Context) (int64, error)) {
+	if interval <= 0 {
+		log.Info("janitor task disabled", "task", name)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// A run can't take longer than the interval
+				runCtx, cancel := context.WithTimeout(ctx, interval)
+				purged, err := purge(runCtx)
+				cancel()
+				if err != nil {
+					log.Error("janitor task failed", "task", name, "err", err)
+					continue
+				}
+				if purged > 0 {
+					log.Info("janitor purged rows", "task", name, "rows", purged, "stats", service.Stats())
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Tracks failed logins per account slowing them down and locking the account, counters live in the
+// database so they survive restarts and are shared between instances
+type LockoutService struct {
+	DB        *sql.DB
+	Free      int           // Failed logins in a row allowed without any delay
+	Delay     time.Duration // Delay after the first failure past the free ones, doubling with every following one
+	MaxDelay  time.Duration // Upper bound of the delay
+	Threshold int           // Every this many failed logins in a row lock the account, zero disables locking
+	Duration  time.Duration // How long a locked account stays locked unless unlocked
+	Window    time.Duration // Failures older than this are forgotten
+}
+
+// Returned while an account can't log in, wraps either ErrAccountLocked or ErrLoginDelayed
+type LockedError struct {
+	Err   error
+	Until time.Time // Logins are tried again from then
+}
+
+func (e *LockedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LockedError) Unwrap() error {
+	return e.Err
+}
+
+// A login attempt reserved before comparing anything, it counts as failed unless it succeeds or is released
+type Attempt struct {
+	Account  int
+	failures int       // Failed logins in a row including this one
+	token    string    // Token of the unlock link if failing locks the account
+	until    time.Time // When the lock expires if failing locks the account
+	previous *attempts // The row before reserving, nil if there was none
+	settled  bool
+}
+
+// A row of the attempts table
+type attempts struct {
+	failures int
+	next     time.Time
+	locked   sql.NullTime
+	token    string
+	updated  time.Time
+}
+
+// Reserves a login attempt counting it as failed along with the delay and the lock failing it brings, so
+// concurrent logins can't get past the limits while their credentials are compared. Fails with a *LockedError
+// if the account is locked or has to wait before the next login, without counting the attempt
+func (service *LockoutService) Reserve(ctx context.Context, account int) (*Attempt, error) {
+	for ctx.Err() == nil {
+		attempt, err := service.reserve(ctx, account)
+		if err != errContended {
+			return attempt, err
+		}
+	}
+	return nil, ctx.Err()
+}
+
+// Returned by reserve when another attempt changed the failures first
+var errContended = errors.New("attempts contended")
+
+// Compares and swaps the failures of an account with the ones including a new attempt
+func (service *LockoutService) reserve(ctx context.Context, account int) (*Attempt, error) {
+	now := time.Now()
+	var previous attempts
+	err := service.DB.QueryRowContext(ctx, "SELECT failures, next, locked, token, updated FROM attempts WHERE account = ?", account).
+		Scan(&previous.failures, &previous.next, &previous.locked, &previous.token, &previous.updated)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error("failed to database select", "err", err)
+		return nil, err
+	}
+	attempt := &Attempt{Account: account, failures: 1}
+	row := attempts{failures: 1, updated: now}
+	if err == nil {
+		if previous.locked.Valid && now.Before(previous.locked.Time) {
+			return nil, &LockedError{Err: ErrAccountLocked, Until: previous.locked.Time}
+		}
+		if now.Before(previous.next) {
+			return nil, &LockedError{Err: ErrLoginDelayed, Until: previous.next}
+		}
+		attempt.previous = &previous
+		row.locked, row.token = previous.locked, previous.token
+		// Failures older than the window are forgotten
+		if !previous.updated.Before(now.Add(-service.Window)) {
+			attempt.failures = previous.failures + 1
+		}
+		row.failures = attempt.failures
+	}
+	row.next = now.Add(service.delay(attempt.failures))
+	// Locking the account until the lock expires or the owner follows the emailed link
+	if service.Threshold > 0 && attempt.failures%service.Threshold == 0 {
+		if attempt.token, err = generateToken(); err != nil {
+			return nil, err
+		}
+		attempt.until = now.Add(service.Duration)
+		row.locked, row.token = sql.NullTime{Time: attempt.until, Valid: true}, hashToken(attempt.token)
+	}
+	var result sql.Result
+	if attempt.previous == nil {
+		result, err = service.DB.ExecContext(ctx,
+			"INSERT INTO attempts (account, failures, next, locked, token, updated) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (account) DO NOTHING",
+			account, row.failures, row.next, row.locked, row.token, row.updated)
+	} else {
+		query := "UPDATE attempts SET failures = ?, next = ?, locked = ?, token = ?, updated = ? WHERE account = ? AND failures = ?"
+		args := []any{row.failures, row.next, row.locked, row.token, row.updated, account, previous.failures}
+		// Other attempts starting over could leave the failures as they were, but not when they were updated
+		if attempt.failures == 1 {
+			query += " AND updated < ?"
+			args = append(args, now.Add(-service.Window))
+		}
+		result, err = service.DB.ExecContext(ctx, query, args...)
+	}
+	if err != nil {
+		log.Error("failed to database upsert", "err", err)
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affected rows", "err", err)
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, errContended
+	}
+	return attempt, nil
+}
+
+// Settles an attempt as failed, if it locks the account the token for the unlock link is returned with the lock expiration
+func (service *LockoutService) Fail(attempt *Attempt) (string, time.Time) {
+	attempt.settled = true
+	return attempt.token, attempt.until
+}
+
+// Settles an attempt as successful forgetting the failures of the account
+func (service *LockoutService) Succeed(ctx context.Context, attempt *Attempt) error {
+	attempt.settled = true
+	return service.Reset(ctx, attempt.Account)
+}
+
+// Gives back an attempt which neither failed nor succeeded since errors aren't failed logins, unless another
+// attempt was reserved meanwhile the failures are as they were before reserving it
+func (service *LockoutService) Release(ctx context.Context, attempt *Attempt) {
+	if attempt.settled {
+		return
+	}
+	attempt.settled = true
+	// Releasing even if the request was cancelled
+	ctx = context.WithoutCancel(ctx)
+	if attempt.previous == nil {
+		if _, err := service.DB.ExecContext(ctx, "DELETE FROM attempts WHERE account = ? AND failures = ?",
+			attempt.Account, attempt.failures); err != nil {
+			log.Error("failed to database delete", "err", err)
+		}
+		return
+	}
+	previous := attempt.previous
+	if _, err := service.DB.ExecContext(ctx,
+		"UPDATE attempts SET failures = ?, next = ?, locked = ?, token = ?, updated = ? WHERE account = ? AND failures = ?",
+		previous.failures, previous.next, previous.locked, previous.token, previous.updated, attempt.Account, attempt.failures); err != nil {
+		log.Error("failed to database update", "err", err)
+	}
+}
+
+// Gets the delay before the next login after some failures in a row
+func (service *LockoutService) delay(failures int) time.Duration {
+	if failures <= service.Free || service.Delay <= 0 {
+		return 0
+	}
+	delay := service.Delay
+	for i := service.Free + 1; i < failures && delay < service.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, service.MaxDelay)
+}
+
+// Forgets the failures of an account after a successful login
+func (service *LockoutService) Reset(ctx context.Context, account int) error {
+	if _, err := service.DB.ExecContext(ctx, "DELETE FROM attempts WHERE account = ?", account); err != nil {
+		log.Error("failed to database delete", "err", err)
+		return err
+	}
+	return nil
+}
+
+// Unlocks an account by the token emailed when it got locked returning the account id
+func (service *LockoutService) Unlock(ctx context.Context, token string) (int, error) {
+	var account int
+	err := service.DB.QueryRowContext(ctx, "SELECT account FROM attempts WHERE token = ? AND locked > ?", hashToken(token), time.Now()).
+		Scan(&account)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrUnlockTokenNotFound
+		}
+		log.Error("failed to database select", "err", err)
+		return 0, err
+	}
+	if err := service.Reset(ctx, account); err != nil {
+		return 0, err
+	}
+	return account, nil
+}
+
+// Unlocks an account and forgets its failures on behalf of an admin
+func (service *LockoutService) UnlockAccount(ctx context.Context, account int) error {
+	rows, err := service.DB.ExecContext(ctx, "DELETE FROM attempts WHERE account = ?", account)
+	if err != nil {
+		log.Error("failed to database delete", "err", err)
+		return err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		return ErrAccountNotLocked
+	}
+	return nil
+}
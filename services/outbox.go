@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0xalby/based/mailer"
+	"github.com/0xalby/based/types"
+	"github.com/charmbracelet/log"
+)
+
+// Outbox statuses
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+// Persists emails and delivers them in the background, it's a mailer itself so sending only enqueues
+type OutboxService struct {
+	DB          *sql.DB
+	Mailer      mailer.Mailer // The transport actually delivering emails
+	Key         []byte        // AES-256 key encrypting queued emails since they carry codes and tokens
+	MaxAttempts int           // Failed attempts before an email is dead
+	Backoff     time.Duration // Delay before the first retry, doubling on every failure
+	Lease       time.Duration // Time a worker has to deliver a claimed email before others can claim it again
+	owner       string        // Identifies the workers of this instance in the claims
+	wake        chan struct{}
+}
+
+// Enqueues an email
+func (service *OutboxService) Send(ctx context.Context, message *mailer.Message) error {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		log.Error("failed to marshal email", "err", err)
+		return err
+	}
+	sealed, err := service.seal(encoded)
+	if err != nil {
+		log.Error("failed to encrypt email", "err", err)
+		return err
+	}
+	rows, err := service.DB.ExecContext(ctx,
+		"INSERT INTO outbox (recipient, subject, message, status, next) VALUES (?, ?, ?, ?, ?)",
+		message.To, message.Subject, sealed, OutboxPending, time.Now())
+	if err != nil {
+		log.Error("failed to database insert", "err", err)
+		return err
+	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		log.Error("failed to enqueue email")
+		return ErrNoRowsAffected
+	}
+	// Waking up a worker without waiting for the next poll, a nil channel means none is running
+	select {
+	case service.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (service *OutboxService) Close() error {
+	return service.Mailer.Close()
+}
+
+// Starts delivering emails with the given amount of workers until the context is done
+func (service *OutboxService) Run(ctx context.Context, workers int, poll time.Duration) error {
+	if _, err := service.gcm(); err != nil {
+		return err
+	}
+	if service.Lease <= 0 {
+		service.Lease = 5 * time.Minute
+	}
+	// Emails claimed by instances which stopped while sending them are claimed again once their lease runs out
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	if len(hostname) > 40 {
+		hostname = hostname[:40]
+	}
+	service.owner = hostname + "-" + hex.EncodeToString(random)
+	service.wake = make(chan struct{}, 1)
+	for i := 0; i < workers; i++ {
+		go func() {
+			ticker := time.NewTicker(poll)
+			defer ticker.Stop()
+			for {
+				// Delivering until nothing is due
+				for {
+					delivered, err := service.deliverNext(ctx)
+					if err != nil {
+						log.Error("failed to deliver outbox email", "err", err)
+					}
+					if !delivered {
+						break
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				case <-service.wake:
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// Claims and delivers a due email, reporting whether there was one
+func (service *OutboxService) deliverNext(ctx context.Context) (bool, error) {
+	var (
+		id       int
+		attempts int
+		sealed   string
+	)
+	now := time.Now()
+	err := service.DB.QueryRowContext(ctx,
+		"SELECT id, attempts, message FROM outbox WHERE (status = ? AND next <= ?) OR (status = ? AND lease <= ?) ORDER BY next LIMIT 1",
+		OutboxPending, now, OutboxSending, now).Scan(&id, &attempts, &sealed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	// Claiming the email for the length of the lease so other workers and instances skip it
+	rows, err := service.DB.ExecContext(ctx,
+		"UPDATE outbox SET status = ?, owner = ?, lease = ? WHERE id = ? AND (status = ? OR (status = ? AND lease <= ?))",
+		OutboxSending, service.owner, now.Add(service.Lease), id, OutboxPending, OutboxSending, now)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := rows.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if claimed == 0 {
+		return true, nil
+	}
+	encoded, err := service.open(sealed)
+	if err != nil {
+		return true, service.fail(ctx, id, service.MaxAttempts, err)
+	}
+	var message mailer.Message
+	if err := json.Unmarshal(encoded, &message); err != nil {
+		return true, service.fail(ctx, id, service.MaxAttempts, err)
+	}
+	// Giving up before the lease runs out so nobody else sends the email meanwhile
+	sendCtx, cancel := context.WithDeadline(ctx, now.Add(service.Lease))
+	defer cancel()
+	if err := service.Mailer.Send(sendCtx, &message); err != nil {
+		log.Error("failed to send email", "id", id, "attempt", attempts+1, "err", err)
+		return true, service.fail(ctx, id, attempts+1, err)
+	}
+	// Forgetting the content right away, it has codes and tokens in it
+	_, err = service.DB.ExecContext(ctx,
+		"UPDATE outbox SET status = ?, message = '', error = '', lease = NULL WHERE id = ? AND owner = ?",
+		OutboxSent, id, service.owner)
+	return true, err
+}
+
+// Schedules a retry with exponential backoff or marks the email as dead
+func (service *OutboxService) fail(ctx context.Context, id int, attempts int, cause error) error {
+	status := OutboxPending
+	if attempts >= service.MaxAttempts {
+		status = OutboxDead
+	}
+	delay := service.Backoff << (attempts - 1)
+	if delay > 24*time.Hour || delay <= 0 {
+		delay = 24 * time.Hour
+	}
+	_, err := service.DB.ExecContext(ctx,
+		"UPDATE outbox SET status = ?, attempts = ?, next = ?, error = ?, lease = NULL WHERE id = ? AND owner = ?",
+		status, attempts, time.Now().Add(delay), cause.Error(), id, service.owner)
+	return err
+}
+
+// Encrypts an email with AES-GCM prepending the nonce
+func (service *OutboxService) seal(plaintext []byte) (string, error) {
+	gcm, err := service.gcm()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Decrypts an email, the ones queued before encryption are plain JSON
+func (service *OutboxService) open(sealed string) ([]byte, error) {
+	if strings.HasPrefix(sealed, "{") {
+		return []byte(sealed), nil
+	}
+	gcm, err := service.gcm()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("encrypted email too short")
+	}
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+}
+
+func (service *OutboxService) gcm() (cipher.AEAD, error) {
+	if len(service.Key) != 32 {
+		return nil, errors.New("the outbox key has to be 32 bytes")
+	}
+	block, err := aes.NewCipher(service.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Lists emails with a status, all of them if empty
+func (service *OutboxService) List(ctx context.Context, status string, limit int) ([]types.Email, error) {
+	rows, err := service.DB.QueryContext(ctx,
+		"SELECT id, recipient, subject, status, attempts, next, error, created FROM outbox WHERE status = ? OR ? = '' ORDER BY id DESC LIMIT ?",
+		status, status, limit)
+	if err != nil {
+		log.Error("failed to database query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	emails := []types.Email{}
+	for rows.Next() {
+		var email types.Email
+		if err := rows.Scan(&email.ID, &email.Recipient, &email.Subject, &email.Status, &email.Attempts, &email.Next, &email.Error, &email.Created); err != nil {
+			log.Error("failed to database scan", "err", err)
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("failed iterating rows", "err", err)
+		return nil, err
+	}
+	return emails, nil
+}
+
+// Sends a dead email again from scratch
+func (service *OutboxService) Retry(ctx context.Context, id int) error {
+	rows, err := service.DB.ExecContext(ctx,
+		"UPDATE outbox SET status = ?, attempts = 0, next = ? WHERE id = ? AND status = ?",
+		OutboxPending, time.Now(), id, OutboxDead)
+	if err != nil {
+		log.Error("failed to database update", "err", err)
+		return err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		return ErrEmailNotFound
+	}
+	select {
+	case service.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
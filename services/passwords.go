@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+// Decides whether a new password is good enough, the same policy for registrations, updates and resets
+type PasswordService struct {
+	MinLength int             // Minimum length in characters
+	MaxLength int             // Maximum length in characters
+	MinScore  int             // Minimum zxcvbn strength score from 0(too guessable) to 4(very unguessable)
+	Breached  BreachedDataset // Passwords known from breaches, not checked if nil
+}
+
+// Passwords known from breaches
+type BreachedDataset interface {
+	// Reports whether a password appeared in a breach
+	Contains(ctx context.Context, password string) (bool, error)
+}
+
+// Checks a new password of the account with an email address against the policy
+func (service *PasswordService) Check(ctx context.Context, password, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < service.MinLength {
+		return ErrPasswordTooShort
+	}
+	if service.MaxLength > 0 && length > service.MaxLength {
+		return ErrPasswordTooLong
+	}
+	// Refusing passwords built from the email address
+	inputs := emailParts(email)
+	lower := strings.ToLower(password)
+	for _, part := range inputs {
+		if strings.Contains(lower, part) {
+			return ErrPasswordContainsEmail
+		}
+	}
+	// Scoring how many guesses the password would take knowing the email address
+	if zxcvbn.PasswordStrength(password, inputs).Score < service.MinScore {
+		return ErrPasswordTooWeak
+	}
+	if service.Breached != nil {
+		breached, err := service.Breached.Contains(ctx, password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			return ErrPasswordBreached
+		}
+	}
+	return nil
+}
+
+// Splits an email address into the lowercase words a password shouldn't contain, short ones and the tld are left out
+func emailParts(email string) []string {
+	local, domain, _ := strings.Cut(strings.ToLower(email), "@")
+	labels := strings.Split(domain, ".")
+	if len(labels) > 1 {
+		labels = labels[:len(labels)-1]
+	}
+	var parts []string
+	for _, part := range append(strings.FieldsFunc(local, isSeparator), labels...) {
+		if utf8.RuneCountInString(part) >= 4 {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func isSeparator(r rune) bool {
+	return r == '.' || r == '_' || r == '-' || r == '+'
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/0xalby/based/utils"
+	"github.com/charmbracelet/log"
+)
+
+// Remembers where and how accounts sign in so sign-ins from new contexts can be told apart, a context is the
+// network of the address and the families of the browser and operating system
+type SignInsService struct {
+	DB       *sql.DB
+	Size     int           // Contexts remembered per account, the least recently seen are forgotten
+	Verify   bool          // Sign-ins from new contexts have to be confirmed with an emailed code
+	Validity time.Duration // How long the secure my account link of a new sign-in works
+}
+
+// Reduces a client to its context, the /24 or /48 network of the address and the user agent family
+func Fingerprint(ip, userAgent string) (string, string) {
+	network := "unknown"
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		addr = addr.Unmap()
+		bits := 48
+		if addr.Is4() {
+			bits = 24
+		}
+		if prefix, err := addr.Prefix(bits); err == nil {
+			network = prefix.String()
+		}
+	}
+	return network, agentFamily(userAgent)
+}
+
+// Reports if an account signed in from a context before, the first sign-in of an account is trusted since
+// there's nothing to compare it with
+func (service *SignInsService) Known(ctx context.Context, account int, network, agent string) (bool, error) {
+	var total, matching int
+	err := service.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*), COALESCE(SUM(CASE WHEN network = ? AND agent = ? THEN 1 ELSE 0 END), 0) FROM signins WHERE account = ? AND code = ''",
+		network, agent, account).Scan(&total, &matching)
+	if err != nil {
+		log.Error("failed to database select", "err", err)
+		return false, err
+	}
+	return total == 0 || matching > 0, nil
+}
+
+// Records a sign-in from a known context or a new one, new contexts are returned the token of the secure my
+// account link while known ones get an empty one
+func (service *SignInsService) Record(ctx context.Context, account int, network, agent string) (string, error) {
+	rows, err := service.DB.ExecContext(ctx, "UPDATE signins SET seen = ? WHERE account = ? AND network = ? AND agent = ? AND code = ''",
+		time.Now(), account, network, agent)
+	if err != nil {
+		log.Error("failed to database update", "err", err)
+		return "", err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return "", err
+	}
+	if affected > 0 {
+		return "", nil
+	}
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := service.DB.ExecContext(ctx, "INSERT INTO signins (account, network, agent, token) VALUES (?, ?, ?, ?)",
+		account, network, agent, hashToken(token)); err != nil {
+		log.Error("failed to database insert", "err", err)
+		return "", err
+	}
+	return token, service.trim(ctx, account)
+}
+
+// Starts a sign-in from a new context returning the code to email which confirms it
+func (service *SignInsService) Challenge(ctx context.Context, account int, network, agent string) (string, error) {
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		return "", err
+	}
+	// Replacing the code of an earlier attempt from the same context
+	if _, err := service.DB.ExecContext(ctx, "DELETE FROM signins WHERE account = ? AND network = ? AND agent = ? AND code <> ''",
+		account, network, agent); err != nil {
+		log.Error("failed to database delete", "err", err)
+		return "", err
+	}
+	expiration := time.Now().Add(15 * time.Minute) // expires in 15 minutes
+	if _, err := service.DB.ExecContext(ctx, "INSERT INTO signins (account, network, agent, code, expiration) VALUES (?, ?, ?, ?, ?)",
+		account, network, agent, hashToken(code), expiration); err != nil {
+		log.Error("failed to database insert", "err", err)
+		return "", err
+	}
+	return code, nil
+}
+
+// Confirms a sign-in from a new context with the emailed code remembering the context from then on
+func (service *SignInsService) Confirm(ctx context.Context, account int, network, agent, code string) error {
+	rows, err := service.DB.ExecContext(ctx,
+		"UPDATE signins SET code = '', seen = ? WHERE account = ? AND network = ? AND agent = ? AND code = ? AND expiration > ?",
+		time.Now(), account, network, agent, hashToken(code), time.Now())
+	if err != nil {
+		log.Error("failed to database update", "err", err)
+		return err
+	}
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidSignInCode
+	}
+	return service.trim(ctx, account)
+}
+
+// Forgets the sign-in the secure my account link was emailed for returning the account to log out everywhere
+func (service *SignInsService) Secure(ctx context.Context, token string) (int, error) {
+	var id, account int
+	err := service.DB.QueryRowContext(ctx, "SELECT id, account FROM signins WHERE token = ? AND created > ?",
+		hashToken(token), time.Now().Add(-service.Validity)).Scan(&id, &account)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrSignInNotFound
+		}
+		log.Error("failed to database select", "err", err)
+		return 0, err
+	}
+	// The context isn't trusted anymore so signing in from it again is noticed
+	if _, err := service.DB.ExecContext(ctx, "DELETE FROM signins WHERE id = ?", id); err != nil {
+		log.Error("failed to database delete", "err", err)
+		return 0, err
+	}
+	return account, nil
+}
+
+// Forgets the contexts of an account past the size of the history
+func (service *SignInsService) trim(ctx context.Context, account int) error {
+	if service.Size <= 0 {
+		return nil
+	}
+	if _, err := service.DB.ExecContext(ctx,
+		`DELETE FROM signins WHERE account = ? AND code = '' AND id NOT IN (
+			SELECT id FROM signins WHERE account = ? AND code = '' ORDER BY seen DESC LIMIT ?
+		)`, account, account, service.Size); err != nil {
+		log.Error("failed to database delete", "err", err)
+		return err
+	}
+	return nil
+}
+
+// Names the browser and operating system families of a user agent like "Firefox on Linux", version
+// numbers are left out so updates don't look like new devices
+func agentFamily(userAgent string) string {
+	if userAgent == "" {
+		return "unknown"
+	}
+	// Order matters since most browsers claim to be others as well
+	browser := "Other"
+	for _, family := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"grpc-", "gRPC"},
+		{"Go-http-client/", "Go"},
+	} {
+		if strings.Contains(userAgent, family.token) {
+			browser = family.name
+			break
+		}
+	}
+	system := ""
+	for _, family := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, family.token) {
+			system = family.name
+			break
+		}
+	}
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
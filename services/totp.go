@@ -2,12 +2,16 @@ package services
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/0xalby/based/utils"
@@ -16,15 +20,15 @@ import (
 	"github.com/pquerna/otp/totp"
 	"github.com/yeqown/go-qrcode/v2"
 	"github.com/yeqown/go-qrcode/writer/standard"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type TotpService struct {
-	DB *sql.DB
+	DB     *sql.DB
+	Pepper []byte // Key backup codes are hashed with, kept out of the database so leaked hashes can't be guessed
 }
 
 // Generates and a saves a totp secret
-func (service *TotpService) GenerateTOTPSecret(email string, id int) (*otp.Key, error) {
+func (service *TotpService) GenerateTOTPSecret(ctx context.Context, email string, id int) (*otp.Key, error) {
 	// Generate a new TOTP key
 	key, err := totp.Generate(totp.GenerateOpts{
 		Issuer:      "Based",
@@ -36,21 +40,21 @@ func (service *TotpService) GenerateTOTPSecret(email string, id int) (*otp.Key,
 		return nil, err
 	}
 	// Store the secret in the database
-	rows, err := service.DB.Exec("UPDATE accounts SET secret = ? WHERE id = ?", key.Secret(), id)
+	rows, err := service.DB.ExecContext(ctx, "UPDATE accounts SET secret = ? WHERE id = ?", key.Secret(), id)
 	if err != nil {
-		// Checking for affected rows
-		affected, err := rows.RowsAffected()
-		if err != nil {
-			log.Error("failed to get affacted rows", "err", err)
-			return nil, err
-		}
-		if affected == 0 {
-			log.Error("failed to add verification code")
-			return nil, fmt.Errorf("no rows affected")
-		}
 		log.Error("failed to store totp secret", "err", err)
 		return nil, err
 	}
+	// Checking for affected rows
+	affected, err := rows.RowsAffected()
+	if err != nil {
+		log.Error("failed to get affacted rows", "err", err)
+		return nil, err
+	}
+	if affected == 0 {
+		log.Error("failed to store totp secret")
+		return nil, ErrNoRowsAffected
+	}
 	return key, nil
 }
 
@@ -96,23 +100,28 @@ func (service *TotpService) GenerateQRCode(key *otp.Key) ([]byte, error) {
 }
 
 // Validates a totp code
-func (service *TotpService) ValidateTOTP(id int, code string) (bool, error) {
+func (service *TotpService) ValidateTOTP(ctx context.Context, id int, code string) (bool, error) {
 	// Retrieving the code from the database
 	var secret string
-	err := service.DB.QueryRow("SELECT secret FROM accounts WHERE id = ?", id).Scan(&secret)
+	err := service.DB.QueryRowContext(ctx, "SELECT secret FROM accounts WHERE id = ?", id).Scan(&secret)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, fmt.Errorf("account not found")
+			return false, ErrAccountNotFound
 		}
 		log.Error("failed to retrieve totp secret", "err", err)
 		return false, err
 	}
 	// Validate the totp code
 	valid, err := totp.ValidateCustom(code, secret, time.Now(), totp.ValidateOpts{Skew: 1, Digits: 6})
-	if !valid {
+	// A code of the wrong length is as wrong as any other, only failures to validate are errors
+	if err == otp.ErrValidateInputInvalidLength {
+		return false, nil
+	}
+	if err != nil {
+		log.Error("failed to validate totp code", "err", err)
 		return false, err
 	}
-	return true, nil
+	return valid, nil
 }
 
 // Generates backup codes
@@ -130,17 +139,13 @@ func (service *TotpService) GenerateBackupCodes(count int, length int) ([]string
 }
 
 // Stores backup codes in the database
-func (service *TotpService) AddBackupCodes(codes []string, account int) error {
+func (service *TotpService) AddBackupCodes(ctx context.Context, codes []string, account int) error {
 	// Looping over the codes
 	var rows sql.Result
 	for _, code := range codes {
-		// Hashing the code
-		hash, err := utils.Hash(code)
-		if err != nil {
-			return err
-		}
-		// Adding the code to the database
-		rows, err = service.DB.Exec("INSERT INTO backup (hash, account) VALUES (?, ?)", hash, account)
+		// Adding the hashed code to the database
+		var err error
+		rows, err = service.DB.ExecContext(ctx, "INSERT INTO backup (hash, account) VALUES (?, ?)", hashBackupCode(service.Pepper, code), account)
 		if err != nil {
 			log.Error("failed to add backup code", "err", err)
 			return fmt.Errorf("failed to add backup code")
@@ -153,20 +158,20 @@ func (service *TotpService) AddBackupCodes(codes []string, account int) error {
 		}
 		if affected == 0 {
 			log.Error("failed to add backup codes", "err", err)
-			return fmt.Errorf("no rows affected")
+			return ErrNoRowsAffected
 		}
 	}
 	return nil
 }
 
 // Validates backup codes
-func (service *TotpService) ValidateBackupCode(account int, code string) error {
+func (service *TotpService) ValidateBackupCode(ctx context.Context, account int, code string) error {
 	// Fetch all unused backup codes for the account
-	rows, err := service.DB.Query("SELECT id, hash FROM backup WHERE account = ?", account)
+	rows, err := service.DB.QueryContext(ctx, "SELECT id, hash FROM backup WHERE account = ?", account)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Error("no backup codes found for the account", "err", err)
-			return fmt.Errorf("code not found")
+			return ErrBackupCodeNotFound
 		}
 		log.Error("failed to retrieve backup code", "err", err)
 		return err
@@ -182,17 +187,28 @@ func (service *TotpService) ValidateBackupCode(account int, code string) error {
 			log.Error("failed to iterate over rows", "err", err)
 			return fmt.Errorf("failed to scan backup code")
 		}
-		// Compare the provided code with the hashed code
-		if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(code)); err == nil {
+		// Compare the provided code with the hashed code, codes hashed like passwords or before the pepper was set
+		// are still accepted
+		if hmac.Equal([]byte(hashed), []byte(hashBackupCode(service.Pepper, code))) ||
+			(len(service.Pepper) > 0 && hmac.Equal([]byte(hashed), []byte(hashBackupCode(nil, code)))) ||
+			(strings.HasPrefix(hashed, "$") && utils.CompareHashedAndPlain(hashed, code)) {
 			return nil
 		}
 	}
-	return fmt.Errorf("invalid backup code")
+	return ErrInvalidBackupCode
+}
+
+// Hashes a backup code with hmac-sha256, random codes don't need a slow hash but without the pepper a short one
+// is quickly found from its hash
+func hashBackupCode(pepper []byte, code string) string {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
 // Deletes backup codes
-func (service *TotpService) DeleteBackupCodes(account int) error {
-	result, err := service.DB.Exec("DELETE FROM backup WHERE account = ?", account)
+func (service *TotpService) DeleteBackupCodes(ctx context.Context, account int) error {
+	result, err := service.DB.ExecContext(ctx, "DELETE FROM backup WHERE account = ?", account)
 	if err != nil {
 		log.Error("failed to delete backup codes", "err", err)
 		return fmt.Errorf("failed to delete backup codes")
@@ -205,7 +221,7 @@ func (service *TotpService) DeleteBackupCodes(account int) error {
 	}
 	if rowsAffected == 0 {
 		log.Error("no affected rows", "err", err)
-		return fmt.Errorf("no affected rows")
+		return ErrNoRowsAffected
 	}
 	return nil
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/0xalby/based/client"
+	"github.com/0xalby/based/types"
+	"github.com/0xalby/based/utils"
+)
+
+func TestBackupCodesHashes(t *testing.T) {
+	ctx := context.Background()
+	pepper := "a pepper kept out of the database"
+	api := newTestAPI(t, map[string]string{"PASSWORD_PEPPER": pepper})
+	c := register(t, api, "backup@example.com", true)
+	key, err := c.EnableTOTP(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Codes are stored as their hmac keyed by the pepper
+	id := accountID(t, api, "backup@example.com")
+	rows, err := api.DB.Query("SELECT hash FROM backup WHERE account = ?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := map[string]bool{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			t.Fatal(err)
+		}
+		stored[hash] = true
+	}
+	rows.Close()
+	for _, code := range key.Backup {
+		mac := hmac.New(sha256.New, []byte(pepper))
+		mac.Write([]byte(code))
+		if !stored[hex.EncodeToString(mac.Sum(nil))] {
+			t.Fatalf("backup code %s isn't stored as its hmac", code)
+		}
+	}
+	// Codes hashed like passwords before still work once
+	legacy, err := utils.Hash("0123abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.DB.Exec("INSERT INTO backup (hash, account) VALUES (?, ?)", legacy, id); err != nil {
+		t.Fatal(err)
+	}
+	backup := types.PayloadLoginWithBackupCode{Email: "backup@example.com", BackupCode: "0123abcd"}
+	if _, err := c.LoginWithBackupCode(ctx, backup); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.LoginWithBackupCode(ctx, backup)
+	expect(t, err, client.ErrInvalidBackupCode)
+	// So do codes hashed before the pepper was set
+	mac := hmac.New(sha256.New, nil)
+	mac.Write([]byte("4567cdef"))
+	if _, err := api.DB.Exec("INSERT INTO backup (hash, account) VALUES (?, ?)", hex.EncodeToString(mac.Sum(nil)), id); err != nil {
+		t.Fatal(err)
+	}
+	backup = types.PayloadLoginWithBackupCode{Email: "backup@example.com", BackupCode: "4567cdef"}
+	if _, err := c.LoginWithBackupCode(ctx, backup); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -13,6 +13,53 @@ type Account struct {
 	TotpSecret  string    `json:"-"`            // TOTP secret
 	Updated     time.Time `json:"updated"`      // Timestamp of the last update
 	Created     time.Time `json:"created"`      // Timestamp of account creation
+	Locale      string    `json:"locale"`       // Preferred locale, empty follows the Accept-Language header
+}
+
+// Represents an email in the outbox
+type Email struct {
+	ID        int       `json:"id"`        // Unique identifier for the email
+	Recipient string    `json:"recipient"` // Email address of the recipient
+	Subject   string    `json:"subject"`   // Subject of the email
+	Status    string    `json:"status"`    // Either pending, sending, sent or dead
+	Attempts  int       `json:"attempts"`  // Failed delivery attempts
+	Next      time.Time `json:"next"`      // Timestamp of the next delivery attempt
+	Error     string    `json:"error"`     // Last delivery error
+	Created   time.Time `json:"created"`   // Timestamp of the email creation
+}
+
+// Represents an email address change
+type EmailChange struct {
+	ID         int       `json:"id"`         // Unique identifier for the change
+	Account    int       `json:"account"`    // Account whose email changes
+	Old        string    `json:"old"`        // Email address before the change
+	New        string    `json:"new"`        // Email address after the change
+	Status     string    `json:"status"`     // Either pending, confirmed, cancelled or reverted
+	Expiration time.Time `json:"expiration"` // Timestamp until which the old address can cancel or revert it
+	Created    time.Time `json:"created"`    // Timestamp of the request
+}
+
+// Represents an invitation to register
+type Invitation struct {
+	ID         int       `json:"id"`         // Unique identifier for the invitation
+	Inviter    int       `json:"inviter"`    // Account which created it, zero if an admin did
+	Email      string    `json:"email"`      // Only this address can use it, any if empty
+	Uses       int       `json:"uses"`       // Registrations made with it
+	MaxUses    int       `json:"max_uses"`   // Registrations it allows
+	Expiration time.Time `json:"expiration"` // Timestamp after which it can't be used
+	Created    time.Time `json:"created"`    // Timestamp of the invitation creation
+}
+
+// Rows purged by the janitor
+type Purged struct {
+	Blacklist   int64 `json:"blacklist"`   // Expired revoked tokens
+	Codes       int64 `json:"codes"`       // Expired verification, confirmation and recovery codes
+	Pending     int64 `json:"pending"`     // Pending emails without a valid confirmation code
+	Outbox      int64 `json:"outbox"`      // Sent outbox emails older than a week
+	Changes     int64 `json:"changes"`     // Email changes which can't be cancelled or reverted anymore
+	RateLimits  int64 `json:"ratelimits"`  // Rate limit windows no limiter looks at anymore
+	Invitations int64 `json:"invitations"` // Expired invitations
+	SignIns     int64 `json:"signins"`     // Sign-ins never confirmed with the emailed code
 }
 
 // Payloads
@@ -20,13 +67,18 @@ type (
 	// The payload for registering a new account
 	PayloadRegister struct {
 		Email    string `json:"email" validate:"required,email"`
-		Password string `json:"password" validate:"required,min=12,max=128,containsany=!@#$%^&*"`
+		Password string `json:"password" validate:"required,max=1024"`      // Checked against the password policy
+		Locale   string `json:"locale" validate:"omitempty,oneof=en it de"` // Preferred locale(optional)
+		// Invitation token, required if registrations are invite only
+		Invitation string `json:"invitation" validate:"omitempty,len=64,hexadecimal"`
 	}
 	// The payload for logging into an account
 	PayloadLogin struct {
 		Email    string `json:"email" validate:"required,email"`
-		Password string `json:"password" validate:"required,min=12,max=128,containsany=!@#$%^&*"`
+		Password string `json:"password" validate:"required,max=1024"`
 		TOTP     string `json:"totp" validate:"omitempty"` // TOTP code(optional)
+		// Code emailed to confirm a sign-in from a new device or location, only if asked for
+		SignInCode string `json:"signin_code" validate:"omitempty,len=6,ascii"`
 	}
 	// The payload for verifying an account
 	PayloadVerification struct {
@@ -36,6 +88,16 @@ type (
 	PayloadLoginWithBackupCode struct {
 		Email      string `json:"email" validate:"required,email"`
 		BackupCode string `json:"code" validate:"required,len=8,ascii"`
+		// Code emailed to confirm a sign-in from a new device or location, only if asked for
+		SignInCode string `json:"signin_code" validate:"omitempty,len=6,ascii"`
+	}
+	// The payload for logging out every session with the token emailed on a new sign-in
+	PayloadSecure struct {
+		Token string `json:"token" validate:"required,len=64,hexadecimal"`
+	}
+	// The payload for unlocking an account with the token emailed when it got locked
+	PayloadUnlock struct {
+		Token string `json:"token" validate:"required,len=64,hexadecimal"`
 	}
 	// The payload for sending a confirmation email
 	PayloadAccountSendConfirmationEmail struct {
@@ -45,10 +107,18 @@ type (
 	PayloadAccountUpdateEmail struct {
 		Code string `json:"code" validate:"required,len=6,ascii"`
 	}
+	// The payload for cancelling or reverting an email change from the old address
+	PayloadAccountCancelEmailChange struct {
+		Token string `json:"token" validate:"required,len=64,hexadecimal"`
+	}
 	// The payload for updating an account's password
 	PayloadAccountUpdatePassword struct {
-		Old string `json:"old" validate:"required,min=12,max=128,containsany=!@#$%^&*"`
-		New string `json:"new" validate:"required,min=12,max=128,containsany=!@#$%^&*"`
+		Old string `json:"old" validate:"required,max=1024"`
+		New string `json:"new" validate:"required,max=1024"` // Checked against the password policy
+	}
+	// The payload for updating an account's preferred locale
+	PayloadAccountUpdateLocale struct {
+		Locale string `json:"locale" validate:"omitempty,oneof=en it de"` // Empty follows the Accept-Language header
 	}
 	// The payload for initiating account recovery
 	PayloadAccountRecovery struct {
@@ -57,10 +127,17 @@ type (
 	// The payload for resetting an account's password
 	PayloadAccountReset struct {
 		Code     string `json:"code" validate:"required,len=6,ascii"`
-		Password string `json:"password" validate:"required,min=12,max=128,containsany=!@#$%^&*"`
+		Password string `json:"password" validate:"required,max=1024"` // Checked against the password policy
+	}
+	// The payload for creating an invitation
+	PayloadInvitation struct {
+		Email string `json:"email" validate:"omitempty,email"`         // Address the invitation is emailed to(optional)
+		Bind  bool   `json:"bind"`                                     // Whether only that address can use it
+		Uses  int    `json:"uses" validate:"omitempty,min=1,max=1000"` // Registrations it allows, only admins can set more than one
+		Days  int    `json:"days" validate:"omitempty,min=1,max=365"`  // Days it lasts, only admins can set it
 	}
 	// The payload for deleting an account.
 	PayloadAccountDelete struct {
-		Password string `json:"password" validate:"required,min=12,max=128,containsany=!@#$%^&*"` // Account password
+		Password string `json:"password" validate:"required,max=1024"` // Account password
 	}
 )
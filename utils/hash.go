@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by hashing
+var ErrInvalidHash = errors.New("invalid hash")
+
+// Argon2id parameters new hashes are made with
+type Argon2id struct {
+	Time    uint32 // Passes over the memory
+	Memory  uint32 // Memory in KiB
+	Threads uint8
+	Salt    uint32 // Salt length in bytes
+	Key     uint32 // Hash length in bytes
+	Pepper  []byte // Secret mixed into every password before hashing, kept out of the database
+}
+
+// Global instance of the parameters, the defaults follow the OWASP recommendation
+var Hasher = Argon2id{Time: 3, Memory: 64 * 1024, Threads: 2, Salt: 16, Key: 32}
+
+// Id of PASSWORD_PEPPER written as k in the hashes made with it
+const pepperKey = 1
+
+// A parsed argon2id hash
+type argon2idHash struct {
+	params Argon2id
+	pepper int // Id of the pepper the password was mixed with, zero for none
+	salt   []byte
+	key    []byte
+}
+
+// Hashes a string with argon2id into the PHC format "$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>",
+// with a pepper the parameters end with ",k=<pepper id>" so hashes made before it was set are still told apart
+func Hash(password string) (string, error) {
+	salt := make([]byte, Hasher.Salt)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(Hasher.pepper(password), salt, Hasher.Time, Hasher.Memory, Hasher.Threads, Hasher.Key)
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", Hasher.Memory, Hasher.Time, Hasher.Threads)
+	if id := Hasher.pepperID(); id != 0 {
+		params += fmt.Sprintf(",k=%d", id)
+	}
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Compares hashed strings with plaintext ones, hashes made with bcrypt before argon2id or without the pepper are
+// still accepted
+func CompareHashedAndPlain(hashed, plain string) bool {
+	if !strings.HasPrefix(hashed, "$argon2id$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plain))
+		return err == nil
+	}
+	hash, err := decodeArgon2id(hashed)
+	if err != nil {
+		return false
+	}
+	password := []byte(plain)
+	if hash.pepper != 0 {
+		// Hashes made with a pepper which isn't set anymore can't match
+		if hash.pepper != Hasher.pepperID() {
+			return false
+		}
+		password = Hasher.pepper(plain)
+	}
+	other := argon2.IDKey(password, hash.salt, hash.params.Time, hash.params.Memory, hash.params.Threads, uint32(len(hash.key)))
+	return subtle.ConstantTimeCompare(hash.key, other) == 1
+}
+
+// Reports if a hash was made with another algorithm, parameters or pepper than the current ones and should be made again
+func NeedsRehash(hashed string) bool {
+	hash, err := decodeArgon2id(hashed)
+	if err != nil {
+		return true
+	}
+	return hash.params.Time != Hasher.Time || hash.params.Memory != Hasher.Memory || hash.params.Threads != Hasher.Threads ||
+		uint32(len(hash.salt)) != Hasher.Salt || uint32(len(hash.key)) != Hasher.Key || hash.pepper != Hasher.pepperID()
+}
+
+// Mixes the pepper into a password with hmac so passwords of any length are hashed whole
+func (hasher Argon2id) pepper(password string) []byte {
+	if len(hasher.Pepper) == 0 {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, hasher.Pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// Gets the id hashes made with the pepper carry, zero without one
+func (hasher Argon2id) pepperID() int {
+	if len(hasher.Pepper) == 0 {
+		return 0
+	}
+	return pepperKey
+}
+
+// Parses an argon2id hash in the PHC format
+func decodeArgon2id(hashed string) (*argon2idHash, error) {
+	var (
+		hash    argon2idHash
+		version int
+	)
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrInvalidHash
+	}
+	params, pepper, peppered := strings.Cut(parts[3], ",k=")
+	if _, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &hash.params.Memory, &hash.params.Time, &hash.params.Threads); err != nil ||
+		hash.params.Time == 0 || hash.params.Threads == 0 {
+		return nil, ErrInvalidHash
+	}
+	if peppered {
+		id, err := strconv.Atoi(pepper)
+		if err != nil || id <= 0 {
+			return nil, ErrInvalidHash
+		}
+		hash.pepper = id
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidHash
+	}
+	hash.salt, hash.key = salt, key
+	return &hash, nil
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// Hashes cheaply with the given pepper until the test ends
+func withHasher(t *testing.T, pepper string) {
+	t.Helper()
+	previous := Hasher
+	t.Cleanup(func() { Hasher = previous })
+	Hasher = Argon2id{Time: 1, Memory: 8 * 1024, Threads: 1, Salt: 16, Key: 32, Pepper: []byte(pepper)}
+}
+
+func TestHashPepperedLater(t *testing.T) {
+	withHasher(t, "")
+	before, err := Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(before) {
+		t.Fatalf("%s needs a rehash with the parameters it was made with", before)
+	}
+	// Setting a pepper keeps hashes made without it matching, flagged to be made again with it
+	withHasher(t, "pepper")
+	if !CompareHashedAndPlain(before, "correct horse battery staple") {
+		t.Fatal("a hash made before the pepper was set doesn't match")
+	}
+	if CompareHashedAndPlain(before, "wrong horse battery staple") {
+		t.Fatal("a hash made before the pepper was set matches another password")
+	}
+	if !NeedsRehash(before) {
+		t.Fatal("a hash made before the pepper was set isn't rehashed")
+	}
+	after, err := Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(after, ",k=1$") || NeedsRehash(after) {
+		t.Fatalf("%s doesn't carry the pepper id", after)
+	}
+	if !CompareHashedAndPlain(after, "correct horse battery staple") {
+		t.Fatal("a peppered hash doesn't match")
+	}
+	// Without the pepper peppered hashes can't match
+	withHasher(t, "")
+	if CompareHashedAndPlain(after, "correct horse battery staple") {
+		t.Fatal("a peppered hash matches without the pepper")
+	}
+	if !NeedsRehash(after) {
+		t.Fatal("a peppered hash isn't rehashed once the pepper is gone")
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/0xalby/based/locale"
+)
+
+// Problem details as per RFC 7807 extended with a stable machine readable code
+type Problem struct {
+	Type    string       `json:"type"`             // Always about:blank, the code tells problems apart
+	Title   string       `json:"title"`            // The http status text
+	Status  int          `json:"status"`           // The http status
+	Detail  string       `json:"detail"`           // Human readable explanation in the request's locale
+	Code    string       `json:"code"`             // Stable code clients can rely on
+	Message string       `json:"message"`          // Same as detail for clients predating problem details
+	Errors  []FieldError `json:"errors,omitempty"` // Invalid request body fields
+}
+
+// A request body field failing validation
+type FieldError struct {
+	Field   string `json:"field"`   // The json name of the field
+	Code    string `json:"code"`    // The failed validation rule(example "required" or "email")
+	Message string `json:"message"` // Human readable explanation in the request's locale
+}
+
+// Creates a problem, the detail is written in english and translated when responding
+func NewProblem(status int, code, detail string) *Problem {
+	return &Problem{Status: status, Code: code, Detail: detail}
+}
+
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+// Sends a problem as application/problem+json
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) error {
+	lang := locale.FromContext(r.Context())
+	// Copying since problems are usually shared
+	response := *p
+	response.Type = "about:blank"
+	response.Title = http.StatusText(p.Status)
+	response.Detail = locale.Translate(lang, p.Detail)
+	response.Message = response.Detail
+	w.Header().Set("Content-Language", lang)
+	securityHeaders(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(response)
+}
+
+// Explanations of the validation rules used by payloads, {param} is replaced by the rule's parameter
+var rules = map[string]string{
+	"required":    "is required",
+	"email":       "has to be a valid email address",
+	"min":         "has to be at least {param} characters long",
+	"max":         "has to be at most {param} characters long",
+	"len":         "has to be exactly {param} characters long",
+	"containsany": "has to contain at least one of {param}",
+	"ascii":       "has to contain only ascii characters",
+	"oneof":       "has to be one of {param}",
+	"hexadecimal": "has to be hexadecimal",
+}
+
+// Explains why a field failed a validation rule in a locale
+func explainRule(lang, rule, param string) string {
+	explanation, ok := rules[rule]
+	if !ok {
+		explanation = "is invalid"
+	}
+	return strings.ReplaceAll(locale.Translate(lang, explanation), "{param}", param)
+}
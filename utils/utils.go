@@ -2,63 +2,133 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"math/rand"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
 	"net/http"
+	"os"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/0xalby/based/locale"
 	"github.com/charmbracelet/log"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/go-playground/validator/v10"
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/idna"
+)
+
+// Errors returned by utils
+var (
+	ErrEmptyBody     = NewProblem(http.StatusBadRequest, "empty_body", "empty request body")
+	ErrInvalidBody   = NewProblem(http.StatusBadRequest, "invalid_body", "invalid request body")
+	ErrInvalidEmail  = NewProblem(http.StatusBadRequest, "invalid_email", "invalid email address")
+	ErrInvalidClaims = errors.New("invalid claims")
 )
 
 // Unmarshals json into a type struct
 func Unmarshal(w http.ResponseWriter, r *http.Request, payload any) error {
 	// Checking for an empty payload
 	if r.Body == nil {
-		Response(w, http.StatusBadRequest, map[string]interface{}{"message": "empty request body", "status": http.StatusBadRequest})
-		return fmt.Errorf("empty request")
+		WriteProblem(w, r, ErrEmptyBody)
+		return ErrEmptyBody
 	}
 	// Decoding the payload
 	err := json.NewDecoder(r.Body).Decode(payload)
+	if errors.Is(err, io.EOF) {
+		WriteProblem(w, r, ErrEmptyBody)
+		return ErrEmptyBody
+	}
 	if err != nil {
-		Response(w, http.StatusBadRequest, map[string]interface{}{"message": "invalid request body", "status": http.StatusBadRequest})
+		WriteProblem(w, r, ErrInvalidBody)
 		return err
 	}
 	return nil
 }
 
-// Global instance of the validator
-var Validator = validator.New(validator.WithRequiredStructEnabled())
+// Global instance of the validator reporting fields by their json name
+var Validator = newValidator()
 
-// Validates a struct
-func Validate(w http.ResponseWriter, r *http.Request, payload any) error {
-	if err := Validator.Struct(payload); err != nil {
-		if verrs := err.(validator.ValidationErrors); verrs != nil {
-			Response(w, http.StatusBadRequest, map[string]interface{}{"message": "failed to validate one or more request body fields", "error": verrs.Error(), "status": http.StatusBadRequest})
-			return errors.New(verrs.Error())
+func newValidator() *validator.Validate {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
 		}
+		return name
+	})
+	return validate
+}
+
+// Validates a struct responding with a problem listing every invalid field
+func Validate(w http.ResponseWriter, r *http.Request, payload any) error {
+	err := Check(r.Context(), payload)
+	if err == nil {
+		return nil
 	}
-	return nil
+	var problem *Problem
+	if !errors.As(err, &problem) {
+		log.Error("failed to validate", "err", err)
+		WriteProblem(w, r, NewProblem(http.StatusInternalServerError, "internal_error", "internal server error"))
+		return err
+	}
+	WriteProblem(w, r, problem)
+	return problem
 }
 
-// Sends a response
-func Response(w http.ResponseWriter, status int, v any) error {
+// Validates a struct returning a problem listing every invalid field explained in the context's locale
+func Check(ctx context.Context, payload any) error {
+	err := Validator.Struct(payload)
+	if err == nil {
+		return nil
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+	lang := locale.FromContext(ctx)
+	problem := NewProblem(http.StatusBadRequest, "validation_failed", "failed to validate one or more request body fields")
+	for _, verr := range verrs {
+		problem.Errors = append(problem.Errors, FieldError{
+			Field:   verr.Field(),
+			Code:    verr.Tag(),
+			Message: explainRule(lang, verr.Tag(), verr.Param()),
+		})
+	}
+	return problem
+}
+
+// Sends a response translating its message to the request's locale
+func Response(w http.ResponseWriter, r *http.Request, status int, v any) error {
+	lang := locale.FromContext(r.Context())
+	if body, ok := v.(map[string]interface{}); ok {
+		if message, ok := body["message"].(string); ok {
+			body["message"] = locale.Translate(lang, message)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	// Setting security headers
-	w.Header().Set("Content-Security-Policy", "default-src 'self'")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("X-Frame-Options", "DENY")
-	w.Header().Set("X-XSS-Protection", "1; mode=block")
+	w.Header().Set("Content-Language", lang)
+	securityHeaders(w)
 	// Adding the http status as an header
 	w.WriteHeader(status)
 	// Encoding the payload
 	return json.NewEncoder(w).Encode(v)
 }
 
+// Sets security headers
+func securityHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Security-Policy", "default-src 'self'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("X-XSS-Protection", "1; mode=block")
+}
+
 // Sends a request
 func Request(method string, headers map[string]string, endpoint string, payload any) (*http.Response, error) {
 	// Marshaling the payload
@@ -88,58 +158,114 @@ func Request(method string, headers map[string]string, endpoint string, payload
 	return resp, nil
 }
 
+// Normalizes an email address lowercasing it and turning an internationalized domain into its ascii form
+// so the same mailbox is always stored and looked up the same way(example "User@Bücher.DE" becomes "user@xn--bcher-kva.de")
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", ErrInvalidEmail
+	}
+	domain, err := idna.Lookup.ToASCII(email[at+1:])
+	if err != nil {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(email[:at]) + "@" + strings.ToLower(domain), nil
+}
+
+// Gets the client address without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // Claims the account's id from the request
 func ContextClaimID(r *http.Request) (int, error) {
-	_, claims, err := jwtauth.FromContext(r.Context())
+	return ClaimID(r.Context())
+}
+
+// Claims the account's id from a context carrying a verified token
+func ClaimID(ctx context.Context) (int, error) {
+	_, claims, err := jwtauth.FromContext(ctx)
 	if err != nil {
 		log.Error("failed to get claims", "err", err)
-		return 0, err
+		return 0, ErrInvalidClaims
 	}
 	id, ok := claims["account"].(float64)
 	if !ok {
 		log.Error("account not found in claims or not a float64")
-		return 0, fmt.Errorf("account not found in claims or not a float64")
+		return 0, ErrInvalidClaims
 	}
 	return int(id), nil
 }
 
 // Claims the jwt expiration from the request
 func ContextClaimExpiration(r *http.Request) (time.Time, error) {
-	_, claims, err := jwtauth.FromContext(r.Context())
+	return ClaimExpiration(r.Context())
+}
+
+// Claims the jwt expiration from a context carrying a verified token
+func ClaimExpiration(ctx context.Context) (time.Time, error) {
+	_, claims, err := jwtauth.FromContext(ctx)
 	if err != nil {
 		log.Error("failed to get claims", "err", err)
-		return time.Time{}, err
+		return time.Time{}, ErrInvalidClaims
 	}
 	exp, ok := claims["exp"].(time.Time)
 	if !ok {
 		log.Error("expiration not found in claims or not a float64")
-		return time.Time{}, fmt.Errorf("expiration not found in claims or not a float64")
+		return time.Time{}, ErrInvalidClaims
 	}
 	return exp, nil
 }
 
-// Hashes a string
-func Hash(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// Claims when the jwt was issued in microseconds, falling back to iat's seconds for tokens issued without it
+func ClaimIssued(ctx context.Context) (time.Time, error) {
+	_, claims, err := jwtauth.FromContext(ctx)
 	if err != nil {
-		return "", err
+		log.Error("failed to get claims", "err", err)
+		return time.Time{}, ErrInvalidClaims
 	}
-	return string(hash), nil
-}
-
-// Compares hashed strings with plaintext ones
-func CompareHashedAndPlain(hashed, plain string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plain))
-	return err == nil
+	if issued, ok := claims["issued"].(float64); ok {
+		return time.UnixMicro(int64(issued)), nil
+	}
+	iat, ok := claims["iat"].(time.Time)
+	if !ok {
+		log.Error("issue time not found in claims")
+		return time.Time{}, ErrInvalidClaims
+	}
+	return iat, nil
 }
 
-// Generating a random alphanumeric code
+// Generating a random alphanumeric code with crypto/rand since codes stand in for passwords
 func GenerateRandomCode(lenght int) (string, error) {
 	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
 	code := make([]byte, lenght)
 	for i := range code {
-		code[i] = charset[seededRand.Intn(len(charset))]
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = charset[n.Int64()]
 	}
 	return string(code), nil
 }
+
+// Filesystem reading files from a directory on disk first and from a fallback one if they aren't there
+type OverlayFS struct {
+	Dir      string
+	Fallback fs.FS
+}
+
+func (overlay OverlayFS) Open(name string) (fs.File, error) {
+	if overlay.Dir != "" {
+		file, err := os.DirFS(overlay.Dir).Open(name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return file, err
+		}
+	}
+	return overlay.Fallback.Open(name)
+}